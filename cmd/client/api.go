@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/pkg/apiclient"
+	"github.com/spf13/cobra"
+)
+
+var (
+	apiServer string
+	apiToken  string
+)
+
+// addAPIFlags adds --server and --token to commands that call the API.
+func addAPIFlags(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		cmd.Flags().StringVar(&apiServer, "server", "", "Tunnel server URL (default: the one you logged in to)")
+		cmd.Flags().StringVar(&apiToken, "token", "", "Authentication token (default: stored login)")
+	}
+}
+
+// newAPIClient returns a client for --server and --token, falling back to
+// the stored login.
+func newAPIClient() (*apiclient.Client, error) {
+	creds, err := common.LoadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	server, token := apiServer, apiToken
+	if server == "" {
+		server = DefaultServer
+		if creds != nil {
+			server = creds.Server
+		}
+	}
+	if token == "" && creds != nil && common.SameServerHost(creds.Server, server) {
+		token = creds.Token
+	}
+	if token == "" {
+		return nil, fmt.Errorf("not logged in to %s; run 'gotunnel login' or pass --token", apiclient.BaseURL(server))
+	}
+	return apiclient.New(server, token), nil
+}
+
+// commandContext returns a context cancelled on interrupt.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+var lsCmd = &cobra.Command{
+	Use:          "ls",
+	Short:        "List reserved subdomains and whether they are online",
+	Args:         cobra.NoArgs,
+	RunE:         runList,
+	SilenceUsage: true,
+}
+
+var reserveOrg string
+
+var reserveCmd = &cobra.Command{
+	Use:   "reserve <subdomain>",
+	Short: "Reserve a subdomain",
+	Long: `Reserve a subdomain for yourself, or for an organization with --org.
+
+Examples:
+  gotunnel reserve myapp
+  gotunnel reserve team-api --org acme`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         runReserve,
+	SilenceUsage: true,
+}
+
+var releaseCmd = &cobra.Command{
+	Use:          "release <subdomain>",
+	Short:        "Release a reserved subdomain",
+	Args:         cobra.ExactArgs(1),
+	RunE:         runRelease,
+	SilenceUsage: true,
+}
+
+var (
+	logsFollow   bool
+	logsLimit    int
+	logsInterval time.Duration
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <subdomain>",
+	Short: "Show requests captured for a subdomain",
+	Long: `Show requests captured by the request inspector, oldest first.
+
+Examples:
+  gotunnel logs myapp
+  gotunnel logs myapp --follow`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         runLogs,
+	SilenceUsage: true,
+}
+
+func init() {
+	reserveCmd.Flags().StringVar(&reserveOrg, "org", "", "Reserve for this organization (ID or slug)")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep printing new requests")
+	logsCmd.Flags().IntVarP(&logsLimit, "limit", "n", 20, "Number of recent requests to show (max 100)")
+	logsCmd.Flags().DurationVar(&logsInterval, "interval", 2*time.Second, "Polling interval with --follow")
+
+	addAPIFlags(lsCmd, reserveCmd, releaseCmd, logsCmd)
+	rootCmd.AddCommand(lsCmd, reserveCmd, releaseCmd, logsCmd)
+}
+
+func runList(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	tunnels, err := api.ListTunnels(ctx)
+	if err != nil {
+		return err
+	}
+	if len(tunnels) == 0 {
+		fmt.Println("No reserved subdomains; reserve one with 'gotunnel reserve <subdomain>'")
+		return nil
+	}
+	return printTunnels(tunnels)
+}
+
+// printTunnels prints tunnels as a table.
+func printTunnels(tunnels []apiclient.Tunnel) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBDOMAIN\tSTATUS\tURL")
+	for _, t := range tunnels {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.Subdomain, t.Status, t.URL)
+	}
+	return w.Flush()
+}
+
+func runReserve(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	subdomain := strings.ToLower(args[0])
+	if reserveOrg != "" {
+		org, err := resolveOrganization(ctx, api, reserveOrg)
+		if err != nil {
+			return err
+		}
+		if err := api.ReserveOrganizationSubdomain(ctx, org.ID, subdomain); err != nil {
+			return err
+		}
+		fmt.Printf("Reserved %s for %s\n", subdomain, org.Slug)
+		return nil
+	}
+
+	if err := api.Reserve(ctx, subdomain); err != nil {
+		return err
+	}
+	fmt.Printf("Reserved %s\n", subdomain)
+	return nil
+}
+
+func runRelease(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	subdomain := strings.ToLower(args[0])
+	if err := api.Release(ctx, subdomain); err != nil {
+		return err
+	}
+	fmt.Printf("Released %s\n", subdomain)
+	return nil
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	subdomain := strings.ToLower(args[0])
+	logs, err := api.RequestLogs(ctx, subdomain, logsLimit, 0)
+	if err != nil {
+		return err
+	}
+
+	// Logs arrive newest first. Remember the newest timestamp, and the IDs
+	// seen at it, so polling prints each request once.
+	var last time.Time
+	seen := map[string]bool{}
+	print := func(logs []apiclient.RequestLog) {
+		for i := len(logs) - 1; i >= 0; i-- {
+			log := logs[i]
+			if log.CreatedAt.Before(last) || log.CreatedAt.Equal(last) && seen[log.ID] {
+				continue
+			}
+			if log.CreatedAt.After(last) {
+				last = log.CreatedAt
+				seen = map[string]bool{}
+			}
+			seen[log.ID] = true
+			fmt.Printf("%s  %-7s %3d %6dms  %s\n",
+				log.CreatedAt.Local().Format("15:04:05"), log.Method, log.StatusCode, log.DurationMs, log.Path)
+		}
+	}
+	print(logs)
+
+	if !logsFollow {
+		return nil
+	}
+
+	ticker := time.NewTicker(logsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			logs, err := api.RequestLogs(ctx, subdomain, 100, 0)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			print(logs)
+		}
+	}
+}
+
+// resolveOrganization finds one of the caller's organizations by ID or slug.
+func resolveOrganization(ctx context.Context, api *apiclient.Client, idOrSlug string) (*apiclient.Organization, error) {
+	orgs, err := api.ListOrganizations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orgs {
+		if orgs[i].ID == idOrSlug || orgs[i].Slug == idOrSlug {
+			return &orgs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("you are not a member of an organization %q", idOrSlug)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/client"
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonConfig   string
+	daemonLogLevel string
+	daemonSocket   string
+	systemdInstall bool
+	statusJSON     bool
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [names...]",
+	Short: "Run tunnels in the background with a control socket",
+	Long: `Run tunnels from a config file over one connection and accept commands
+on a Unix socket. 'gotunnel up', 'down', 'status' and 'reload' talk to the
+daemon, adding and removing tunnels without reconnecting.
+
+With no names, every tunnel in the file is started. The socket is created
+in $XDG_RUNTIME_DIR, or the user config directory, unless --socket or
+$GOTUNNEL_SOCKET says otherwise.
+
+Examples:
+  gotunnel daemon --config tunnel.yaml     # Start every tunnel in the file
+  gotunnel daemon --config tunnel.yaml web # Start only web; add others with 'up'
+  gotunnel daemon systemd --install        # Run the daemon as a systemd user service`,
+	RunE:         runDaemon,
+	SilenceUsage: true,
+}
+
+var daemonSystemdCmd = &cobra.Command{
+	Use:   "systemd",
+	Short: "Print or install a systemd user unit for the daemon",
+	Long: `Print a systemd user unit that runs 'gotunnel daemon' with the given
+--config and --socket. With --install it is written to
+~/.config/systemd/user/gotunnel.service instead.`,
+	Args:         cobra.NoArgs,
+	RunE:         runDaemonSystemd,
+	SilenceUsage: true,
+}
+
+var upCmd = &cobra.Command{
+	Use:          "up <name>",
+	Short:        "Start a tunnel from the daemon's config file",
+	Args:         cobra.ExactArgs(1),
+	RunE:         runUp,
+	SilenceUsage: true,
+}
+
+var downCmd = &cobra.Command{
+	Use:          "down <name>",
+	Short:        "Stop a tunnel served by the daemon",
+	Args:         cobra.ExactArgs(1),
+	RunE:         runDown,
+	SilenceUsage: true,
+}
+
+var statusCmd = &cobra.Command{
+	Use:          "status",
+	Short:        "Show the daemon's connection, tunnels and connection pools",
+	Args:         cobra.NoArgs,
+	RunE:         runStatus,
+	SilenceUsage: true,
+}
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Make the daemon re-read its config file",
+	Long: `Make the daemon re-read its config file. Running tunnels that changed
+are re-registered and ones removed from the file are stopped. Tunnels that
+are not running are left alone; start them with 'gotunnel up'.`,
+	Args:         cobra.NoArgs,
+	RunE:         runReload,
+	SilenceUsage: true,
+}
+
+func init() {
+	daemonCmd.PersistentFlags().StringVarP(&daemonConfig, "config", "c", "tunnel.yaml", "Path to configuration file")
+	daemonCmd.Flags().StringVarP(&daemonLogLevel, "log-level", "l", "", "Log level: debug, info, warn, error (overrides log_level)")
+	daemonSystemdCmd.Flags().BoolVar(&systemdInstall, "install", false, "Write the unit to the systemd user directory")
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the raw status as JSON")
+
+	daemonCmd.PersistentFlags().StringVar(&daemonSocket, "socket", "", "Control socket path (default: $GOTUNNEL_SOCKET or the runtime directory)")
+	for _, cmd := range []*cobra.Command{upCmd, downCmd, statusCmd, reloadCmd} {
+		cmd.Flags().StringVar(&daemonSocket, "socket", "", "Control socket path (default: $GOTUNNEL_SOCKET or the runtime directory)")
+	}
+
+	daemonCmd.AddCommand(daemonSystemdCmd)
+	rootCmd.AddCommand(daemonCmd, upCmd, downCmd, statusCmd, reloadCmd)
+}
+
+// socketPath returns --socket or the default control socket.
+func socketPath() (string, error) {
+	if daemonSocket != "" {
+		return daemonSocket, nil
+	}
+	return client.DaemonSocketPath()
+}
+
+func runDaemon(cmd *cobra.Command, args []string) error {
+	configPath, err := filepath.Abs(daemonConfig)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path: %w", err)
+	}
+	cfg, err := common.LoadClientConfig(configPath)
+	if err != nil {
+		return err
+	}
+	socket, err := socketPath()
+	if err != nil {
+		return err
+	}
+
+	// The tunnel gets its own copy; the daemon keeps the whole file for 'up'
+	tunnelCfg := *cfg
+	tunnelCfg.Tunnels = append([]protocol.TunnelConfig(nil), cfg.Tunnels...)
+	if err := tunnelCfg.SelectTunnels(args...); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("log-level") {
+		tunnelCfg.LogLevel = daemonLogLevel
+	}
+
+	logger := newLogger(tunnelCfg.LogLevel)
+	tunnel, err := client.NewTunnel(&tunnelCfg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel: %w", err)
+	}
+
+	daemon := client.NewDaemon(tunnel, configPath, cfg, logger)
+	if err := daemon.Start(socket); err != nil {
+		return err
+	}
+	defer daemon.Stop(5 * time.Second)
+
+	return tunnel.Run()
+}
+
+func runDaemonSystemd(cmd *cobra.Command, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the gotunnel binary: %w", err)
+	}
+	configPath, err := filepath.Abs(daemonConfig)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path: %w", err)
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	unit := systemdUnit(executable, configPath, daemonSocket)
+	if !systemdInstall {
+		fmt.Print(unit)
+		return nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return fmt.Errorf("failed to find config directory: %w", err)
+	}
+	path := filepath.Join(dir, "systemd", "user", "gotunnel.service")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create unit directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(unit), 0o644); err != nil {
+		return fmt.Errorf("failed to write unit: %w", err)
+	}
+
+	fmt.Printf("Wrote %s\n\n", path)
+	fmt.Println("Start it now and at login with:")
+	fmt.Println("  systemctl --user daemon-reload")
+	fmt.Println("  systemctl --user enable --now gotunnel")
+	return nil
+}
+
+// systemdUnit returns a systemd user unit running the daemon. Reloading the
+// unit reloads the daemon's config file.
+func systemdUnit(executable, configPath, socket string) string {
+	args := "--config " + systemdQuote(configPath)
+	ctlArgs := ""
+	if socket != "" {
+		args += " --socket " + systemdQuote(socket)
+		ctlArgs = " --socket " + systemdQuote(socket)
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=gotunnel daemon\n")
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=simple\n")
+	// The daemon falls back to the login stored by 'gotunnel login'
+	if path := os.Getenv(common.CredentialsEnv); path != "" {
+		fmt.Fprintf(&b, "Environment=%s=%s\n", common.CredentialsEnv, systemdQuote(path))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s daemon %s\n", systemdQuote(executable), args)
+	fmt.Fprintf(&b, "ExecReload=%s reload%s\n", systemdQuote(executable), ctlArgs)
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+	return b.String()
+}
+
+// systemdQuote quotes a unit file argument if it contains spaces or quotes.
+func systemdQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// newDaemonClient returns a client for the daemon's control socket.
+func newDaemonClient() (*client.DaemonClient, error) {
+	socket, err := socketPath()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewDaemonClient(socket), nil
+}
+
+func runUp(cmd *cobra.Command, args []string) error {
+	daemon, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	status, err := daemon.Up(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	switch status.Status {
+	case "active":
+		fmt.Printf("Started %s → %s\n", args[0], status.URL)
+	default:
+		fmt.Printf("Started %s; it will be registered when the daemon reconnects\n", args[0])
+	}
+	return nil
+}
+
+func runDown(cmd *cobra.Command, args []string) error {
+	daemon, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := daemon.Down(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Stopped %s\n", args[0])
+	return nil
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	daemon, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	status, err := daemon.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if statusJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+	return printDaemonStatus(status)
+}
+
+// printDaemonStatus prints the connection, its tunnels and connection pools.
+func printDaemonStatus(status *client.DaemonStatus) error {
+	fmt.Printf("State:   %s\n", status.State)
+	if status.SessionID != "" {
+		fmt.Printf("Session: %s\n", status.SessionID)
+	}
+	fmt.Printf("Server:  %s\n", status.ServerAddr)
+	fmt.Printf("Config:  %s\n", status.ConfigPath)
+	if status.ReconnectAttempts > 0 {
+		fmt.Printf("Reconnect attempts: %d\n", status.ReconnectAttempts)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tURL\tLOCAL")
+	for _, t := range status.Tunnels {
+		target := t.URL
+		if t.Error != "" {
+			target = t.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Name, t.Status, target, t.LocalAddr)
+	}
+	for _, name := range status.Available {
+		fmt.Fprintf(w, "%s\t%s\t\t\n", name, "stopped")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(status.Pools) == 0 {
+		return nil
+	}
+	addrs := make([]string, 0, len(status.Pools))
+	for addr := range status.Pools {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tOPEN\tIDLE\tWAITS\tDIALED\tREUSED")
+	for _, addr := range addrs {
+		p := status.Pools[addr]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", p.Address, p.OpenConns, p.IdleConns, p.WaitCount, p.TotalConns, p.TotalReused)
+	}
+	return w.Flush()
+}
+
+func runReload(cmd *cobra.Command, args []string) error {
+	daemon, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	result, err := daemon.Reload(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Reloaded config")
+	for _, name := range result.Updated {
+		fmt.Printf("  updated %s\n", name)
+	}
+	for _, name := range result.Stopped {
+		fmt.Printf("  stopped %s\n", name)
+	}
+	failed := make([]string, 0, len(result.Failed))
+	for name := range result.Failed {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	for _, name := range failed {
+		fmt.Printf("  failed  %s: %s\n", name, result.Failed[name])
+	}
+	for _, note := range result.Notes {
+		fmt.Printf("Note: %s\n", note)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d tunnel(s) could not be reloaded", len(failed))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/pkg/apiclient"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	loginServer string
+	loginEmail  string
+	loginToken  string
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in and store credentials for later runs",
+	Long: `Log in with your email and password, or store a personal token.
+
+Credentials are saved with 0600 permissions in the user config directory
+(override with $GOTUNNEL_CREDENTIALS). Tunnels to the same server use the
+stored token when neither --token nor a config file token is given.
+
+Examples:
+  gotunnel login                                   # Prompt for email and password
+  gotunnel login --server https://tunnel.example.com
+  gotunnel login --token <token>                   # Store a personal token
+  echo "$TOKEN" | gotunnel login --token -         # Read the token from stdin`,
+	Args:         cobra.NoArgs,
+	RunE:         runLogin,
+	SilenceUsage: true,
+}
+
+var logoutCmd = &cobra.Command{
+	Use:          "logout",
+	Short:        "Remove stored credentials",
+	Args:         cobra.NoArgs,
+	RunE:         runLogout,
+	SilenceUsage: true,
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:          "whoami",
+	Short:        "Show the logged-in user",
+	Args:         cobra.NoArgs,
+	RunE:         runWhoami,
+	SilenceUsage: true,
+}
+
+func init() {
+	loginCmd.Flags().StringVar(&loginServer, "server", DefaultServer, "Tunnel server URL")
+	loginCmd.Flags().StringVar(&loginEmail, "email", "", "Account email (prompted if empty)")
+	loginCmd.Flags().StringVar(&loginToken, "token", "", "Personal token to store instead of logging in ('-' reads stdin)")
+
+	rootCmd.AddCommand(loginCmd, logoutCmd, whoamiCmd)
+}
+
+func runLogin(cmd *cobra.Command, args []string) error {
+	api := apiclient.New(loginServer, "")
+	stdin := bufio.NewReader(os.Stdin)
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	token := loginToken
+	if token == "-" {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read token: %w", err)
+		}
+		token = strings.TrimSpace(line)
+	}
+
+	if token == "" {
+		email := loginEmail
+		if email == "" {
+			fmt.Print("Email: ")
+			line, err := stdin.ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("failed to read email: %w", err)
+			}
+			email = strings.TrimSpace(line)
+		}
+		password, err := readPassword(stdin)
+		if err != nil {
+			return err
+		}
+
+		result, err := api.Login(ctx, email, password)
+		if apiclient.IsStatus(err, http.StatusUnauthorized) {
+			return fmt.Errorf("invalid email or password")
+		}
+		if err != nil {
+			return err
+		}
+		token = result.Token
+	}
+
+	// Confirm the token works before storing it
+	api.Token = token
+	acct, err := fetchAccount(ctx, api)
+	if err != nil {
+		return err
+	}
+
+	creds := &common.Credentials{
+		Server: api.BaseURL,
+		Token:  token,
+		UserID: acct.ID,
+		Email:  acct.Email,
+	}
+	if err := common.SaveCredentials(creds); err != nil {
+		return err
+	}
+	path, _ := common.CredentialsPath()
+	fmt.Printf("Logged in to %s as %s\n", api.BaseURL, acct.Email)
+	fmt.Printf("Credentials saved to %s\n", path)
+	return nil
+}
+
+func runLogout(cmd *cobra.Command, args []string) error {
+	if err := common.RemoveCredentials(); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Not logged in")
+			return nil
+		}
+		return err
+	}
+	fmt.Println("Logged out")
+	return nil
+}
+
+func runWhoami(cmd *cobra.Command, args []string) error {
+	creds, err := common.LoadCredentials()
+	if err != nil {
+		return err
+	}
+	if creds == nil {
+		return fmt.Errorf("not logged in; run 'gotunnel login'")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	acct, err := fetchAccount(ctx, apiclient.New(creds.Server, creds.Token))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Email:   %s\n", acct.Email)
+	fmt.Printf("User ID: %s\n", acct.ID)
+	fmt.Printf("Server:  %s\n", creds.Server)
+	if acct.IsAdmin {
+		fmt.Println("Role:    server admin")
+	}
+	return nil
+}
+
+// readPassword prompts for a password without echo on a terminal, or reads
+// a line from stdin otherwise.
+func readPassword(stdin *bufio.Reader) (string, error) {
+	fmt.Print("Password: ")
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return string(password), nil
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// fetchAccount returns the user a client's token belongs to.
+func fetchAccount(ctx context.Context, api *apiclient.Client) (*apiclient.User, error) {
+	user, err := api.Me(ctx)
+	if apiclient.IsStatus(err, http.StatusUnauthorized) {
+		return nil, fmt.Errorf("token is not valid on %s; run 'gotunnel login'", api.BaseURL)
+	}
+	return user, err
+}
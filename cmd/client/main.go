@@ -30,6 +30,9 @@ var (
 	password   string
 	basicAuth  string
 	inspect    bool
+	localAddr  string
+	token      string
+	proxyURL   string
 )
 
 func main() {
@@ -55,7 +58,8 @@ Examples:
   gotunnel 3000 --inspect            # Show live HTTP requests
   gotunnel 3000 --qr                 # Show QR code for mobile
   gotunnel 3000 --urls 5             # Generate 5 URLs
-  gotunnel 3000 --password secret    # Password protect the tunnel`,
+  gotunnel 3000 --password secret    # Password protect the tunnel
+  gotunnel start --config tunnel.yaml # Run the tunnels in a config file`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runTunnel,
 }
@@ -68,6 +72,9 @@ func init() {
 	rootCmd.Flags().StringVar(&password, "password", "", "Password protect the tunnel")
 	rootCmd.Flags().StringVar(&basicAuth, "auth", "", "Basic auth (user:pass)")
 	rootCmd.Flags().BoolVar(&inspect, "inspect", false, "Show live HTTP request log")
+	rootCmd.Flags().StringVar(&localAddr, "local-addr", "", "Serve /metrics and /status on this address (e.g. 127.0.0.1:4040)")
+	rootCmd.Flags().StringVar(&token, "token", "", "Authentication token (default: stored login, else anonymous)")
+	rootCmd.Flags().StringVar(&proxyURL, "proxy", "", "Connect through an HTTP or SOCKS5 proxy (default: HTTPS_PROXY)")
 }
 
 func runTunnel(cmd *cobra.Command, args []string) error {
@@ -96,7 +103,18 @@ func runTunnel(cmd *cobra.Command, args []string) error {
 	// Build client config
 	cfg := common.DefaultClientConfig()
 	cfg.ServerAddr = server
-	cfg.Token = "public"
+	cfg.Token = token
+	cfg.ProxyURL = proxyURL
+	if err := cfg.UseStoredToken(); err != nil {
+		return err
+	}
+	if cfg.Token == "" {
+		cfg.Token = "public"
+	}
+	if localAddr != "" {
+		cfg.LocalServer.Enabled = true
+		cfg.LocalServer.Addr = localAddr
+	}
 
 	for _, sub := range subdomains {
 		tunnelCfg := protocol.TunnelConfig{
@@ -168,33 +186,38 @@ func runTunnel(cmd *cobra.Command, args []string) error {
 
 	// Register request handler for --inspect mode
 	if inspect {
-		var requestCount atomic.Int64
-		fmt.Println("  Request Log:")
-		fmt.Println("  " + strings.Repeat("─", 70))
-
-		tunnel.OnRequest(func(info client.RequestInfo) {
-			count := requestCount.Add(1)
-			method := info.Method
-			if method == "" {
-				method = "???"
-			}
-			path := info.Path
-			if path == "" {
-				path = "/"
-			}
-			// Truncate path if too long
-			if len(path) > 40 {
-				path = path[:37] + "..."
-			}
-
-			timestamp := info.Timestamp.Format("15:04:05")
-			fmt.Printf("  %s  [%d] %-7s %s\n", timestamp, count, method, path)
-		})
+		logRequests(tunnel)
 	}
 
 	return tunnel.Run()
 }
 
+// logRequests prints a line for each request the tunnel forwards.
+func logRequests(tunnel *client.Tunnel) {
+	var requestCount atomic.Int64
+	fmt.Println("  Request Log:")
+	fmt.Println("  " + strings.Repeat("─", 70))
+
+	tunnel.OnRequest(func(info client.RequestInfo) {
+		count := requestCount.Add(1)
+		method := info.Method
+		if method == "" {
+			method = "???"
+		}
+		path := info.Path
+		if path == "" {
+			path = "/"
+		}
+		// Truncate path if too long
+		if len(path) > 40 {
+			path = path[:37] + "..."
+		}
+
+		timestamp := info.Timestamp.Format("15:04:05")
+		fmt.Printf("  %s  [%d] %-7s %s\n", timestamp, count, method, path)
+	})
+}
+
 func generateSubdomain() string {
 	bytes := make([]byte, 4)
 	rand.Read(bytes)
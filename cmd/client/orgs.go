@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var orgsCmd = &cobra.Command{
+	Use:   "orgs",
+	Short: "Manage organizations",
+	Long: `Manage organizations. Organizations are named by ID or slug.
+
+Examples:
+  gotunnel orgs ls
+  gotunnel orgs create "Acme Inc" acme
+  gotunnel orgs members acme
+  gotunnel orgs subdomains acme
+  gotunnel orgs invite acme dev@example.com --role developer`,
+	Args:         cobra.NoArgs,
+	RunE:         runOrgsList,
+	SilenceUsage: true,
+}
+
+var orgsListCmd = &cobra.Command{
+	Use:          "ls",
+	Short:        "List your organizations",
+	Args:         cobra.NoArgs,
+	RunE:         runOrgsList,
+	SilenceUsage: true,
+}
+
+var orgsCreateCmd = &cobra.Command{
+	Use:          "create <name> <slug>",
+	Short:        "Create an organization",
+	Args:         cobra.ExactArgs(2),
+	RunE:         runOrgsCreate,
+	SilenceUsage: true,
+}
+
+var orgsMembersCmd = &cobra.Command{
+	Use:          "members <org>",
+	Short:        "List an organization's members",
+	Args:         cobra.ExactArgs(1),
+	RunE:         runOrgsMembers,
+	SilenceUsage: true,
+}
+
+var orgsSubdomainsCmd = &cobra.Command{
+	Use:          "subdomains <org>",
+	Short:        "List an organization's subdomains",
+	Args:         cobra.ExactArgs(1),
+	RunE:         runOrgsSubdomains,
+	SilenceUsage: true,
+}
+
+var inviteRole string
+
+var orgsInviteCmd = &cobra.Command{
+	Use:          "invite <org> <email>",
+	Short:        "Invite an email address to an organization",
+	Args:         cobra.ExactArgs(2),
+	RunE:         runOrgsInvite,
+	SilenceUsage: true,
+}
+
+func init() {
+	orgsInviteCmd.Flags().StringVar(&inviteRole, "role", "developer", "Role: owner, admin, developer or viewer")
+
+	addAPIFlags(orgsCmd, orgsListCmd, orgsCreateCmd, orgsMembersCmd, orgsSubdomainsCmd, orgsInviteCmd)
+	orgsCmd.AddCommand(orgsListCmd, orgsCreateCmd, orgsMembersCmd, orgsSubdomainsCmd, orgsInviteCmd)
+	rootCmd.AddCommand(orgsCmd)
+}
+
+func runOrgsList(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	orgs, err := api.ListOrganizations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(orgs) == 0 {
+		fmt.Println("You are not a member of any organization")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SLUG\tNAME\tID")
+	for _, org := range orgs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", org.Slug, org.Name, org.ID)
+	}
+	return w.Flush()
+}
+
+func runOrgsCreate(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	org, err := api.CreateOrganization(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s (%s)\n", org.Slug, org.ID)
+	return nil
+}
+
+func runOrgsMembers(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	org, err := resolveOrganization(ctx, api, args[0])
+	if err != nil {
+		return err
+	}
+	members, err := api.OrganizationMembers(ctx, org.ID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER ID\tROLE\tJOINED")
+	for _, m := range members {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.UserID, m.Role, m.CreatedAt.Local().Format("2006-01-02"))
+	}
+	return w.Flush()
+}
+
+func runOrgsSubdomains(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	org, err := resolveOrganization(ctx, api, args[0])
+	if err != nil {
+		return err
+	}
+	tunnels, err := api.OrganizationSubdomains(ctx, org.ID)
+	if err != nil {
+		return err
+	}
+	if len(tunnels) == 0 {
+		fmt.Printf("%s has no reserved subdomains\n", org.Slug)
+		return nil
+	}
+	return printTunnels(tunnels)
+}
+
+func runOrgsInvite(cmd *cobra.Command, args []string) error {
+	api, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	org, err := resolveOrganization(ctx, api, args[0])
+	if err != nil {
+		return err
+	}
+	result, err := api.CreateInvite(ctx, org.ID, args[1], inviteRole)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result.EmailSent:
+		fmt.Printf("Invited %s to %s as %s\n", result.Invite.Email, org.Slug, result.Invite.Role)
+	case result.EmailError != "":
+		fmt.Printf("Invited %s to %s, but the email failed: %s\n", result.Invite.Email, org.Slug, result.EmailError)
+	default:
+		fmt.Printf("Invited %s to %s as %s (no email was sent)\n", result.Invite.Email, org.Slug, result.Invite.Role)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/anyhost/gotunnel/internal/client"
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	startConfig    string
+	startServer    string
+	startToken     string
+	startClientID  string
+	startLocalAddr string
+	startLogLevel  string
+	startInspect   bool
+)
+
+var startCmd = &cobra.Command{
+	Use:   "start [names...]",
+	Short: "Run tunnels from a config file",
+	Long: `Run the tunnels defined in a YAML config file over a single connection.
+
+Tunnels are selected by name, or by subdomain for tunnels without a name.
+With no names, every tunnel in the file is started. Environment variables
+such as ${TUNNEL_TOKEN} are expanded in the file, and flags override it.
+Without a token in either, the one stored by 'gotunnel login' is used.
+
+Examples:
+  gotunnel start                           # All tunnels in tunnel.yaml
+  gotunnel start --config dev.yaml web api # Only the web and api tunnels
+  gotunnel start --token $TOKEN            # Override the file's token`,
+	RunE:         runStart,
+	SilenceUsage: true,
+}
+
+func init() {
+	startCmd.Flags().StringVarP(&startConfig, "config", "c", "tunnel.yaml", "Path to configuration file")
+	startCmd.Flags().StringVar(&startServer, "server", "", "Tunnel server URL (overrides server_addr and server_addrs)")
+	startCmd.Flags().StringVar(&startToken, "token", "", "Authentication token (overrides token)")
+	startCmd.Flags().StringVar(&startClientID, "client-id", "", "Client identifier (overrides client_id)")
+	startCmd.Flags().StringVar(&startLocalAddr, "local-addr", "", "Serve /metrics and /status on this address (overrides local_server)")
+	startCmd.Flags().StringVarP(&startLogLevel, "log-level", "l", "", "Log level: debug, info, warn, error (overrides log_level)")
+	startCmd.Flags().BoolVar(&startInspect, "inspect", false, "Show live HTTP request log")
+
+	rootCmd.AddCommand(startCmd)
+}
+
+func runStart(cmd *cobra.Command, args []string) error {
+	cfg, err := common.ReadClientConfig(startConfig)
+	if err != nil {
+		return err
+	}
+
+	// Flags take precedence over the file
+	flags := cmd.Flags()
+	if flags.Changed("server") {
+		cfg.ServerAddr = startServer
+		cfg.ServerAddrs = []string{startServer}
+	}
+	if flags.Changed("token") {
+		cfg.Token = startToken
+	}
+	if flags.Changed("client-id") {
+		cfg.ClientID = startClientID
+	}
+	if flags.Changed("local-addr") {
+		cfg.LocalServer.Enabled = startLocalAddr != ""
+		cfg.LocalServer.Addr = startLocalAddr
+	}
+	if flags.Changed("log-level") {
+		cfg.LogLevel = startLogLevel
+	}
+
+	if err := cfg.UseStoredToken(); err != nil {
+		return err
+	}
+	if err := cfg.SelectTunnels(args...); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	tunnel, err := client.NewTunnel(cfg, newLogger(cfg.LogLevel))
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel: %w", err)
+	}
+
+	// Show the public URLs whenever the server (re)accepts the tunnels
+	tunnel.OnStateChange(func(state client.TunnelState) {
+		if state == client.TunnelStateConnected {
+			printTunnelStatus(cfg.Tunnels, tunnel.GetTunnelStatus())
+		}
+	})
+
+	if startInspect {
+		logRequests(tunnel)
+	}
+
+	return tunnel.Run()
+}
+
+// printTunnelStatus prints each configured tunnel with its public URL or
+// the reason the server rejected it.
+func printTunnelStatus(tunnels []protocol.TunnelConfig, statuses []protocol.TunnelStatus) {
+	bySubdomain := make(map[string]protocol.TunnelStatus, len(statuses))
+	for _, status := range statuses {
+		bySubdomain[status.Subdomain] = status
+	}
+
+	printHeader()
+	for _, tunnel := range tunnels {
+		name := tunnel.Name
+		if name == "" {
+			name = tunnel.Subdomain
+		}
+		local := fmt.Sprintf("%s://%s:%d", tunnel.Protocol, tunnel.LocalHost, tunnel.LocalPort)
+
+		status, ok := bySubdomain[tunnel.Subdomain]
+		switch {
+		case !ok:
+			fmt.Printf("  │ %-12s %s (no response)\n", name, local)
+		case status.Status == "active":
+			fmt.Printf("  │ %-12s %s → %s\n", name, status.URL, local)
+		default:
+			fmt.Printf("  │ %-12s failed: %s\n", name, status.Error)
+		}
+	}
+	printFooter()
+}
+
+// newLogger returns a text logger on stderr at the given level.
+func newLogger(level string) *slog.Logger {
+	var logLevel slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		logLevel = slog.LevelDebug
+	case "warn":
+		logLevel = slog.LevelWarn
+	case "error":
+		logLevel = slog.LevelError
+	default:
+		logLevel = slog.LevelInfo
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/anyhost/gotunnel/internal/cli"
 	"github.com/anyhost/gotunnel/internal/common"
 	"github.com/anyhost/gotunnel/internal/server"
 	"github.com/spf13/cobra"
@@ -41,6 +42,9 @@ func init() {
 	rootCmd.Flags().StringVarP(&domain, "domain", "d", "localhost", "Base domain for subdomains")
 	rootCmd.Flags().StringVar(&controlAddr, "control-addr", ":9000", "Address for client connections")
 	rootCmd.Flags().StringVar(&httpAddr, "http-addr", ":8080", "Address for HTTP traffic")
+
+	rootCmd.AddCommand(cli.NewMigrateCommand())
+	rootCmd.AddCommand(cli.NewAdminCommand())
 }
 
 func runServer(cmd *cobra.Command, args []string) error {
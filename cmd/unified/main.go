@@ -10,6 +10,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/anyhost/gotunnel/internal/cli"
 	"github.com/anyhost/gotunnel/internal/common"
 	"github.com/anyhost/gotunnel/internal/server"
 	"github.com/spf13/cobra"
@@ -48,6 +49,9 @@ func init() {
 	rootCmd.Flags().StringVarP(&domain, "domain", "d", "", "Base domain for subdomains")
 	rootCmd.Flags().StringVar(&addr, "addr", "", "Address to listen on (e.g., :8080)")
 	rootCmd.Flags().StringVar(&port, "port", "", "Port to listen on (alternative to --addr)")
+
+	rootCmd.AddCommand(cli.NewMigrateCommand())
+	rootCmd.AddCommand(cli.NewAdminCommand())
 }
 
 func runServer(cmd *cobra.Command, args []string) error {
@@ -85,6 +89,13 @@ func runServer(cmd *cobra.Command, args []string) error {
 		cfg.HTTPAddr = ":" + envPort
 	}
 
+	if envDBPath := os.Getenv("DATABASE_PATH"); envDBPath != "" {
+		cfg.DatabasePath = envDBPath
+	}
+	if envDBURL := os.Getenv("DATABASE_URL"); envDBURL != "" {
+		cfg.DatabaseURL = envDBURL
+	}
+
 	cfg.LogLevel = logLevel
 
 	// For unified mode, we don't use the control addr separately
@@ -127,6 +138,11 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Metrics get their own listener, away from tenant traffic
+	if err := srv.StartMetrics(); err != nil {
+		return fmt.Errorf("failed to start metrics server: %w", err)
+	}
+
 	// Create HTTP server with unified handler
 	httpServer := &http.Server{
 		Addr:              cfg.HTTPAddr,
@@ -157,7 +173,10 @@ func runServer(cmd *cobra.Command, args []string) error {
 	<-sigCh
 
 	logger.Info("shutting down...")
-	return httpServer.Close()
+	if err := httpServer.Close(); err != nil {
+		return err
+	}
+	return srv.Close()
 }
 
 func setupLogger(level string) *slog.Logger {
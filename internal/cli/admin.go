@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// NewAdminCommand returns the "admin" command, which grants and revokes
+// server-wide admin rights. Admins may use the /api/admin endpoints.
+func NewAdminCommand() *cobra.Command {
+	var configFile, dsn string
+
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Manage server administrators",
+	}
+	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Path to configuration file")
+	cmd.PersistentFlags().StringVar(&dsn, "database", "", "Database path or postgres:// URL (overrides config)")
+
+	setAdmin := func(email string, isAdmin bool) error {
+		db, err := openDatabase(configFile, dsn, database.Options{})
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if err := db.SetUserAdmin(email, isAdmin); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no user with email %s", email)
+			}
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return nil
+	}
+
+	grantCmd := &cobra.Command{
+		Use:   "grant <email>",
+		Short: "Make a user a server administrator",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setAdmin(args[0], true); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s is now an administrator\n", args[0])
+			return nil
+		},
+	}
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke <email>",
+		Short: "Remove a user's administrator rights",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setAdmin(args[0], false); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s is no longer an administrator\n", args[0])
+			return nil
+		},
+	}
+
+	cmd.AddCommand(grantCmd, revokeCmd)
+	return cmd
+}
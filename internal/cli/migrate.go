@@ -0,0 +1,150 @@
+// Package cli holds cobra commands shared by the server binaries.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// openDatabase opens the database named by dsn, then the DATABASE_URL and
+// DATABASE_PATH environment variables, then the config file.
+func openDatabase(configFile, dsn string, opts database.Options) (*database.DB, error) {
+	cfg := common.DefaultServerConfig()
+	if configFile != "" {
+		loaded, err := common.LoadServerConfig(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		cfg = loaded
+	}
+	if env := os.Getenv("DATABASE_PATH"); env != "" {
+		cfg.DatabasePath = env
+	}
+	if env := os.Getenv("DATABASE_URL"); env != "" {
+		cfg.DatabaseURL = env
+	}
+	if dsn != "" {
+		cfg.DatabaseURL = dsn
+	}
+
+	db, err := database.Open(cfg.DatabaseDSN(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// NewMigrateCommand returns the "migrate" command with up, down and status
+// subcommands. The database is taken from --database, then the DATABASE_URL
+// and DATABASE_PATH environment variables, then the config file.
+func NewMigrateCommand() *cobra.Command {
+	var configFile, dsn string
+
+	open := func() (*database.DB, error) {
+		return openDatabase(configFile, dsn, database.Options{SkipMigrations: true})
+	}
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage database schema migrations",
+	}
+	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Path to configuration file")
+	cmd.PersistentFlags().StringVar(&dsn, "database", "", "Database path or postgres:// URL (overrides config)")
+
+	var target int
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := open()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			applied, err := db.MigrateUp(target)
+			for _, m := range applied {
+				fmt.Fprintf(cmd.OutOrStdout(), "applied %d_%s\n", m.Version, m.Name)
+			}
+			if err != nil {
+				return err
+			}
+			if len(applied) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "schema is up to date")
+			}
+			return nil
+		},
+	}
+	upCmd.Flags().IntVar(&target, "to", 0, "Migrate up to this version (default: latest)")
+
+	var steps int
+	downCmd := &cobra.Command{
+		Use:   "down",
+		Short: "Revert the most recent migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if steps < 1 {
+				return fmt.Errorf("--steps must be at least 1")
+			}
+			db, err := open()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			reverted, err := db.MigrateDown(steps)
+			for _, m := range reverted {
+				fmt.Fprintf(cmd.OutOrStdout(), "reverted %d_%s\n", m.Version, m.Name)
+			}
+			if err != nil {
+				return err
+			}
+			if len(reverted) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "no migrations to revert")
+			}
+			return nil
+		},
+	}
+	downCmd.Flags().IntVar(&steps, "steps", 1, "Number of migrations to revert")
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show applied and pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := open()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			states, err := db.MigrationStatus()
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+			for _, s := range states {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+				}
+				if s.Version > database.LatestSchemaVersion() {
+					applied += " (unknown to this build)"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.AddCommand(upCmd, downCmd, statusCmd)
+	return cmd
+}
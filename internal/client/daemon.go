@@ -0,0 +1,458 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// DaemonSocketEnv overrides where the daemon's control socket is created.
+const DaemonSocketEnv = "GOTUNNEL_SOCKET"
+
+// DaemonSocketPath returns the daemon's control socket: $GOTUNNEL_SOCKET,
+// gotunnel.sock in $XDG_RUNTIME_DIR, or gotunnel/daemon.sock in the user's
+// config directory.
+func DaemonSocketPath() (string, error) {
+	if path := os.Getenv(DaemonSocketEnv); path != "" {
+		return path, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gotunnel.sock"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	return filepath.Join(dir, "gotunnel", "daemon.sock"), nil
+}
+
+// DaemonTunnel describes one tunnel served by the daemon.
+type DaemonTunnel struct {
+	Name      string `json:"name"`
+	Subdomain string `json:"subdomain"`
+	LocalAddr string `json:"local_addr"`
+	URL       string `json:"url,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DaemonStatus is the daemon's view of its tunnel session.
+type DaemonStatus struct {
+	State             string               `json:"state"`
+	SessionID         string               `json:"session_id,omitempty"`
+	ServerAddr        string               `json:"server_addr"`
+	ConfigPath        string               `json:"config_path"`
+	Tunnels           []DaemonTunnel       `json:"tunnels"`
+	Available         []string             `json:"available"`
+	Pools             map[string]PoolStats `json:"pools"`
+	ReconnectAttempts int64                `json:"reconnect_attempts"`
+}
+
+// ReloadResult lists what a reload changed. Tunnels missing from the
+// config file are stopped and changed ones are re-registered; tunnels that
+// are not running are left for "up".
+type ReloadResult struct {
+	Updated []string          `json:"updated"`
+	Stopped []string          `json:"stopped"`
+	Failed  map[string]string `json:"failed,omitempty"`
+	Notes   []string          `json:"notes,omitempty"`
+}
+
+// Daemon runs a tunnel in the background and serves a JSON control API on
+// a Unix socket, so tunnels from its config file can be started, stopped
+// and reloaded without dropping the session.
+type Daemon struct {
+	tunnel     *Tunnel
+	configPath string
+	logger     *slog.Logger
+
+	// mu serializes tunnel changes and guards config, the config file as
+	// last read.
+	mu     sync.Mutex
+	config *common.ClientConfig
+
+	socketPath string
+	server     *http.Server
+}
+
+// NewDaemon creates a daemon controlling tunnel. cfg is the config file at
+// configPath, with all of its tunnels.
+func NewDaemon(tunnel *Tunnel, configPath string, cfg *common.ClientConfig, logger *slog.Logger) *Daemon {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Daemon{
+		tunnel:     tunnel,
+		configPath: configPath,
+		config:     cfg,
+		logger:     logger.With(slog.String("component", "daemon")),
+	}
+}
+
+// Start listens on the Unix socket at path. A stale socket left by a daemon
+// that exited uncleanly is replaced; a live one is an error.
+func (d *Daemon) Start(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	// Anyone who can reach the socket controls the tunnels
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	d.socketPath = path
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", d.handleStatus)
+	mux.HandleFunc("POST /tunnels/{name}/up", d.handleUp)
+	mux.HandleFunc("POST /tunnels/{name}/down", d.handleDown)
+	mux.HandleFunc("POST /reload", d.handleReload)
+
+	d.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	d.logger.Info("daemon listening", slog.String("socket", path))
+
+	go func() {
+		if err := d.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			d.logger.Error("daemon control server error", slog.Any("error", err))
+		}
+	}()
+
+	return nil
+}
+
+// Stop shuts the control API down and removes the socket.
+func (d *Daemon) Stop(timeout time.Duration) error {
+	if d.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := d.server.Shutdown(ctx)
+	// Serve closes the listener, which normally unlinks the socket
+	if rmErr := os.Remove(d.socketPath); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) && err == nil {
+		err = fmt.Errorf("failed to remove socket: %w", rmErr)
+	}
+	return err
+}
+
+// Status reports the session state, each running tunnel, the config file's
+// tunnels that are not running, and connection pool statistics.
+func (d *Daemon) Status() *DaemonStatus {
+	d.mu.Lock()
+	defined := d.config.Tunnels
+	d.mu.Unlock()
+
+	statuses := make(map[string]protocol.TunnelStatus)
+	for _, status := range d.tunnel.GetTunnelStatus() {
+		statuses[strings.ToLower(status.Subdomain)] = status
+	}
+
+	status := &DaemonStatus{
+		State:      d.tunnel.State().String(),
+		SessionID:  d.tunnel.SessionID(),
+		ServerAddr: d.tunnel.ServerAddr(),
+		ConfigPath: d.configPath,
+		Tunnels:    []DaemonTunnel{},
+		Available:  []string{},
+		Pools:      d.tunnel.router.GetPoolStats(),
+	}
+	if d.tunnel.reconnect != nil {
+		status.ReconnectAttempts = d.tunnel.reconnect.TotalAttempts()
+	}
+
+	running := make(map[string]bool)
+	for _, tc := range d.tunnel.Tunnels() {
+		running[tunnelName(tc)] = true
+		tunnel := DaemonTunnel{
+			Name:      tunnelName(tc),
+			Subdomain: tc.Subdomain,
+			LocalAddr: fmt.Sprintf("%s://%s:%d", tc.Protocol, tc.LocalHost, tc.LocalPort),
+			Status:    "pending",
+		}
+		if s, ok := statuses[strings.ToLower(tc.Subdomain)]; ok {
+			tunnel.URL = s.URL
+			tunnel.Status = s.Status
+			tunnel.Error = s.Error
+		}
+		status.Tunnels = append(status.Tunnels, tunnel)
+	}
+	for _, tc := range defined {
+		if !running[tunnelName(tc)] {
+			status.Available = append(status.Available, tunnelName(tc))
+		}
+	}
+
+	return status
+}
+
+// Up starts the tunnel with the given name or subdomain from the config
+// file. Starting a running tunnel applies its current definition.
+func (d *Daemon) Up(name string) (protocol.TunnelStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tc, ok := findTunnel(d.config.Tunnels, name)
+	if !ok {
+		return protocol.TunnelStatus{}, fmt.Errorf("no tunnel named %q in %s", name, d.configPath)
+	}
+	return d.tunnel.AddTunnel(tc)
+}
+
+// Down stops the running tunnel with the given name or subdomain.
+func (d *Daemon) Down(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tc, ok := findTunnel(d.tunnel.Tunnels(), name)
+	if !ok {
+		return fmt.Errorf("no running tunnel named %q", name)
+	}
+	return d.tunnel.RemoveTunnel(tc.Subdomain)
+}
+
+// Reload re-reads the config file and applies it to the running tunnels.
+// The server address and token are only read at startup, so changing them
+// needs a restart.
+func (d *Daemon) Reload() (*ReloadResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cfg, err := common.LoadClientConfig(d.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReloadResult{Updated: []string{}, Stopped: []string{}}
+	fail := func(name string, err error) {
+		if result.Failed == nil {
+			result.Failed = make(map[string]string)
+		}
+		result.Failed[name] = err.Error()
+		d.logger.Warn("failed to reload tunnel", slog.String("name", name), slog.Any("error", err))
+	}
+
+	for _, running := range d.tunnel.Tunnels() {
+		name := tunnelName(running)
+		tc, ok := findTunnel(cfg.Tunnels, name)
+		switch {
+		case !ok:
+			if err := d.tunnel.RemoveTunnel(running.Subdomain); err != nil {
+				fail(name, err)
+				continue
+			}
+			result.Stopped = append(result.Stopped, name)
+
+		case tc != running:
+			// A new subdomain is a different registration on the server
+			if !strings.EqualFold(tc.Subdomain, running.Subdomain) {
+				if err := d.tunnel.RemoveTunnel(running.Subdomain); err != nil {
+					fail(name, err)
+					continue
+				}
+			}
+			if _, err := d.tunnel.AddTunnel(tc); err != nil {
+				fail(name, err)
+				continue
+			}
+			result.Updated = append(result.Updated, name)
+		}
+	}
+
+	if cfg.ServerAddr != d.config.ServerAddr || !slices.Equal(cfg.ServerAddrs, d.config.ServerAddrs) ||
+		cfg.Failover != d.config.Failover || cfg.Token != d.config.Token || cfg.TLS != d.config.TLS ||
+		cfg.ProxyURL != d.config.ProxyURL {
+		result.Notes = append(result.Notes, "server, failover, token, tls and proxy_url changes take effect when the daemon restarts")
+	}
+	d.config = cfg
+
+	d.logger.Info("config reloaded",
+		slog.Int("updated", len(result.Updated)),
+		slog.Int("stopped", len(result.Stopped)),
+		slog.Int("failed", len(result.Failed)))
+	return result, nil
+}
+
+// handleStatus writes the daemon status as JSON.
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeDaemonJSON(w, http.StatusOK, d.Status())
+}
+
+// handleUp starts a tunnel and writes its status.
+func (d *Daemon) handleUp(w http.ResponseWriter, r *http.Request) {
+	status, err := d.Up(r.PathValue("name"))
+	if err != nil {
+		writeDaemonError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeDaemonJSON(w, http.StatusOK, status)
+}
+
+// handleDown stops a tunnel.
+func (d *Daemon) handleDown(w http.ResponseWriter, r *http.Request) {
+	if err := d.Down(r.PathValue("name")); err != nil {
+		writeDaemonError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReload reloads the config file and writes what changed.
+func (d *Daemon) handleReload(w http.ResponseWriter, r *http.Request) {
+	result, err := d.Reload()
+	if err != nil {
+		writeDaemonError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeDaemonJSON(w, http.StatusOK, result)
+}
+
+// writeDaemonJSON writes v as a JSON response.
+func writeDaemonJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeDaemonError writes err as a JSON error response.
+func writeDaemonError(w http.ResponseWriter, status int, err error) {
+	writeDaemonJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// tunnelName returns the name a tunnel is known by: its name in the config
+// file, or its subdomain.
+func tunnelName(tc protocol.TunnelConfig) string {
+	if tc.Name != "" {
+		return tc.Name
+	}
+	return tc.Subdomain
+}
+
+// findTunnel returns the tunnel with the given name, or with the given
+// subdomain if it has no name, matching ClientConfig.SelectTunnels.
+func findTunnel(tunnels []protocol.TunnelConfig, name string) (protocol.TunnelConfig, bool) {
+	for _, tc := range tunnels {
+		if tc.Name == name || tc.Name == "" && tc.Subdomain == name {
+			return tc, true
+		}
+	}
+	return protocol.TunnelConfig{}, false
+}
+
+// DaemonClient talks to a running daemon over its control socket.
+type DaemonClient struct {
+	httpClient *http.Client
+}
+
+// NewDaemonClient returns a client for the daemon listening on the Unix
+// socket at path.
+func NewDaemonClient(path string) *DaemonClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+	return &DaemonClient{
+		httpClient: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// Status returns the daemon's status.
+func (c *DaemonClient) Status(ctx context.Context) (*DaemonStatus, error) {
+	var status DaemonStatus
+	if err := c.do(ctx, http.MethodGet, "/status", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Up starts a tunnel from the daemon's config file.
+func (c *DaemonClient) Up(ctx context.Context, name string) (*protocol.TunnelStatus, error) {
+	var status protocol.TunnelStatus
+	if err := c.do(ctx, http.MethodPost, "/tunnels/"+url.PathEscape(name)+"/up", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Down stops a running tunnel.
+func (c *DaemonClient) Down(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, "/tunnels/"+url.PathEscape(name)+"/down", nil)
+}
+
+// Reload makes the daemon re-read its config file.
+func (c *DaemonClient) Reload(ctx context.Context) (*ReloadResult, error) {
+	var result ReloadResult
+	if err := c.do(ctx, http.MethodPost, "/reload", &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// do sends a request to the daemon and decodes a successful response into
+// out, if non-nil.
+func (c *DaemonClient) do(ctx context.Context, method, path string, out interface{}) error {
+	// The host is ignored; requests always go to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://gotunnel"+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach daemon (is 'gotunnel daemon' running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &body) != nil || body.Error == "" {
+			body.Error = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("daemon: %s", body.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode daemon response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+)
+
+// daemonConfig is a config file with a running tunnel, web, and one that is
+// only started with "up", api.
+const daemonConfig = `
+server_addr: %q
+token: test-token
+tunnels:
+  - name: web
+    subdomain: web
+    local_port: 3000
+  - name: api
+    subdomain: api
+    local_port: 4000
+  - name: taken
+    subdomain: taken
+    local_port: 5000
+`
+
+// startTestDaemon connects a tunnel serving web to server and starts a
+// daemon for it on a socket in a temporary directory.
+func startTestDaemon(t *testing.T, server *fakeServer) (*Daemon, *DaemonClient, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "tunnel.yaml")
+	if err := os.WriteFile(configPath, []byte(fmt.Sprintf(daemonConfig, server.Addr())), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cfg, err := common.LoadClientConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error = %v", err)
+	}
+
+	running := *cfg
+	if err := running.SelectTunnels("web"); err != nil {
+		t.Fatalf("SelectTunnels() error = %v", err)
+	}
+	tunnel := newTestTunnel(t, &running)
+	if err := tunnel.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	daemon := NewDaemon(tunnel, configPath, cfg, nil)
+	socketPath := filepath.Join(dir, "daemon.sock")
+	if err := daemon.Start(socketPath); err != nil {
+		t.Fatalf("daemon.Start() error = %v", err)
+	}
+	t.Cleanup(func() { daemon.Stop(time.Second) })
+
+	return daemon, NewDaemonClient(socketPath), configPath
+}
+
+func TestDaemon_API(t *testing.T) {
+	server := newFakeServer(t)
+	server.Reject("taken", "subdomain already in use")
+	daemon, client, _ := startTestDaemon(t, server)
+	ctx := context.Background()
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.State != "connected" || status.SessionID != "session-1" || status.ServerAddr != server.Addr() {
+		t.Errorf("Status() = %+v, want connected to session-1", status)
+	}
+	if len(status.Tunnels) != 1 || status.Tunnels[0].Name != "web" || status.Tunnels[0].Status != "active" {
+		t.Errorf("Tunnels = %+v, want web active", status.Tunnels)
+	}
+	if strings.Join(status.Available, ",") != "api,taken" {
+		t.Errorf("Available = %v, want [api taken]", status.Available)
+	}
+
+	// up registers the tunnel on the live session
+	up, err := client.Up(ctx, "api")
+	if err != nil {
+		t.Fatalf("Up(api) error = %v", err)
+	}
+	if up.Status != "active" || up.URL != "https://api.example.com" {
+		t.Errorf("Up(api) = %+v, want active", up)
+	}
+	if !server.Registered("api") {
+		t.Error("api not registered on the server")
+	}
+
+	// down removes it again
+	if err := client.Down(ctx, "api"); err != nil {
+		t.Fatalf("Down(api) error = %v", err)
+	}
+	if server.Registered("api") {
+		t.Error("api still registered on the server after down")
+	}
+
+	status, err = client.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if len(status.Tunnels) != 1 || strings.Join(status.Available, ",") != "api,taken" {
+		t.Errorf("Status() after down = %+v", status)
+	}
+	if _, ok := daemon.tunnel.router.GetPoolStats()["127.0.0.1:4000"]; ok {
+		t.Error("pool for api's port still open after down")
+	}
+}
+
+func TestDaemon_APIErrors(t *testing.T) {
+	server := newFakeServer(t)
+	server.Reject("taken", "subdomain already in use")
+	_, client, _ := startTestDaemon(t, server)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr string
+	}{
+		{"up unknown tunnel", func() error {
+			_, err := client.Up(ctx, "missing")
+			return err
+		}, `no tunnel named "missing"`},
+		{"up rejected by server", func() error {
+			_, err := client.Up(ctx, "taken")
+			return err
+		}, "subdomain already in use"},
+		{"down tunnel not running", func() error {
+			return client.Down(ctx, "api")
+		}, `no running tunnel named "api"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A rejected tunnel is not left half-started
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if len(status.Tunnels) != 1 {
+		t.Errorf("Tunnels = %+v, want only web", status.Tunnels)
+	}
+}
+
+func TestDaemon_Reload(t *testing.T) {
+	server := newFakeServer(t)
+	_, client, configPath := startTestDaemon(t, server)
+	ctx := context.Background()
+
+	if _, err := client.Up(ctx, "api"); err != nil {
+		t.Fatalf("Up(api) error = %v", err)
+	}
+
+	// web moves to another port, api is dropped from the file
+	config := fmt.Sprintf(`
+server_addr: %q
+token: other-token
+tunnels:
+  - name: web
+    subdomain: web
+    local_port: 3001
+`, server.Addr())
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	result, err := client.Reload(ctx)
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if strings.Join(result.Updated, ",") != "web" || strings.Join(result.Stopped, ",") != "api" {
+		t.Errorf("Reload() = %+v, want web updated and api stopped", result)
+	}
+	if len(result.Notes) != 1 {
+		t.Errorf("Notes = %v, want a restart note for the token", result.Notes)
+	}
+	if server.Registered("api") {
+		t.Error("api still registered after reload")
+	}
+
+	// An invalid file is reported and leaves the tunnels alone
+	if err := os.WriteFile(configPath, []byte("tunnels: []\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := client.Reload(ctx); err == nil {
+		t.Error("Reload() accepted an invalid config")
+	}
+	if !server.Registered("web") {
+		t.Error("web unregistered by a failed reload")
+	}
+}
+
+func TestDaemon_StartTwice(t *testing.T) {
+	server := newFakeServer(t)
+	daemon, _, _ := startTestDaemon(t, server)
+
+	other := NewDaemon(daemon.tunnel, daemon.configPath, daemon.config, nil)
+	if err := other.Start(daemon.socketPath); err == nil {
+		other.Stop(time.Second)
+		t.Fatal("second daemon started on a live socket")
+	}
+}
+
+func TestDaemonClient_NotRunning(t *testing.T) {
+	client := NewDaemonClient(filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := client.Status(context.Background()); err == nil || !strings.Contains(err.Error(), "is 'gotunnel daemon' running") {
+		t.Errorf("Status() error = %v, want a hint to start the daemon", err)
+	}
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+)
+
+// Prober connects to the server at addr and reports the round trip time.
+type Prober func(ctx context.Context, addr string) (time.Duration, error)
+
+// ServerSelector chooses the server the tunnel connects to. It keeps the
+// servers in order of preference and moves to the next one after repeated
+// failures.
+type ServerSelector struct {
+	config *common.FailoverConfig
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	servers  []string
+	current  int
+	failures int
+
+	// checkedAt is when the preferred server was last left or last found
+	// unreachable.
+	checkedAt time.Time
+}
+
+// NewServerSelector creates a selector over servers, ordered by the
+// configured policy. Latency ordering happens when Rank is called.
+func NewServerSelector(servers []string, cfg *common.FailoverConfig, logger *slog.Logger) *ServerSelector {
+	s := &ServerSelector{
+		config:  cfg,
+		logger:  logger.With(slog.String("component", "failover")),
+		now:     time.Now,
+		servers: append([]string(nil), servers...),
+	}
+	if cfg.Policy == common.FailoverRandom {
+		rand.Shuffle(len(s.servers), func(i, j int) {
+			s.servers[i], s.servers[j] = s.servers[j], s.servers[i]
+		})
+	}
+	return s
+}
+
+// Current returns the server to connect to.
+func (s *ServerSelector) Current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servers[s.current]
+}
+
+// OnPreferred reports whether the current server is the preferred one.
+func (s *ServerSelector) OnPreferred() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current == 0
+}
+
+// Succeeded records a successful connection to the current server.
+func (s *ServerSelector) Succeeded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = 0
+}
+
+// Failed records a failed connection to the current server. After
+// MaxFailures consecutive failures it moves to the next server, wrapping
+// around after the last, and returns true.
+func (s *ServerSelector) Failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	maxFailures := max(s.config.MaxFailures, 1)
+	if s.failures < maxFailures || len(s.servers) == 1 {
+		return false
+	}
+
+	from := s.servers[s.current]
+	if s.current == 0 {
+		s.checkedAt = s.now()
+	}
+	s.current = (s.current + 1) % len(s.servers)
+	s.failures = 0
+
+	s.logger.Warn("failing over to next server",
+		slog.String("from", from),
+		slog.String("to", s.servers[s.current]))
+	return true
+}
+
+// Reset makes the preferred server current again.
+func (s *ServerSelector) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = 0
+	s.failures = 0
+}
+
+// FailBack makes the preferred server current again if probe reaches it.
+// It probes at most once per FailbackInterval, counted from leaving the
+// preferred server, and reports whether the current server changed.
+func (s *ServerSelector) FailBack(ctx context.Context, probe Prober) bool {
+	s.mu.Lock()
+	if s.current == 0 || s.now().Sub(s.checkedAt) < s.config.FailbackInterval {
+		s.mu.Unlock()
+		return false
+	}
+	preferred := s.servers[0]
+	s.mu.Unlock()
+
+	if _, err := probe(ctx, preferred); err != nil {
+		s.logger.Debug("preferred server still unreachable",
+			slog.String("server", preferred),
+			slog.Any("error", err))
+		s.mu.Lock()
+		s.checkedAt = s.now()
+		s.mu.Unlock()
+		return false
+	}
+
+	s.logger.Info("preferred server recovered, failing back", slog.String("server", preferred))
+	s.Reset()
+	return true
+}
+
+// Rank orders the servers by the round trip time probe measures, fastest
+// first, and makes the fastest current. Servers probe cannot reach go last
+// in their configured order. Rank does nothing unless the policy is
+// latency.
+func (s *ServerSelector) Rank(ctx context.Context, probe Prober) {
+	s.mu.Lock()
+	servers := append([]string(nil), s.servers...)
+	s.mu.Unlock()
+	if s.config.Policy != common.FailoverLatency || len(servers) < 2 {
+		return
+	}
+
+	rtts := make(map[string]time.Duration, len(servers))
+	var (
+		wg     sync.WaitGroup
+		rttsMu sync.Mutex
+	)
+	for _, addr := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+			defer cancel()
+			rtt, err := probe(probeCtx, addr)
+			if err != nil {
+				s.logger.Warn("server unreachable", slog.String("server", addr), slog.Any("error", err))
+				rtt = math.MaxInt64
+			}
+
+			rttsMu.Lock()
+			rtts[addr] = rtt
+			rttsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(servers, func(i, j int) bool {
+		return rtts[servers[i]] < rtts[servers[j]]
+	})
+
+	s.mu.Lock()
+	s.servers = servers
+	s.current = 0
+	s.failures = 0
+	s.mu.Unlock()
+
+	s.logger.Info("ranked servers by latency",
+		slog.String("preferred", servers[0]),
+		slog.Duration("rtt", rtts[servers[0]]))
+}
+
+// serverFailed records a failed connection to the current server,
+// reporting TunnelStateFailingOver if the tunnel moves to the next one.
+func (t *Tunnel) serverFailed() {
+	if t.servers.Failed() {
+		t.setState(TunnelStateFailingOver)
+	}
+}
+
+// probe connects to the server at addr, without a handshake, to check that
+// it is reachable and measure how long connecting takes.
+func (t *Tunnel) probe(ctx context.Context, addr string) (time.Duration, error) {
+	transport, err := t.transportFor(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if muxTransport, ok := transport.(MuxTransport); ok {
+		muxSession, err := muxTransport.DialMux(ctx, addr)
+		if err != nil {
+			return 0, err
+		}
+		return time.Since(start), muxSession.Close()
+	}
+	conn, err := transport.Dial(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(start), conn.Close()
+}
+
+// failBackLoop moves the tunnel back to the preferred server once it is
+// reachable again.
+func (t *Tunnel) failBackLoop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.config.Failover.FailbackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if t.State() != TunnelStateConnected {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(t.ctx, dialTimeout)
+		failedBack := t.servers.FailBack(ctx, t.probe)
+		cancel()
+		if !failedBack {
+			continue
+		}
+
+		// acceptLoop reconnects once the current connection is closed
+		t.setState(TunnelStateFailingOver)
+		if muxSession := t.session(); muxSession != nil {
+			muxSession.Close()
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+)
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeProber reports a fixed round trip time per server and fails for
+// servers marked down.
+type fakeProber struct {
+	mu     sync.Mutex
+	rtts   map[string]time.Duration
+	down   map[string]bool
+	probed []string
+}
+
+func newFakeProber() *fakeProber {
+	return &fakeProber{rtts: make(map[string]time.Duration), down: make(map[string]bool)}
+}
+
+func (p *fakeProber) Probe(ctx context.Context, addr string) (time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probed = append(p.probed, addr)
+	if p.down[addr] {
+		return 0, errors.New("connection refused")
+	}
+	return p.rtts[addr], nil
+}
+
+func (p *fakeProber) setDown(addr string, down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down[addr] = down
+}
+
+func (p *fakeProber) calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.probed)
+}
+
+func newTestSelector(servers []string, cfg common.FailoverConfig, clock *fakeClock) *ServerSelector {
+	s := NewServerSelector(servers, &cfg, slog.Default())
+	s.now = clock.Now
+	return s
+}
+
+var testServers = []string{"a:9000", "b:9000", "c:9000"}
+
+func TestServerSelector_Failed(t *testing.T) {
+	tests := []struct {
+		name        string
+		servers     []string
+		maxFailures int
+		failures    int
+		want        string
+		wantMoved   bool
+	}{
+		{"below max failures", testServers, 3, 2, "a:9000", false},
+		{"at max failures", testServers, 3, 3, "b:9000", true},
+		{"zero max failures moves every time", testServers, 0, 1, "b:9000", true},
+		{"wraps after the last server", testServers, 1, 3, "a:9000", true},
+		{"single server stays", []string{"a:9000"}, 1, 5, "a:9000", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSelector(tt.servers, common.FailoverConfig{MaxFailures: tt.maxFailures}, &fakeClock{})
+
+			var moved bool
+			for i := 0; i < tt.failures; i++ {
+				moved = s.Failed()
+			}
+			if moved != tt.wantMoved {
+				t.Errorf("last Failed() = %v, want %v", moved, tt.wantMoved)
+			}
+			if got := s.Current(); got != tt.want {
+				t.Errorf("Current() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerSelector_SucceededResetsFailures(t *testing.T) {
+	s := newTestSelector(testServers, common.FailoverConfig{MaxFailures: 2}, &fakeClock{})
+
+	s.Failed()
+	s.Succeeded()
+	if s.Failed() {
+		t.Error("Failed() after Succeeded() moved on the first failure")
+	}
+	if !s.Failed() {
+		t.Error("Failed() did not move after MaxFailures failures")
+	}
+
+	s.Reset()
+	if got := s.Current(); got != "a:9000" || !s.OnPreferred() {
+		t.Errorf("Current() after Reset() = %q, want a:9000", got)
+	}
+	if s.Failed() {
+		t.Error("Reset() kept the failure count")
+	}
+}
+
+func TestServerSelector_Rank(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		rtts   map[string]time.Duration
+		down   []string
+		want   []string
+	}{
+		{
+			name:   "latency orders fastest first",
+			policy: common.FailoverLatency,
+			rtts:   map[string]time.Duration{"a:9000": 80 * time.Millisecond, "b:9000": 20 * time.Millisecond, "c:9000": 50 * time.Millisecond},
+			want:   []string{"b:9000", "c:9000", "a:9000"},
+		},
+		{
+			name:   "latency puts unreachable servers last in configured order",
+			policy: common.FailoverLatency,
+			rtts:   map[string]time.Duration{"c:9000": 50 * time.Millisecond},
+			down:   []string{"a:9000", "b:9000"},
+			want:   []string{"c:9000", "a:9000", "b:9000"},
+		},
+		{
+			name:   "ordered ignores latency",
+			policy: common.FailoverOrdered,
+			rtts:   map[string]time.Duration{"a:9000": 80 * time.Millisecond, "b:9000": 20 * time.Millisecond},
+			want:   testServers,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prober := newFakeProber()
+			prober.rtts = tt.rtts
+			for _, addr := range tt.down {
+				prober.setDown(addr, true)
+			}
+			s := newTestSelector(testServers, common.FailoverConfig{Policy: tt.policy, MaxFailures: 1}, &fakeClock{})
+			s.Failed()
+
+			s.Rank(context.Background(), prober.Probe)
+
+			s.mu.Lock()
+			got := append([]string(nil), s.servers...)
+			s.mu.Unlock()
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("servers = %v, want %v", got, tt.want)
+			}
+			if tt.policy == common.FailoverLatency && !s.OnPreferred() {
+				t.Error("Rank() did not make the fastest server current")
+			}
+			if tt.policy != common.FailoverLatency && prober.calls() != 0 {
+				t.Errorf("Rank() probed %d servers with policy %s", prober.calls(), tt.policy)
+			}
+		})
+	}
+}
+
+func TestServerSelector_Random(t *testing.T) {
+	s := newTestSelector(testServers, common.FailoverConfig{Policy: common.FailoverRandom}, &fakeClock{})
+
+	got := append([]string(nil), s.servers...)
+	slices.Sort(got)
+	if !slices.Equal(got, testServers) {
+		t.Errorf("shuffled servers = %v, want a permutation of %v", s.servers, testServers)
+	}
+}
+
+func TestServerSelector_FailBack(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	prober := newFakeProber()
+	s := newTestSelector(testServers, common.FailoverConfig{MaxFailures: 1, FailbackInterval: time.Minute}, clock)
+	ctx := context.Background()
+
+	if s.FailBack(ctx, prober.Probe) || prober.calls() != 0 {
+		t.Fatal("FailBack() probed while on the preferred server")
+	}
+
+	prober.setDown("a:9000", true)
+	s.Failed()
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		recovered bool
+		want      bool
+		wantCalls int
+	}{
+		{"too soon after failing over", 30 * time.Second, false, false, 0},
+		{"preferred still down", 30 * time.Second, false, false, 1},
+		{"too soon after the last probe", 59 * time.Second, true, false, 1},
+		{"preferred recovered", time.Second, true, true, 2},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		prober.setDown("a:9000", !step.recovered)
+
+		if got := s.FailBack(ctx, prober.Probe); got != step.want {
+			t.Errorf("%s: FailBack() = %v, want %v", step.name, got, step.want)
+		}
+		if calls := prober.calls(); calls != step.wantCalls {
+			t.Errorf("%s: %d probes, want %d", step.name, calls, step.wantCalls)
+		}
+	}
+
+	if got := s.Current(); got != "a:9000" {
+		t.Errorf("Current() after failing back = %q, want a:9000", got)
+	}
+}
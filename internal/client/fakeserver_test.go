@@ -0,0 +1,239 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/hashicorp/yamux"
+)
+
+// fakeServer is a stand-in tunnel server. It accepts every handshake and
+// answers add and remove requests, rejecting subdomains listed in reject.
+type fakeServer struct {
+	t        *testing.T
+	listener net.Listener
+
+	mu       sync.Mutex
+	sessions []*yamux.Session
+	tunnels  map[string]bool
+	reject   map[string]string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	f := &fakeServer{
+		t:        t,
+		listener: listener,
+		tunnels:  make(map[string]bool),
+		reject:   make(map[string]string),
+	}
+	t.Cleanup(f.Close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// Addr returns the server's host:port.
+func (f *fakeServer) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Close stops accepting connections and drops the open ones.
+func (f *fakeServer) Close() {
+	f.listener.Close()
+	f.DropSessions()
+}
+
+// DropSessions closes every open session, as a server restart would.
+func (f *fakeServer) DropSessions() {
+	f.mu.Lock()
+	sessions := f.sessions
+	f.sessions = nil
+	f.mu.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+}
+
+// Reject makes the server refuse to register subdomain.
+func (f *fakeServer) Reject(subdomain, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reject[subdomain] = reason
+}
+
+// Registered reports whether subdomain is registered on the server.
+func (f *fakeServer) Registered(subdomain string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tunnels[subdomain]
+}
+
+// CloseTunnel unregisters subdomain and tells the newest session's client,
+// as an admin unregister on the real server does.
+func (f *fakeServer) CloseTunnel(subdomain, reason string) {
+	f.t.Helper()
+
+	f.mu.Lock()
+	delete(f.tunnels, subdomain)
+	session := f.sessions[len(f.sessions)-1]
+	f.mu.Unlock()
+
+	stream, err := session.Open()
+	if err != nil {
+		f.t.Fatalf("Open() error = %v", err)
+	}
+	defer stream.Close()
+	if err := protocol.WriteStreamHeader(stream, &protocol.StreamHeader{Type: protocol.StreamTypeControl, Subdomain: subdomain}); err != nil {
+		f.t.Fatalf("WriteStreamHeader() error = %v", err)
+	}
+	if err := protocol.NewCodec(stream, stream).SendTunnelClosed(subdomain, reason); err != nil {
+		f.t.Fatalf("SendTunnelClosed() error = %v", err)
+	}
+}
+
+func (f *fakeServer) serve(conn net.Conn) {
+	session, err := yamux.Server(conn, nil)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	f.mu.Lock()
+	f.sessions = append(f.sessions, session)
+	id := len(f.sessions)
+	f.mu.Unlock()
+
+	// The first stream carries the handshake
+	stream, err := session.Accept()
+	if err != nil {
+		return
+	}
+	f.handshake(stream, fmt.Sprintf("session-%d", id))
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+		go f.update(stream)
+	}
+}
+
+func (f *fakeServer) handshake(stream net.Conn, sessionID string) {
+	defer stream.Close()
+
+	codec := protocol.NewCodec(stream, stream)
+	envelope, err := codec.ReadMessage()
+	if err != nil {
+		return
+	}
+	var req protocol.HandshakeRequest
+	if err := envelope.DecodePayload(&req); err != nil {
+		return
+	}
+
+	resp := &protocol.HandshakeResponse{Success: true, SessionID: sessionID, ServerVersion: protocol.ProtocolVersion}
+	for _, tc := range req.Tunnels {
+		resp.Tunnels = append(resp.Tunnels, f.register(tc))
+	}
+	codec.SendHandshakeResponse(resp)
+}
+
+func (f *fakeServer) update(stream net.Conn) {
+	defer stream.Close()
+
+	codec := protocol.NewCodec(stream, stream)
+	envelope, err := codec.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	resp := &protocol.TunnelUpdateResponse{Success: true}
+	switch envelope.Type {
+	case protocol.MessageTypeAddTunnel:
+		var req protocol.AddTunnelRequest
+		if err := envelope.DecodePayload(&req); err != nil {
+			return
+		}
+		resp.Tunnel = f.register(req.Tunnel)
+		if resp.Tunnel.Status != "active" {
+			resp.Success = false
+			resp.Error = resp.Tunnel.Error
+		}
+	case protocol.MessageTypeRemoveTunnel:
+		var req protocol.RemoveTunnelRequest
+		if err := envelope.DecodePayload(&req); err != nil {
+			return
+		}
+		f.mu.Lock()
+		delete(f.tunnels, strings.ToLower(req.Subdomain))
+		f.mu.Unlock()
+		resp.Tunnel = protocol.TunnelStatus{Subdomain: req.Subdomain, Status: "removed"}
+	default:
+		return
+	}
+	codec.SendTunnelUpdate(envelope.RequestID, resp)
+}
+
+// register registers a tunnel unless it is rejected and returns its status.
+func (f *fakeServer) register(tc protocol.TunnelConfig) protocol.TunnelStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subdomain := strings.ToLower(tc.Subdomain)
+	status := protocol.TunnelStatus{Subdomain: subdomain, LocalPort: tc.LocalPort}
+	if reason, ok := f.reject[subdomain]; ok {
+		status.Status = "error"
+		status.Error = reason
+		return status
+	}
+	f.tunnels[subdomain] = true
+	status.Status = "active"
+	status.URL = "https://" + subdomain + ".example.com"
+	return status
+}
+
+// newTestTunnel returns a tunnel for cfg that is closed when the test ends.
+func newTestTunnel(t *testing.T, cfg *common.ClientConfig) *Tunnel {
+	t.Helper()
+
+	tunnel, err := NewTunnel(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewTunnel() error = %v", err)
+	}
+	t.Cleanup(func() { tunnel.Close() })
+	return tunnel
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// LocalServer serves tunnel health on a local address: Prometheus metrics
+// on /metrics and a JSON summary on /status.
+type LocalServer struct {
+	tunnel *Tunnel
+	logger *slog.Logger
+
+	registry *prometheus.Registry
+	server   *http.Server
+}
+
+// NewLocalServer creates a local server reporting on the given tunnel.
+func NewLocalServer(tunnel *Tunnel, logger *slog.Logger) *LocalServer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTunnelCollector(tunnel))
+
+	return &LocalServer{
+		tunnel:   tunnel,
+		logger:   logger.With(slog.String("component", "local_server")),
+		registry: registry,
+	}
+}
+
+// Start starts listening on addr.
+func (s *LocalServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/status", s.handleStatus)
+
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.logger.Info("local server listening", slog.String("addr", listener.Addr().String()))
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("local server error", slog.Any("error", err))
+		}
+	}()
+
+	return nil
+}
+
+// Stop shuts the local server down.
+func (s *LocalServer) Stop(timeout time.Duration) error {
+	if s.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// localStatus is the /status response body.
+type localStatus struct {
+	State             string                  `json:"state"`
+	SessionID         string                  `json:"session_id,omitempty"`
+	Tunnels           []protocol.TunnelStatus `json:"tunnels"`
+	Pools             map[string]PoolStats    `json:"pools"`
+	ReconnectAttempts int64                   `json:"reconnect_attempts"`
+}
+
+// handleStatus writes the tunnel state as JSON.
+func (s *LocalServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := localStatus{
+		State:     s.tunnel.State().String(),
+		SessionID: s.tunnel.SessionID(),
+		Tunnels:   s.tunnel.GetTunnelStatus(),
+		Pools:     s.tunnel.router.GetPoolStats(),
+	}
+	if s.tunnel.reconnect != nil {
+		status.ReconnectAttempts = s.tunnel.reconnect.TotalAttempts()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// tunnelCollector exports tunnel state, connection pool statistics and
+// reconnect attempts, read from the tunnel at scrape time.
+type tunnelCollector struct {
+	tunnel *Tunnel
+
+	state             *prometheus.Desc
+	poolIdle          *prometheus.Desc
+	poolOpen          *prometheus.Desc
+	poolWaits         *prometheus.Desc
+	poolConns         *prometheus.Desc
+	poolReused        *prometheus.Desc
+	reconnectAttempts *prometheus.Desc
+}
+
+func newTunnelCollector(tunnel *Tunnel) *tunnelCollector {
+	poolLabels := []string{"local_port", "address"}
+	return &tunnelCollector{
+		tunnel: tunnel,
+		state: prometheus.NewDesc("gotunnel_client_state",
+			"Current tunnel state; 1 for the active state.", []string{"state"}, nil),
+		poolIdle: prometheus.NewDesc("gotunnel_client_pool_idle_conns",
+			"Idle connections to the local service.", poolLabels, nil),
+		poolOpen: prometheus.NewDesc("gotunnel_client_pool_open_conns",
+			"Open connections to the local service.", poolLabels, nil),
+		poolWaits: prometheus.NewDesc("gotunnel_client_pool_waits_total",
+			"Times a request waited for a pooled connection.", poolLabels, nil),
+		poolConns: prometheus.NewDesc("gotunnel_client_pool_conns_total",
+			"Connections dialed to the local service.", poolLabels, nil),
+		poolReused: prometheus.NewDesc("gotunnel_client_pool_reused_total",
+			"Requests served over a reused connection.", poolLabels, nil),
+		reconnectAttempts: prometheus.NewDesc("gotunnel_client_reconnect_attempts_total",
+			"Reconnection attempts since the client started.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *tunnelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.poolIdle
+	ch <- c.poolOpen
+	ch <- c.poolWaits
+	ch <- c.poolConns
+	ch <- c.poolReused
+	ch <- c.reconnectAttempts
+}
+
+// Collect implements prometheus.Collector.
+func (c *tunnelCollector) Collect(ch chan<- prometheus.Metric) {
+	current := c.tunnel.State()
+	for state := TunnelStateDisconnected; state <= TunnelStateClosed; state++ {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, value, state.String())
+	}
+
+	for addr, stats := range c.tunnel.router.GetPoolStats() {
+		_, port, _ := net.SplitHostPort(addr)
+		labels := []string{port, stats.Address}
+		ch <- prometheus.MustNewConstMetric(c.poolIdle, prometheus.GaugeValue, float64(stats.IdleConns), labels...)
+		ch <- prometheus.MustNewConstMetric(c.poolOpen, prometheus.GaugeValue, float64(stats.OpenConns), labels...)
+		ch <- prometheus.MustNewConstMetric(c.poolWaits, prometheus.CounterValue, float64(stats.WaitCount), labels...)
+		ch <- prometheus.MustNewConstMetric(c.poolConns, prometheus.CounterValue, float64(stats.TotalConns), labels...)
+		ch <- prometheus.MustNewConstMetric(c.poolReused, prometheus.CounterValue, float64(stats.TotalReused), labels...)
+	}
+
+	var attempts int64
+	if c.tunnel.reconnect != nil {
+		attempts = c.tunnel.reconnect.TotalAttempts()
+	}
+	ch <- prometheus.MustNewConstMetric(c.reconnectAttempts, prometheus.CounterValue, float64(attempts))
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/quic-go/quic-go"
+)
+
+// networkCheckInterval is how often a QUIC connection checks whether the
+// host has moved to another network.
+const networkCheckInterval = 5 * time.Second
+
+// quicTransport dials quic://host:port. Each proxied request is a native
+// QUIC stream, so one stalled download does not hold up the others.
+type quicTransport struct {
+	tlsConfig *tls.Config
+	logger    *slog.Logger
+}
+
+// Dial implements Transport. A QUIC connection is not a single byte stream,
+// so the tunnel calls DialMux instead.
+func (t *quicTransport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return nil, errors.New("quic transport multiplexes streams natively; use DialMux")
+}
+
+// DialMux implements MuxTransport.
+func (t *quicTransport) DialMux(ctx context.Context, addr string) (StreamMux, error) {
+	hostPort := strings.TrimPrefix(addr, "quic://")
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUIC address: %w", err)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", hostPort, err)
+	}
+
+	tlsConfig := t.tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{common.QUICProtocol}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+
+	session := &quicSession{logger: t.logger.With(slog.String("transport", "quic"))}
+	transport, err := session.newTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := transport.Dial(ctx, udpAddr, tlsConfig, common.QUICConfig())
+	if err != nil {
+		session.closeTransports()
+		return nil, fmt.Errorf("QUIC dial failed: %w", err)
+	}
+	session.QUICSession = common.NewQUICSession(conn)
+
+	go session.watchNetwork()
+	return session, nil
+}
+
+// quicSession is a client QUIC connection that migrates to another UDP socket
+// when the host changes networks, keeping its streams open.
+type quicSession struct {
+	*common.QUICSession
+	logger *slog.Logger
+
+	// mu protects the sockets the connection has used: the one it was
+	// dialed on and at most one more to migrate to. quic-go keeps a
+	// connection registered on every transport it has sent from and closing
+	// any of them ends the connection, so instead of opening a socket per
+	// migration the connection alternates between the two. Both are bound
+	// to every interface, so either works on a new network.
+	mu         sync.Mutex
+	transports []*quic.Transport
+	sockets    []*net.UDPConn
+	active     int        // index in transports of the one in use
+	path       *quic.Path // path in use, nil until the first migration
+}
+
+// newTransport opens a UDP socket for the connection to send from.
+func (s *quicSession) newTransport() (*quic.Transport, error) {
+	socket, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	transport := &quic.Transport{Conn: socket}
+
+	s.mu.Lock()
+	s.transports = append(s.transports, transport)
+	s.sockets = append(s.sockets, socket)
+	s.mu.Unlock()
+	return transport, nil
+}
+
+// Close closes the connection and its sockets.
+func (s *quicSession) Close() error {
+	err := s.QUICSession.Close()
+	s.closeTransports()
+	return err
+}
+
+func (s *quicSession) closeTransports() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, transport := range s.transports {
+		transport.Close()
+	}
+	for _, socket := range s.sockets {
+		socket.Close()
+	}
+	s.transports, s.sockets = nil, nil
+}
+
+// spareTransport returns the transport the connection is not using,
+// opening it on the first migration.
+func (s *quicSession) spareTransport() (*quic.Transport, int, error) {
+	s.mu.Lock()
+	if len(s.transports) > 1 {
+		spare := 1 - s.active
+		defer s.mu.Unlock()
+		return s.transports[spare], spare, nil
+	}
+	s.mu.Unlock()
+
+	transport, err := s.newTransport()
+	return transport, 1, err
+}
+
+// migrate moves the connection to the other UDP socket after validating
+// the path from it to the server.
+func (s *quicSession) migrate(ctx context.Context) error {
+	transport, index, err := s.spareTransport()
+	if err != nil {
+		return err
+	}
+
+	path, err := s.Conn().AddPath(transport)
+	if err != nil {
+		return fmt.Errorf("failed to add path: %w", err)
+	}
+	if err := path.Probe(ctx); err != nil {
+		path.Close()
+		return fmt.Errorf("failed to probe path: %w", err)
+	}
+	if err := path.Switch(); err != nil {
+		path.Close()
+		return fmt.Errorf("failed to switch path: %w", err)
+	}
+
+	// The path left behind is dropped so the socket can carry a new one
+	s.mu.Lock()
+	previous := s.path
+	s.active = index
+	s.path = path
+	s.mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// activeAddr returns the local address of the socket in use.
+func (s *quicSession) activeAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sockets[s.active].LocalAddr()
+}
+
+// watchNetwork migrates the connection whenever the host's addresses
+// change, e.g. when a laptop moves from Wi-Fi to a wired network.
+func (s *quicSession) watchNetwork() {
+	ticker := time.NewTicker(networkCheckInterval)
+	defer ticker.Stop()
+
+	connCtx := s.Conn().Context()
+	addrs := interfaceAddrs()
+	for {
+		select {
+		case <-connCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := interfaceAddrs()
+		if current == addrs {
+			continue
+		}
+		addrs = current
+
+		ctx, cancel := context.WithTimeout(connCtx, dialTimeout)
+		err := s.migrate(ctx)
+		cancel()
+		if err != nil {
+			s.logger.Warn("failed to migrate connection after network change", slog.Any("error", err))
+			continue
+		}
+		s.logger.Info("migrated connection after network change",
+			slog.String("local_addr", s.activeAddr().String()))
+	}
+}
+
+// interfaceAddrs returns the host's addresses as a comparable string.
+func interfaceAddrs() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+
+	strs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strs[i] = addr.String()
+	}
+	sort.Strings(strs)
+	return strings.Join(strs, ",")
+}
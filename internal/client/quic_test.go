@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/quic-go/quic-go"
+)
+
+// quicEchoServer listens for QUIC connections on localhost and echoes every
+// stream. It returns the address and a client TLS config trusting it.
+func quicEchoServer(t *testing.T) (string, *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{common.QUICProtocol},
+	}, common.QUICConfig())
+	if err != nil {
+		t.Fatalf("ListenAddr() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go func() {
+						defer stream.Close()
+						io.Copy(stream, stream)
+					}()
+				}
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"}
+}
+
+// assertQUICEcho checks that a new stream on session reaches the echo server.
+func assertQUICEcho(t *testing.T, session StreamMux, msg string) {
+	t.Helper()
+
+	stream, err := session.Open()
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(stream, msg); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(buf) != msg {
+		t.Errorf("echo = %q, want %q", buf, msg)
+	}
+}
+
+func TestQUICTransport_Migrate(t *testing.T) {
+	addr, tlsConfig := quicEchoServer(t)
+	transport := &quicTransport{tlsConfig: tlsConfig, logger: slog.Default()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mux, err := transport.DialMux(ctx, "quic://"+addr)
+	if err != nil {
+		t.Fatalf("DialMux() error = %v", err)
+	}
+	defer mux.Close()
+	session := mux.(*quicSession)
+	assertQUICEcho(t, session, "before")
+
+	// Each move adds, probes and switches to a path from the other socket,
+	// so moving back reuses the dialing socket
+	dialed := session.activeAddr().String()
+	for i, want := range []string{"spare", "dialing"} {
+		before := session.activeAddr().String()
+		if err := session.migrate(ctx); err != nil {
+			t.Fatalf("migration %d: migrate() error = %v", i+1, err)
+		}
+		after := session.activeAddr().String()
+		if after == before || (want == "dialing") != (after == dialed) {
+			t.Errorf("migration %d: on %s, want the %s socket", i+1, after, want)
+		}
+		assertQUICEcho(t, session, "after migrating")
+	}
+
+	session.mu.Lock()
+	sockets := len(session.sockets)
+	session.mu.Unlock()
+	if sockets != 2 {
+		t.Errorf("%d sockets open after migrating, want 2", sockets)
+	}
+
+	if err := session.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if len(session.sockets) != 0 {
+		t.Errorf("%d sockets open after Close(), want 0", len(session.sockets))
+	}
+}
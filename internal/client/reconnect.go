@@ -15,10 +15,11 @@ type Reconnector struct {
 	config *common.ReconnectConfig
 	logger *slog.Logger
 
-	mu           sync.Mutex
-	attempts     int
-	currentDelay time.Duration
-	lastAttempt  time.Time
+	mu            sync.Mutex
+	attempts      int
+	totalAttempts int64
+	currentDelay  time.Duration
+	lastAttempt   time.Time
 }
 
 // NewReconnector creates a new reconnector.
@@ -37,6 +38,7 @@ func (r *Reconnector) NextDelay() time.Duration {
 	defer r.mu.Unlock()
 
 	r.attempts++
+	r.totalAttempts++
 
 	// Check if we've exceeded max attempts
 	if r.config.MaxAttempts > 0 && r.attempts > r.config.MaxAttempts {
@@ -86,6 +88,14 @@ func (r *Reconnector) Attempts() int {
 	return r.attempts
 }
 
+// TotalAttempts returns the number of reconnection attempts made over the
+// reconnector's lifetime, including those before the last Reset.
+func (r *Reconnector) TotalAttempts() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.totalAttempts
+}
+
 // CurrentDelay returns the current delay.
 func (r *Reconnector) CurrentDelay() time.Duration {
 	r.mu.Lock()
@@ -1,22 +1,27 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/anyhost/gotunnel/internal/common"
 	"github.com/anyhost/gotunnel/internal/protocol"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Router routes incoming streams to local services.
 type Router struct {
 	config *common.ClientConfig
 	logger *slog.Logger
-	pools  map[int]*ConnectionPool
+	pools  map[string]*ConnectionPool // local host:port -> pool
 
 	mu sync.RWMutex
 }
@@ -26,33 +31,40 @@ func NewRouter(cfg *common.ClientConfig, logger *slog.Logger) *Router {
 	r := &Router{
 		config: cfg,
 		logger: logger.With(slog.String("component", "router")),
-		pools:  make(map[int]*ConnectionPool),
+		pools:  make(map[string]*ConnectionPool),
 	}
 
 	// Initialize connection pools for each tunnel
 	for _, tunnel := range cfg.Tunnels {
-		addr := fmt.Sprintf("%s:%d", tunnel.LocalHost, tunnel.LocalPort)
-		if tunnel.LocalHost == "" {
-			addr = fmt.Sprintf("127.0.0.1:%d", tunnel.LocalPort)
-		}
-		r.pools[tunnel.LocalPort] = NewConnectionPool(addr, &PoolConfig{
-			MaxIdleConns:    10,
-			MaxOpenConns:    100,
-			ConnMaxLifetime: 5 * time.Minute,
-			ConnMaxIdleTime: 1 * time.Minute,
-			DialTimeout:     5 * time.Second,
-		})
+		r.AddPool(localAddr(tunnel.LocalHost, tunnel.LocalPort))
 	}
 
 	return r
 }
 
+// localAddr returns the address of a tunnel's local service.
+func localAddr(host string, port int) string {
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
 // Forward forwards a stream to the appropriate local service.
-func (r *Router) Forward(stream net.Conn, header *protocol.StreamHeader) error {
+func (r *Router) Forward(ctx context.Context, stream net.Conn, header *protocol.StreamHeader) error {
+	addr := localAddr(header.LocalHost, header.LocalPort)
 	r.mu.RLock()
-	pool, exists := r.pools[header.LocalPort]
+	pool, exists := r.pools[addr]
 	r.mu.RUnlock()
 
+	_, span := tracer.Start(ctx, "Router.Forward",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.Int("gotunnel.local_port", header.LocalPort),
+			attribute.Bool("gotunnel.pooled", exists),
+		))
+	defer span.End()
+
 	var localConn net.Conn
 	var err error
 
@@ -60,21 +72,30 @@ func (r *Router) Forward(stream net.Conn, header *protocol.StreamHeader) error {
 		// Use pooled connection
 		localConn, err = pool.Get()
 		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to get connection from pool")
 			return fmt.Errorf("failed to get connection from pool: %w", err)
 		}
 		defer pool.Put(localConn)
 	} else {
 		// Direct connection (fallback)
-		addr := header.GetLocalAddr()
 		localConn, err = net.DialTimeout("tcp", addr, 5*time.Second)
 		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to connect to local service")
 			return fmt.Errorf("failed to connect to %s: %w", addr, err)
 		}
 		defer localConn.Close()
 	}
+	span.SetAttributes(attribute.String("server.address", localConn.RemoteAddr().String()))
 
 	// Bidirectional copy
-	return r.pipe(stream, localConn)
+	if err := r.pipe(stream, localConn); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to pipe stream")
+		return err
+	}
+	return nil
 }
 
 // pipe copies data bidirectionally between two connections.
@@ -128,20 +149,15 @@ func (r *Router) Close() {
 	for _, pool := range r.pools {
 		pool.Close()
 	}
-	r.pools = make(map[int]*ConnectionPool)
+	r.pools = make(map[string]*ConnectionPool)
 }
 
-// AddPool adds a connection pool for a new tunnel.
-func (r *Router) AddPool(port int, host string) {
+// AddPool adds a connection pool for a new tunnel's local address.
+func (r *Router) AddPool(addr string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	addr := fmt.Sprintf("%s:%d", host, port)
-	if host == "" {
-		addr = fmt.Sprintf("127.0.0.1:%d", port)
-	}
-
-	r.pools[port] = NewConnectionPool(addr, &PoolConfig{
+	r.pools[addr] = NewConnectionPool(addr, &PoolConfig{
 		MaxIdleConns:    10,
 		MaxOpenConns:    100,
 		ConnMaxLifetime: 5 * time.Minute,
@@ -150,25 +166,26 @@ func (r *Router) AddPool(port int, host string) {
 	})
 }
 
-// RemovePool removes a connection pool.
-func (r *Router) RemovePool(port int) {
+// RemovePool removes the connection pool for a local address.
+func (r *Router) RemovePool(addr string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if pool, exists := r.pools[port]; exists {
+	if pool, exists := r.pools[addr]; exists {
 		pool.Close()
-		delete(r.pools, port)
+		delete(r.pools, addr)
 	}
 }
 
-// GetPoolStats returns statistics for all connection pools.
-func (r *Router) GetPoolStats() map[int]PoolStats {
+// GetPoolStats returns statistics for all connection pools, keyed by local
+// address.
+func (r *Router) GetPoolStats() map[string]PoolStats {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	stats := make(map[int]PoolStats)
-	for port, pool := range r.pools {
-		stats[port] = pool.Stats()
+	stats := make(map[string]PoolStats)
+	for addr, pool := range r.pools {
+		stats[addr] = pool.Stats()
 	}
 	return stats
 }
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/gorilla/websocket"
+)
+
+// dialTimeout bounds establishing a connection to the server.
+const dialTimeout = 10 * time.Second
+
+// Transport connects to the tunnel server. The connection it returns
+// carries the yamux session, so a transport only has to provide a
+// reliable, ordered byte stream.
+type Transport interface {
+	// Dial connects to the server at addr, the configured server address.
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// MuxTransport is implemented by transports whose connections multiplex
+// streams natively, such as QUIC. The tunnel uses the streams of DialMux's
+// connection directly instead of running yamux over Dial's.
+type MuxTransport interface {
+	Transport
+
+	// DialMux connects to the server at addr.
+	DialMux(ctx context.Context, addr string) (StreamMux, error)
+}
+
+// StreamMux multiplexes streams over a server connection. *yamux.Session
+// implements it.
+type StreamMux interface {
+	// Open opens a new stream to the server.
+	Open() (net.Conn, error)
+
+	// Accept waits for the server to open a stream.
+	Accept() (net.Conn, error)
+
+	// Close closes the connection and all its streams.
+	Close() error
+
+	// IsClosed reports whether the connection is closed.
+	IsClosed() bool
+}
+
+// TransportFunc adapts a function to the Transport interface.
+type TransportFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// Dial implements Transport.
+func (f TransportFunc) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return f(ctx, addr)
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]Transport)
+)
+
+// RegisterTransport makes a transport available for server addresses with
+// the given URL scheme, taking precedence over the built-in ones.
+func RegisterTransport(scheme string, transport Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[strings.ToLower(scheme)] = transport
+}
+
+// transportFor returns the transport for a server address: a registered
+// one for its URL scheme, or a built-in one. A bare host:port is raw TCP.
+// The built-in TLS and QUIC transports use the tunnel's TLS settings.
+func (t *Tunnel) transportFor(serverAddr string) (Transport, error) {
+	scheme, _, ok := strings.Cut(serverAddr, "://")
+	if !ok {
+		scheme = "tcp"
+	}
+	scheme = strings.ToLower(scheme)
+
+	transportsMu.RLock()
+	transport, ok := transports[scheme]
+	transportsMu.RUnlock()
+	if ok {
+		return transport, nil
+	}
+
+	switch scheme {
+	case "tcp":
+		return TransportFunc(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialTCP(ctx, addr, t.dialer)
+		}), nil
+	case "tls":
+		return TransportFunc(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialTLS(ctx, addr, t.dialer, t.tlsConfig)
+		}), nil
+	case "quic":
+		if t.config.ProxyURL != "" {
+			return nil, fmt.Errorf("quic transport cannot be used with proxy_url: proxies carry TCP only")
+		}
+		return &quicTransport{tlsConfig: t.tlsConfig, logger: t.logger}, nil
+	case "ws", "wss", "http", "https":
+		return TransportFunc(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialWebSocket(ctx, addr, t.dialer, t.tlsConfig)
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported server address scheme %q", scheme)
+}
+
+// dialTCP establishes a raw TCP connection to host:port or tcp://host:port.
+func dialTCP(ctx context.Context, addr string, dialer *common.Dialer) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	return dialer.DialContext(ctx, "https", strings.TrimPrefix(addr, "tcp://"))
+}
+
+// dialTLS establishes a TLS connection to tls://host:port.
+func dialTLS(ctx context.Context, addr string, dialer *common.Dialer, config *tls.Config) (net.Conn, error) {
+	hostPort := strings.TrimPrefix(addr, "tls://")
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "https", hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("TLS dial failed: %w", err)
+	}
+
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(hostPort)
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS dial failed: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// dialWebSocket establishes a WebSocket connection to the server's /tunnel
+// endpoint. http and https URLs are dialed as ws and wss.
+func dialWebSocket(ctx context.Context, addr string, dialer *common.Dialer, config *tls.Config) (net.Conn, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+
+	// Ensure path ends with /tunnel
+	if !strings.HasSuffix(u.Path, "/tunnel") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/tunnel"
+	}
+
+	// Proxy selection follows the HTTP scheme the WebSocket upgrades from
+	proxyScheme := "http"
+	if u.Scheme == "wss" {
+		proxyScheme = "https"
+	}
+	wsDialer := websocket.Dialer{
+		HandshakeTimeout: dialTimeout,
+		TLSClientConfig:  config,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, proxyScheme, addr)
+		},
+	}
+
+	ws, _, err := wsDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("WebSocket dial failed: %w", err)
+	}
+
+	return common.NewWSConn(ws), nil
+}
@@ -2,13 +2,13 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
-	"net/url"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -16,10 +16,16 @@ import (
 
 	"github.com/anyhost/gotunnel/internal/common"
 	"github.com/anyhost/gotunnel/internal/protocol"
-	"github.com/gorilla/websocket"
+	"github.com/anyhost/gotunnel/internal/telemetry"
 	"github.com/hashicorp/yamux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer creates spans for forwarded requests.
+var tracer = telemetry.Tracer("github.com/anyhost/gotunnel/internal/client")
+
 // TunnelState represents the current state of the tunnel.
 type TunnelState int32
 
@@ -36,6 +42,10 @@ const (
 	// TunnelStateReconnecting indicates reconnection in progress.
 	TunnelStateReconnecting
 
+	// TunnelStateFailingOver indicates the tunnel is moving to another
+	// server; ServerAddr returns the one it will connect to.
+	TunnelStateFailingOver
+
 	// TunnelStateClosed indicates tunnel has been permanently closed.
 	TunnelStateClosed
 )
@@ -50,6 +60,8 @@ func (s TunnelState) String() string {
 		return "connected"
 	case TunnelStateReconnecting:
 		return "reconnecting"
+	case TunnelStateFailingOver:
+		return "failing_over"
 	case TunnelStateClosed:
 		return "closed"
 	default:
@@ -74,17 +86,35 @@ type RequestInfo struct {
 // RequestHandler is called for each request.
 type RequestHandler func(info RequestInfo)
 
+// StreamHandler receives a stream from the server after its header has been
+// read, in place of forwarding it to a local port. It owns the stream and
+// must close it.
+type StreamHandler func(stream net.Conn, header *protocol.StreamHeader)
+
 // Tunnel is the main client that connects to the tunnel server.
 type Tunnel struct {
 	config *common.ClientConfig
 	logger *slog.Logger
 
+	// connMu guards the current server connection. The reconnect path
+	// replaces it while daemon requests and failback read it.
+	connMu     sync.RWMutex
 	conn       net.Conn
-	muxSession *yamux.Session
+	muxSession StreamMux
 	sessionID  string
 
-	router    *Router
-	reconnect *Reconnector
+	// tlsConfig is used by the tls, wss and https transports.
+	tlsConfig *tls.Config
+
+	// dialer reaches the server directly or through the configured proxy.
+	dialer *common.Dialer
+
+	router      *Router
+	reconnect   *Reconnector
+	servers     *ServerSelector
+	rankOnce    sync.Once
+	localServer *LocalServer
+	tracing     telemetry.ShutdownFunc
 
 	state atomic.Int32
 
@@ -92,6 +122,7 @@ type Tunnel struct {
 	tunnelStatus    []protocol.TunnelStatus
 	stateHandlers   []func(TunnelState)
 	requestHandlers []RequestHandler
+	streamHandler   StreamHandler
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -104,13 +135,32 @@ func NewTunnel(cfg *common.ClientConfig, logger *slog.Logger) (*Tunnel, error) {
 		logger = slog.Default()
 	}
 
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	dialer, err := common.NewDialer(cfg.ProxyURL, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxy: %w", err)
+	}
+
+	// Export spans to the configured OTLP collector
+	tracing, err := telemetry.Setup(context.Background(), &cfg.Tracing, "gotunnel-client")
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	t := &Tunnel{
 		config:          cfg,
+		tlsConfig:       tlsConfig,
+		dialer:          dialer,
 		logger:          logger.With(slog.String("component", "tunnel")),
 		ctx:             ctx,
 		cancel:          cancel,
+		tracing:         tracing,
 		stateHandlers:   make([]func(TunnelState), 0),
 		requestHandlers: make([]RequestHandler, 0),
 	}
@@ -118,6 +168,8 @@ func NewTunnel(cfg *common.ClientConfig, logger *slog.Logger) (*Tunnel, error) {
 	// Create router with connection pooling
 	t.router = NewRouter(cfg, logger)
 
+	t.servers = NewServerSelector(cfg.Servers(), &cfg.Failover, logger)
+
 	// Create reconnector if enabled
 	if cfg.Reconnect.Enabled {
 		t.reconnect = NewReconnector(&cfg.Reconnect, logger)
@@ -128,101 +180,106 @@ func NewTunnel(cfg *common.ClientConfig, logger *slog.Logger) (*Tunnel, error) {
 	return t, nil
 }
 
-// Connect establishes a connection to the tunnel server.
-// It auto-detects whether to use WebSocket or raw TCP based on the server address.
+// Connect establishes a connection to the current server over the
+// transport for its address's scheme. Repeated failures move the tunnel to
+// the next server.
 func (t *Tunnel) Connect() error {
-	t.setState(TunnelStateConnecting)
+	// Order the servers by latency before the first connection
+	t.rankOnce.Do(func() {
+		t.servers.Rank(t.ctx, t.probe)
+	})
 
-	t.logger.Info("connecting to server", slog.String("addr", t.config.ServerAddr))
+	if err := t.connect(t.servers.Current()); err != nil {
+		t.serverFailed()
+		return err
+	}
+	t.servers.Succeeded()
+	return nil
+}
 
-	var conn net.Conn
-	var err error
+// connect establishes a connection to the server at addr.
+func (t *Tunnel) connect(addr string) error {
+	t.setState(TunnelStateConnecting)
 
-	// Check if server address is a WebSocket URL
-	if strings.HasPrefix(t.config.ServerAddr, "ws://") || strings.HasPrefix(t.config.ServerAddr, "wss://") {
-		conn, err = t.dialWebSocket()
-	} else if strings.Contains(t.config.ServerAddr, "://") {
-		// HTTP/HTTPS URL - convert to WebSocket
-		wsURL := strings.Replace(t.config.ServerAddr, "https://", "wss://", 1)
-		wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
-		if !strings.HasSuffix(wsURL, "/tunnel") {
-			wsURL = strings.TrimSuffix(wsURL, "/") + "/tunnel"
-		}
-		t.config.ServerAddr = wsURL
-		conn, err = t.dialWebSocket()
-	} else {
-		// Raw TCP connection
-		conn, err = t.dialTCP()
-	}
+	t.logger.Info("connecting to server", slog.String("addr", addr))
 
+	transport, err := t.transportFor(addr)
 	if err != nil {
 		t.setState(TunnelStateDisconnected)
-		return fmt.Errorf("failed to connect to server: %w", err)
+		return err
 	}
-	t.conn = conn
 
-	// Create yamux session (client mode)
-	yamuxConfig := t.defaultYamuxConfig()
-	muxSession, err := yamux.Client(conn, yamuxConfig)
-	if err != nil {
-		conn.Close()
-		t.setState(TunnelStateDisconnected)
-		return fmt.Errorf("failed to create yamux session: %w", err)
+	var (
+		conn       net.Conn
+		muxSession StreamMux
+	)
+	if muxTransport, ok := transport.(MuxTransport); ok {
+		// The transport multiplexes streams itself
+		muxSession, err = muxTransport.DialMux(t.ctx, addr)
+		if err != nil {
+			t.setState(TunnelStateDisconnected)
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+	} else {
+		conn, err = transport.Dial(t.ctx, addr)
+		if err != nil {
+			t.setState(TunnelStateDisconnected)
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+
+		// Create yamux session (client mode)
+		yamuxConfig := t.defaultYamuxConfig()
+		muxSession, err = yamux.Client(conn, yamuxConfig)
+		if err != nil {
+			conn.Close()
+			t.setState(TunnelStateDisconnected)
+			return fmt.Errorf("failed to create yamux session: %w", err)
+		}
 	}
-	t.muxSession = muxSession
 
 	// Perform handshake
-	if err := t.performHandshake(); err != nil {
+	sessionID, err := t.performHandshake(muxSession)
+	if err != nil {
 		muxSession.Close()
-		conn.Close()
+		if conn != nil {
+			conn.Close()
+		}
 		t.setState(TunnelStateDisconnected)
 		return fmt.Errorf("handshake failed: %w", err)
 	}
 
+	t.setSession(conn, muxSession, sessionID)
 	t.setState(TunnelStateConnected)
-	t.logger.Info("connected to server", slog.String("session_id", t.sessionID))
+	t.logger.Info("connected to server", slog.String("session_id", sessionID))
 
 	return nil
 }
 
-// dialTCP establishes a raw TCP connection to the server.
-func (t *Tunnel) dialTCP() (net.Conn, error) {
-	return net.DialTimeout("tcp", t.config.ServerAddr, 10*time.Second)
+// session returns the stream multiplexer of the current connection, or nil
+// if there is none.
+func (t *Tunnel) session() StreamMux {
+	t.connMu.RLock()
+	defer t.connMu.RUnlock()
+	return t.muxSession
 }
 
-// dialWebSocket establishes a WebSocket connection to the server.
-func (t *Tunnel) dialWebSocket() (net.Conn, error) {
-	t.logger.Debug("connecting via WebSocket", slog.String("url", t.config.ServerAddr))
-
-	// Parse and validate URL
-	u, err := url.Parse(t.config.ServerAddr)
-	if err != nil {
-		return nil, fmt.Errorf("invalid WebSocket URL: %w", err)
-	}
-
-	// Ensure path ends with /tunnel
-	if !strings.HasSuffix(u.Path, "/tunnel") {
-		u.Path = strings.TrimSuffix(u.Path, "/") + "/tunnel"
-	}
-
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 10 * time.Second,
-	}
-
-	ws, _, err := dialer.Dial(u.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("WebSocket dial failed: %w", err)
-	}
-
-	return common.NewWSConn(ws), nil
+// setSession makes a handshaken connection current. A nil muxSession
+// clears it.
+func (t *Tunnel) setSession(conn net.Conn, muxSession StreamMux, sessionID string) {
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+	t.conn = conn
+	t.muxSession = muxSession
+	t.sessionID = sessionID
 }
 
-// performHandshake performs the initial handshake with the server.
-func (t *Tunnel) performHandshake() error {
+// performHandshake performs the initial handshake with the server over
+// muxSession and returns the session ID it assigned.
+func (t *Tunnel) performHandshake(muxSession StreamMux) (string, error) {
 	// Open a stream for handshake
-	stream, err := t.muxSession.Open()
+	stream, err := muxSession.Open()
 	if err != nil {
-		return fmt.Errorf("failed to open handshake stream: %w", err)
+		return "", fmt.Errorf("failed to open handshake stream: %w", err)
 	}
 	defer stream.Close()
 
@@ -233,34 +290,39 @@ func (t *Tunnel) performHandshake() error {
 		Version:  protocol.ProtocolVersion,
 		Token:    t.config.Token,
 		ClientID: t.config.ClientID,
-		Tunnels:  t.config.Tunnels,
+		Tunnels:  t.Tunnels(),
 	}
 
 	// Send handshake
 	if err := codec.SendHandshake(request); err != nil {
-		return fmt.Errorf("failed to send handshake: %w", err)
+		return "", fmt.Errorf("failed to send handshake: %w", err)
 	}
 
 	// Read response
 	envelope, err := codec.ReadMessage()
 	if err != nil {
-		return fmt.Errorf("failed to read handshake response: %w", err)
+		return "", fmt.Errorf("failed to read handshake response: %w", err)
 	}
 
 	if envelope.Type != protocol.MessageTypeHandshakeResponse {
-		return fmt.Errorf("unexpected message type: %s", envelope.Type)
+		return "", fmt.Errorf("unexpected message type: %s", envelope.Type)
 	}
 
 	var response protocol.HandshakeResponse
 	if err := envelope.DecodePayload(&response); err != nil {
-		return fmt.Errorf("failed to decode handshake response: %w", err)
+		return "", fmt.Errorf("failed to decode handshake response: %w", err)
 	}
 
 	if !response.Success {
-		return fmt.Errorf("handshake rejected: %s (code: %s)", response.Error, response.ErrorCode)
+		// Say why when the server rejected every tunnel
+		for _, status := range response.Tunnels {
+			if status.Error != "" {
+				return "", fmt.Errorf("handshake rejected: %s (%s: %s)", response.Error, status.Subdomain, status.Error)
+			}
+		}
+		return "", fmt.Errorf("handshake rejected: %s (code: %s)", response.Error, response.ErrorCode)
 	}
 
-	t.sessionID = response.SessionID
 	t.mu.Lock()
 	t.tunnelStatus = response.Tunnels
 	t.mu.Unlock()
@@ -279,24 +341,15 @@ func (t *Tunnel) performHandshake() error {
 		}
 	}
 
-	return nil
+	return response.SessionID, nil
 }
 
-// Run starts the tunnel and blocks until closed.
+// Run starts the tunnel and blocks until interrupted or closed.
 func (t *Tunnel) Run() error {
-	// Initial connection
-	if err := t.Connect(); err != nil {
-		if t.reconnect != nil {
-			t.logger.Warn("initial connection failed, will retry", slog.Any("error", err))
-		} else {
-			return err
-		}
+	if err := t.Start(); err != nil {
+		return err
 	}
 
-	// Start accepting streams
-	t.wg.Add(1)
-	go t.acceptLoop()
-
 	// Wait for interrupt or context cancellation
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -311,6 +364,46 @@ func (t *Tunnel) Run() error {
 	return t.Close()
 }
 
+// Start connects, unless Connect has already succeeded, and serves streams
+// in the background until Close. If the first connection fails, it is
+// retried when reconnects are enabled and returned otherwise.
+func (t *Tunnel) Start() error {
+	// Start local metrics and status server
+	if t.config.LocalServer.Enabled {
+		t.localServer = NewLocalServer(t, t.logger)
+		if err := t.localServer.Start(t.config.LocalServer.Addr); err != nil {
+			return fmt.Errorf("failed to start local server: %w", err)
+		}
+	}
+
+	// Initial connection, unless tunnels will only be added later
+	switch {
+	case t.State() == TunnelStateConnected:
+		// Already connected by the caller
+	case len(t.Tunnels()) == 0:
+		t.logger.Info("no tunnels configured, waiting for one to be added")
+	default:
+		if err := t.Connect(); err != nil {
+			if t.reconnect == nil {
+				return err
+			}
+			t.logger.Warn("initial connection failed, will retry", slog.Any("error", err))
+		}
+	}
+
+	// Start accepting streams
+	t.wg.Add(1)
+	go t.acceptLoop()
+
+	// Return to the preferred server when it recovers
+	if t.reconnect != nil && t.config.Failover.FailbackInterval > 0 && len(t.config.Servers()) > 1 {
+		t.wg.Add(1)
+		go t.failBackLoop()
+	}
+
+	return nil
+}
+
 // acceptLoop accepts incoming streams from the server.
 func (t *Tunnel) acceptLoop() {
 	defer t.wg.Done()
@@ -323,18 +416,25 @@ func (t *Tunnel) acceptLoop() {
 		}
 
 		// Check if we need to reconnect
-		if t.State() == TunnelStateDisconnected && t.reconnect != nil {
+		state := t.State()
+		if (state == TunnelStateDisconnected || state == TunnelStateFailingOver) && t.reconnect != nil {
+			if len(t.Tunnels()) == 0 {
+				// Nothing to serve until a tunnel is added
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
 			t.handleReconnect()
 			continue
 		}
 
-		if t.muxSession == nil {
+		muxSession := t.session()
+		if muxSession == nil {
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
 		// Accept stream with timeout
-		stream, err := t.muxSession.AcceptStream()
+		stream, err := muxSession.Accept()
 		if err != nil {
 			select {
 			case <-t.ctx.Done():
@@ -342,9 +442,17 @@ func (t *Tunnel) acceptLoop() {
 			default:
 			}
 
-			if t.muxSession.IsClosed() {
+			if muxSession.IsClosed() {
+				// Release what the connection still holds, such as QUIC sockets
+				muxSession.Close()
+				t.setSession(nil, nil, "")
+				if t.State() == TunnelStateFailingOver {
+					// failBackLoop closed it to move to the preferred server
+					continue
+				}
 				t.logger.Warn("connection lost")
 				t.setState(TunnelStateDisconnected)
+				t.serverFailed()
 				continue
 			}
 
@@ -363,17 +471,32 @@ func (t *Tunnel) acceptLoop() {
 
 // handleStream handles an incoming stream from the server.
 func (t *Tunnel) handleStream(stream net.Conn) {
-	defer stream.Close()
-
 	startTime := time.Now()
 
 	// Read stream header
 	header, err := protocol.ReadStreamHeader(stream)
 	if err != nil {
 		t.logger.Error("failed to read stream header", slog.Any("error", err))
+		stream.Close()
 		return
 	}
 
+	// Control streams carry a message from the server, not proxied traffic
+	if header.Type == protocol.StreamTypeControl {
+		t.handleControlStream(stream)
+		return
+	}
+
+	// A stream handler takes over the stream, including closing it
+	t.mu.RLock()
+	handler := t.streamHandler
+	t.mu.RUnlock()
+	if handler != nil {
+		handler(stream, header)
+		return
+	}
+	defer stream.Close()
+
 	logger := t.logger.With(
 		slog.String("request_id", header.RequestID),
 		slog.String("subdomain", header.Subdomain),
@@ -382,6 +505,17 @@ func (t *Tunnel) handleStream(stream net.Conn) {
 
 	logger.Debug("handling request")
 
+	// Join the server's trace for this request
+	ctx, span := tracer.Start(telemetry.ExtractStreamHeader(t.ctx, header), "Tunnel.handleStream",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("gotunnel.request_id", header.RequestID),
+			attribute.String("gotunnel.subdomain", header.Subdomain),
+			attribute.String("http.request.method", header.Method),
+			attribute.String("url.path", header.Path),
+		))
+	defer span.End()
+
 	// Create request info
 	info := RequestInfo{
 		ID:        header.RequestID,
@@ -396,8 +530,10 @@ func (t *Tunnel) handleStream(stream net.Conn) {
 	t.notifyRequest(info)
 
 	// Forward to local service
-	if err := t.router.Forward(stream, header); err != nil {
+	if err := t.router.Forward(ctx, stream, header); err != nil {
 		logger.Error("failed to forward request", slog.Any("error", err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to forward request")
 		info.Duration = time.Since(startTime)
 		info.Status = 502 // Bad Gateway
 		t.notifyRequest(info)
@@ -409,17 +545,23 @@ func (t *Tunnel) handleStream(stream net.Conn) {
 	logger.Debug("request completed")
 }
 
-// handleReconnect handles reconnection with exponential backoff.
+// handleReconnect handles reconnection with exponential backoff. A server
+// just failed over to is tried at once, except when failing over has
+// wrapped around to the preferred server during an outage.
 func (t *Tunnel) handleReconnect() {
-	t.setState(TunnelStateReconnecting)
+	failingOver := t.State() == TunnelStateFailingOver &&
+		(!t.servers.OnPreferred() || t.reconnect.Attempts() == 0)
+	if !failingOver {
+		t.setState(TunnelStateReconnecting)
 
-	delay := t.reconnect.NextDelay()
-	t.logger.Info("reconnecting", slog.Duration("delay", delay))
+		delay := t.reconnect.NextDelay()
+		t.logger.Info("reconnecting", slog.Duration("delay", delay))
 
-	select {
-	case <-time.After(delay):
-	case <-t.ctx.Done():
-		return
+		select {
+		case <-time.After(delay):
+		case <-t.ctx.Done():
+			return
+		}
 	}
 
 	if err := t.Connect(); err != nil {
@@ -439,21 +581,32 @@ func (t *Tunnel) Close() error {
 
 	var errs []error
 
+	// Stop local server
+	if t.localServer != nil {
+		if err := t.localServer.Stop(5 * time.Second); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop local server: %w", err))
+		}
+	}
+
 	// Close router (drains connection pools)
 	if t.router != nil {
 		t.router.Close()
 	}
 
-	// Close yamux session
-	if t.muxSession != nil {
-		if err := t.muxSession.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("failed to close yamux session: %w", err))
+	t.connMu.RLock()
+	conn, muxSession := t.conn, t.muxSession
+	t.connMu.RUnlock()
+
+	// Close the stream multiplexer
+	if muxSession != nil {
+		if err := muxSession.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close stream multiplexer: %w", err))
 		}
 	}
 
-	// Close connection
-	if t.conn != nil {
-		if err := t.conn.Close(); err != nil {
+	// Close connection; closing the yamux session usually closed it already
+	if conn != nil {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			errs = append(errs, fmt.Errorf("failed to close connection: %w", err))
 		}
 	}
@@ -461,6 +614,13 @@ func (t *Tunnel) Close() error {
 	// Wait for goroutines
 	t.wg.Wait()
 
+	// Flush buffered spans
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := t.tracing(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush traces: %w", err))
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("errors during close: %v", errs)
 	}
@@ -501,6 +661,15 @@ func (t *Tunnel) OnRequest(handler RequestHandler) {
 	t.requestHandlers = append(t.requestHandlers, handler)
 }
 
+// SetStreamHandler makes the tunnel hand streams to handler instead of
+// forwarding them to local ports. Request handlers are not called for
+// these streams.
+func (t *Tunnel) SetStreamHandler(handler StreamHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.streamHandler = handler
+}
+
 // notifyRequest notifies all request handlers.
 func (t *Tunnel) notifyRequest(info RequestInfo) {
 	t.mu.RLock()
@@ -517,11 +686,19 @@ func (t *Tunnel) notifyRequest(info RequestInfo) {
 func (t *Tunnel) GetTunnelStatus() []protocol.TunnelStatus {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	return t.tunnelStatus
+	return append([]protocol.TunnelStatus(nil), t.tunnelStatus...)
+}
+
+// ServerAddr returns the address of the server the tunnel is connected or
+// connecting to.
+func (t *Tunnel) ServerAddr() string {
+	return t.servers.Current()
 }
 
 // SessionID returns the current session ID.
 func (t *Tunnel) SessionID() string {
+	t.connMu.RLock()
+	defer t.connMu.RUnlock()
 	return t.sessionID
 }
 
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// tunnelUpdateTimeout bounds a single add or remove round trip.
+const tunnelUpdateTimeout = 10 * time.Second
+
+// Tunnels returns the tunnels this client serves. They are sent in every
+// handshake, so changes made with AddTunnel and RemoveTunnel survive
+// reconnects.
+func (t *Tunnel) Tunnels() []protocol.TunnelConfig {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]protocol.TunnelConfig(nil), t.config.Tunnels...)
+}
+
+// AddTunnel starts serving another tunnel. On a live session the server
+// registers it straight away; otherwise it is sent with the next handshake.
+// Adding a subdomain that is already served replaces its settings.
+func (t *Tunnel) AddTunnel(tc protocol.TunnelConfig) (protocol.TunnelStatus, error) {
+	if err := tc.Validate(); err != nil {
+		return protocol.TunnelStatus{}, fmt.Errorf("invalid tunnel: %w", err)
+	}
+
+	status := protocol.TunnelStatus{
+		Subdomain: strings.ToLower(tc.Subdomain),
+		LocalPort: tc.LocalPort,
+		Status:    "pending",
+	}
+	if t.State() == TunnelStateConnected {
+		resp, err := t.sendTunnelUpdate(protocol.MessageTypeAddTunnel, &protocol.AddTunnelRequest{Tunnel: tc})
+		if err != nil {
+			return status, err
+		}
+		if !resp.Success {
+			return resp.Tunnel, fmt.Errorf("server rejected tunnel %s: %s", tc.Subdomain, resp.Error)
+		}
+		status = resp.Tunnel
+	}
+
+	t.mu.Lock()
+	oldAddr := ""
+	if i := t.tunnelIndexLocked(tc.Subdomain); i >= 0 {
+		oldAddr = localAddr(t.config.Tunnels[i].LocalHost, t.config.Tunnels[i].LocalPort)
+		t.config.Tunnels[i] = tc
+	} else {
+		t.config.Tunnels = append(t.config.Tunnels, tc)
+	}
+	t.setTunnelStatusLocked(status)
+	t.syncPoolsLocked(oldAddr, localAddr(tc.LocalHost, tc.LocalPort))
+	t.mu.Unlock()
+
+	t.logger.Info("tunnel added",
+		slog.String("subdomain", status.Subdomain),
+		slog.Int("local_port", tc.LocalPort),
+		slog.String("status", status.Status))
+	return status, nil
+}
+
+// RemoveTunnel stops serving a tunnel. It returns ErrTunnelNotFound if the
+// client does not serve the subdomain.
+func (t *Tunnel) RemoveTunnel(subdomain string) error {
+	t.mu.RLock()
+	i := t.tunnelIndexLocked(subdomain)
+	active := false
+	for _, status := range t.tunnelStatus {
+		if strings.EqualFold(status.Subdomain, subdomain) {
+			active = status.Status == "active"
+		}
+	}
+	t.mu.RUnlock()
+	if i < 0 {
+		return protocol.ErrTunnelNotFound
+	}
+
+	// Tunnels the server rejected were never registered on the session
+	if active && t.State() == TunnelStateConnected {
+		resp, err := t.sendTunnelUpdate(protocol.MessageTypeRemoveTunnel, &protocol.RemoveTunnelRequest{Subdomain: subdomain})
+		if err != nil {
+			return err
+		}
+		if !resp.Success {
+			return fmt.Errorf("server refused to remove tunnel %s: %s", subdomain, resp.Error)
+		}
+	}
+
+	t.forgetTunnel(subdomain)
+	t.logger.Info("tunnel removed", slog.String("subdomain", subdomain))
+	return nil
+}
+
+// forgetTunnel drops a tunnel from the configuration and status, so it is
+// not sent with later handshakes, and releases its connection pool.
+func (t *Tunnel) forgetTunnel(subdomain string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if i := t.tunnelIndexLocked(subdomain); i >= 0 {
+		addr := localAddr(t.config.Tunnels[i].LocalHost, t.config.Tunnels[i].LocalPort)
+		t.config.Tunnels = append(t.config.Tunnels[:i], t.config.Tunnels[i+1:]...)
+		t.syncPoolsLocked(addr, "")
+	}
+	statuses := t.tunnelStatus[:0:0]
+	for _, status := range t.tunnelStatus {
+		if !strings.EqualFold(status.Subdomain, subdomain) {
+			statuses = append(statuses, status)
+		}
+	}
+	t.tunnelStatus = statuses
+}
+
+// handleControlStream reads a control message the server sent on its own
+// stream and acts on it.
+func (t *Tunnel) handleControlStream(stream net.Conn) {
+	defer stream.Close()
+
+	if err := stream.SetDeadline(time.Now().Add(tunnelUpdateTimeout)); err != nil {
+		t.logger.Error("failed to set deadline", slog.Any("error", err))
+		return
+	}
+	envelope, err := protocol.NewCodec(stream, stream).ReadMessage()
+	if err != nil {
+		t.logger.Warn("failed to read control message", slog.Any("error", err))
+		return
+	}
+
+	switch envelope.Type {
+	case protocol.MessageTypeTunnelClosed:
+		var msg protocol.TunnelClosedMessage
+		if err := envelope.DecodePayload(&msg); err != nil {
+			t.logger.Warn("invalid tunnel closed payload", slog.Any("error", err))
+			return
+		}
+		// The server no longer routes the subdomain; stop offering it so a
+		// reconnect does not register it again
+		t.forgetTunnel(msg.Subdomain)
+		t.logger.Warn("tunnel closed by server",
+			slog.String("subdomain", msg.Subdomain),
+			slog.String("reason", msg.Reason))
+	default:
+		t.logger.Warn("unexpected control message", slog.String("type", string(envelope.Type)))
+	}
+}
+
+// sendTunnelUpdate sends an add or remove request on a new stream and waits
+// for the server's answer.
+func (t *Tunnel) sendTunnelUpdate(msgType protocol.MessageType, payload interface{}) (*protocol.TunnelUpdateResponse, error) {
+	muxSession := t.session()
+	if muxSession == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	stream, err := muxSession.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open control stream: %w", err)
+	}
+	defer stream.Close()
+
+	if err := stream.SetDeadline(time.Now().Add(tunnelUpdateTimeout)); err != nil {
+		return nil, fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	envelope, err := protocol.NewEnvelope(msgType, "", payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s envelope: %w", msgType, err)
+	}
+	codec := protocol.NewCodec(stream, stream)
+	if err := codec.WriteMessage(envelope); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", msgType, err)
+	}
+
+	reply, err := codec.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tunnel update: %w", err)
+	}
+	if reply.Type != protocol.MessageTypeTunnelUpdate {
+		return nil, fmt.Errorf("unexpected message type: %s", reply.Type)
+	}
+
+	var resp protocol.TunnelUpdateResponse
+	if err := reply.DecodePayload(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode tunnel update: %w", err)
+	}
+	return &resp, nil
+}
+
+// tunnelIndexLocked returns the position of a subdomain in the configured
+// tunnels, or -1. Caller must hold t.mu.
+func (t *Tunnel) tunnelIndexLocked(subdomain string) int {
+	for i, tc := range t.config.Tunnels {
+		if strings.EqualFold(tc.Subdomain, subdomain) {
+			return i
+		}
+	}
+	return -1
+}
+
+// setTunnelStatusLocked records the status of one tunnel. Caller must hold
+// t.mu.
+func (t *Tunnel) setTunnelStatusLocked(status protocol.TunnelStatus) {
+	for i, existing := range t.tunnelStatus {
+		if strings.EqualFold(existing.Subdomain, status.Subdomain) {
+			t.tunnelStatus[i] = status
+			return
+		}
+	}
+	t.tunnelStatus = append(t.tunnelStatus, status)
+}
+
+// syncPoolsLocked keeps one connection pool per local address in use after
+// a tunnel moved from oldAddr to newAddr. It drops the pool for oldAddr once
+// no tunnel uses it and creates one for newAddr if missing; empty means no
+// address. Caller must hold t.mu.
+func (t *Tunnel) syncPoolsLocked(oldAddr, newAddr string) {
+	inUse := make(map[string]bool)
+	for _, tc := range t.config.Tunnels {
+		inUse[localAddr(tc.LocalHost, tc.LocalPort)] = true
+	}
+
+	if oldAddr != "" && !inUse[oldAddr] {
+		t.router.RemovePool(oldAddr)
+	}
+	if newAddr != "" {
+		if _, exists := t.router.GetPoolStats()[newAddr]; !exists {
+			t.router.AddPool(newAddr)
+		}
+	}
+}
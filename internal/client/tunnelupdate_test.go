@@ -0,0 +1,99 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+func TestTunnel_AddRemoveOffline(t *testing.T) {
+	server := newFakeServer(t)
+	cfg := common.DefaultClientConfig()
+	cfg.ServerAddr = server.Addr()
+	cfg.Token = "test-token"
+	tunnel := newTestTunnel(t, cfg)
+
+	// Without a session, tunnels wait for the next handshake
+	status, err := tunnel.AddTunnel(protocol.TunnelConfig{Subdomain: "Web", LocalPort: 3000})
+	if err != nil {
+		t.Fatalf("AddTunnel() error = %v", err)
+	}
+	if status.Status != "pending" || status.Subdomain != "web" {
+		t.Errorf("AddTunnel() = %+v, want web pending", status)
+	}
+	if _, err := tunnel.AddTunnel(protocol.TunnelConfig{Subdomain: "api", LocalPort: 4000}); err != nil {
+		t.Fatalf("AddTunnel() error = %v", err)
+	}
+	if _, err := tunnel.AddTunnel(protocol.TunnelConfig{Subdomain: "bad"}); err == nil {
+		t.Error("AddTunnel() accepted a tunnel without a port")
+	}
+
+	if err := tunnel.RemoveTunnel("api"); err != nil {
+		t.Fatalf("RemoveTunnel() error = %v", err)
+	}
+	if err := tunnel.RemoveTunnel("api"); !errors.Is(err, protocol.ErrTunnelNotFound) {
+		t.Errorf("RemoveTunnel() of a removed tunnel error = %v, want ErrTunnelNotFound", err)
+	}
+
+	if err := tunnel.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if !server.Registered("web") || server.Registered("api") {
+		t.Error("handshake did not carry exactly the tunnels added offline")
+	}
+	if statuses := tunnel.GetTunnelStatus(); len(statuses) != 1 || statuses[0].Status != "active" {
+		t.Errorf("GetTunnelStatus() = %+v, want web active", statuses)
+	}
+}
+
+func TestTunnel_PoolsPerLocalAddress(t *testing.T) {
+	cfg := common.DefaultClientConfig()
+	cfg.ServerAddr = "127.0.0.1:1"
+	cfg.Tunnels = []protocol.TunnelConfig{{Subdomain: "web", LocalPort: 3000}}
+	tunnel := newTestTunnel(t, cfg)
+
+	// Same port, different host
+	if _, err := tunnel.AddTunnel(protocol.TunnelConfig{Subdomain: "db", LocalHost: "10.0.0.5", LocalPort: 3000}); err != nil {
+		t.Fatalf("AddTunnel() error = %v", err)
+	}
+	pools := tunnel.router.GetPoolStats()
+	if pools["127.0.0.1:3000"].Address != "127.0.0.1:3000" || pools["10.0.0.5:3000"].Address != "10.0.0.5:3000" {
+		t.Fatalf("pools = %v, want one per local address", pools)
+	}
+
+	if err := tunnel.RemoveTunnel("db"); err != nil {
+		t.Fatalf("RemoveTunnel() error = %v", err)
+	}
+	pools = tunnel.router.GetPoolStats()
+	if _, ok := pools["10.0.0.5:3000"]; ok || len(pools) != 1 {
+		t.Errorf("pools after removing db = %v, want only web's", pools)
+	}
+}
+
+func TestTunnel_ClosedByServer(t *testing.T) {
+	server := newFakeServer(t)
+	cfg := common.DefaultClientConfig()
+	cfg.ServerAddr = server.Addr()
+	cfg.Token = "test-token"
+	cfg.LocalServer.Enabled = false
+	cfg.Tunnels = []protocol.TunnelConfig{{Subdomain: "web", LocalPort: 3000}, {Subdomain: "api", LocalPort: 4000}}
+	tunnel := newTestTunnel(t, cfg)
+	if err := tunnel.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	server.CloseTunnel("api", "unregistered by an administrator")
+
+	waitFor(t, "api to be dropped", func() bool { return len(tunnel.GetTunnelStatus()) == 1 })
+	if tunnels := tunnel.Tunnels(); len(tunnels) != 1 || tunnels[0].Subdomain != "web" {
+		t.Errorf("Tunnels() = %+v, want only web", tunnels)
+	}
+	if _, ok := tunnel.router.GetPoolStats()["127.0.0.1:4000"]; ok {
+		t.Error("pool for api's port still open")
+	}
+	if tunnel.State() != TunnelStateConnected {
+		t.Errorf("State() = %v, want the session kept for web", tunnel.State())
+	}
+}
@@ -0,0 +1,154 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CredentialsEnv overrides where client credentials are stored.
+const CredentialsEnv = "GOTUNNEL_CREDENTIALS"
+
+// Credentials are stored by "gotunnel login" and used when a client config
+// has no token of its own.
+type Credentials struct {
+	// Server is the URL the credentials were issued by.
+	Server string `yaml:"server"`
+
+	// Token authenticates tunnel connections and API requests.
+	Token string `yaml:"token"`
+
+	// UserID and Email identify the logged-in user.
+	UserID string `yaml:"user_id,omitempty"`
+	Email  string `yaml:"email,omitempty"`
+}
+
+// CredentialsPath returns the credentials file, $GOTUNNEL_CREDENTIALS or
+// gotunnel/credentials.yaml in the user's config directory.
+func CredentialsPath() (string, error) {
+	if path := os.Getenv(CredentialsEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	return filepath.Join(dir, "gotunnel", "credentials.yaml"), nil
+}
+
+// LoadCredentials reads the stored credentials. It returns nil if the user
+// has not logged in.
+func LoadCredentials() (*Credentials, error) {
+	path, err := CredentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var creds Credentials
+	if err := yaml.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	return &creds, nil
+}
+
+// SaveCredentials writes credentials readable only by the current user.
+func SaveCredentials(creds *Credentials) error {
+	path, err := CredentialsPath()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	// Write a new file so a pre-existing one with looser permissions is
+	// never reused
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	return nil
+}
+
+// RemoveCredentials deletes the stored credentials. It returns an error
+// wrapping os.ErrNotExist if there were none.
+func RemoveCredentials() error {
+	path, err := CredentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove credentials: %w", err)
+	}
+	return nil
+}
+
+// UseStoredToken fills in an empty Token from the stored credentials, if
+// they were issued by the same host as the first of Servers.
+func (c *ClientConfig) UseStoredToken() error {
+	if c.Token != "" {
+		return nil
+	}
+	creds, err := LoadCredentials()
+	if err != nil || creds == nil {
+		return err
+	}
+	if SameServerHost(creds.Server, c.Servers()[0]) {
+		c.Token = creds.Token
+	}
+	return nil
+}
+
+// SameServerHost reports whether two server addresses, given as URLs or
+// host:port, name the same host.
+func SameServerHost(a, b string) bool {
+	hostA, hostB := serverHost(a), serverHost(b)
+	return hostA != "" && strings.EqualFold(hostA, hostB)
+}
+
+// serverHost returns the host name of a URL or host:port address.
+func serverHost(addr string) string {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentials_SaveLoadRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gotunnel", "credentials.yaml")
+	t.Setenv(CredentialsEnv, path)
+
+	if creds, err := LoadCredentials(); err != nil || creds != nil {
+		t.Fatalf("LoadCredentials() = %v, %v, want nil before login", creds, err)
+	}
+
+	want := &Credentials{Server: "https://tunnel.example.com", Token: "secret", Email: "a@example.com"}
+	if err := SaveCredentials(want); err != nil {
+		t.Fatalf("SaveCredentials() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("credentials mode = %o, want 600", perm)
+	}
+
+	got, err := LoadCredentials()
+	if err != nil || got == nil || *got != *want {
+		t.Fatalf("LoadCredentials() = %+v, %v, want %+v", got, err, want)
+	}
+
+	if err := RemoveCredentials(); err != nil {
+		t.Fatalf("RemoveCredentials() error = %v", err)
+	}
+	if err := RemoveCredentials(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("second RemoveCredentials() error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestClientConfig_UseStoredToken(t *testing.T) {
+	t.Setenv(CredentialsEnv, filepath.Join(t.TempDir(), "credentials.yaml"))
+	if err := SaveCredentials(&Credentials{Server: "https://tunnel.example.com", Token: "stored"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		server string
+		token  string
+		want   string
+	}{
+		{"same host over websocket", "wss://tunnel.example.com/tunnel", "", "stored"},
+		{"same host over tcp", "tunnel.example.com:9000", "", "stored"},
+		{"explicit token wins", "wss://tunnel.example.com", "flag", "flag"},
+		{"other server", "wss://other.example.com", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ClientConfig{ServerAddr: tt.server, Token: tt.token}
+			if err := cfg.UseStoredToken(); err != nil {
+				t.Fatalf("UseStoredToken() error = %v", err)
+			}
+			if cfg.Token != tt.want {
+				t.Errorf("Token = %q, want %q", cfg.Token, tt.want)
+			}
+		})
+	}
+}
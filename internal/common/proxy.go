@@ -0,0 +1,200 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// Dialer connects to the tunnel server directly or through an HTTP CONNECT
+// or SOCKS5 proxy.
+type Dialer struct {
+	// proxyFor returns the proxy for a target URL, or nil to connect
+	// directly.
+	proxyFor func(target *url.URL) (*url.URL, error)
+
+	// forward makes the TCP connections to proxies and direct targets.
+	forward *net.Dialer
+
+	// timeout bounds the proxy handshake when the context has no deadline.
+	timeout time.Duration
+}
+
+// NewDialer returns a dialer that connects through proxyURL. If proxyURL is
+// empty it uses the proxy named by HTTPS_PROXY or HTTP_PROXY, except for
+// hosts matched by NO_PROXY.
+func NewDialer(proxyURL string, timeout time.Duration) (*Dialer, error) {
+	d := &Dialer{forward: &net.Dialer{Timeout: timeout}, timeout: timeout}
+
+	if proxyURL == "" {
+		proxyFunc := httpproxy.FromEnvironment().ProxyFunc()
+		d.proxyFor = proxyFunc
+		return d, nil
+	}
+
+	u, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	d.proxyFor = func(*url.URL) (*url.URL, error) { return u, nil }
+	return d, nil
+}
+
+// ParseProxyURL parses and checks a proxy URL: http, https, socks5 or
+// socks5h, with optional user:password credentials.
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", proxyURL)
+	}
+	return u, nil
+}
+
+// Proxy returns the proxy used to reach addr on behalf of a scheme ("http"
+// or "https") connection, or nil for a direct connection. Raw TCP and TLS
+// connections count as https.
+func (d *Dialer) Proxy(scheme, addr string) (*url.URL, error) {
+	return d.proxyFor(&url.URL{Scheme: scheme, Host: addr})
+}
+
+// DialContext connects to addr (host:port) on behalf of a scheme
+// connection, through the proxy if one applies.
+func (d *Dialer) DialContext(ctx context.Context, scheme, addr string) (net.Conn, error) {
+	proxyURL, err := d.Proxy(scheme, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine proxy: %w", err)
+	}
+	if proxyURL == nil {
+		return d.forward.DialContext(ctx, "tcp", addr)
+	}
+
+	// A proxy that accepts the connection but never answers would otherwise
+	// hang the dial
+	if _, ok := ctx.Deadline(); !ok && d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	var conn net.Conn
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		conn, err = d.dialSOCKS5(ctx, proxyURL, addr)
+	default:
+		conn, err = d.dialConnect(ctx, proxyURL, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	return conn, nil
+}
+
+// dialSOCKS5 connects to addr through a SOCKS5 proxy. The proxy resolves
+// host names.
+func (d *Dialer) dialSOCKS5(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, d.forward)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+}
+
+// dialConnect connects to addr through an HTTP proxy with a CONNECT
+// request, over TLS for an https proxy.
+func (d *Dialer) dialConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	// Bound the CONNECT exchange by the context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		// The server spoke first; keep what was read past the response
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose first reads drain a bufio.Reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads from the buffer, then the connection.
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,319 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoServer accepts connections and echoes what they send.
+func echoServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// connectProxy is a stand-in HTTP proxy that serves CONNECT requests carrying
+// the given Proxy-Authorization.
+func connectProxy(t *testing.T, wantAuth string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect {
+					io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != wantAuth {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// socks5Proxy is a stand-in SOCKS5 proxy that requires username/password
+// authentication and supports CONNECT to domain names and IPv4 addresses.
+func socks5Proxy(t *testing.T, user, password string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, user, password)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn, user, password string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// Greeting: offer username/password authentication only
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, header[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+
+	// RFC 1929 username/password
+	version, _ := r.ReadByte()
+	ulen, _ := r.ReadByte()
+	u := make([]byte, ulen)
+	io.ReadFull(r, u)
+	plen, _ := r.ReadByte()
+	p := make([]byte, plen)
+	io.ReadFull(r, p)
+	if version != 1 || string(u) != user || string(p) != password {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	// CONNECT request
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := r.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		host = string(name)
+	default:
+		return
+	}
+	portBytes := make([]byte, 2)
+	io.ReadFull(r, portBytes)
+	port := binary.BigEndian.Uint16(portBytes)
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+// assertEcho checks that conn reaches the echo server.
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("echo = %q, want ping", buf)
+	}
+}
+
+func TestDialer_Proxies(t *testing.T) {
+	target := echoServer(t)
+	httpProxy := connectProxy(t, "Basic dXNlcjpzZWNyZXQ=") // user:secret
+	socksProxy := socks5Proxy(t, "user", "secret")
+
+	tests := []struct {
+		name     string
+		proxyURL string
+		target   string
+		wantErr  bool
+	}{
+		{"http connect", "http://user:secret@" + httpProxy, target, false},
+		{"http connect wrong password", "http://user:wrong@" + httpProxy, target, true},
+		{"socks5", "socks5://user:secret@" + socksProxy, target, false},
+		{"socks5 host name", "socks5h://user:secret@" + socksProxy, strings.Replace(target, "127.0.0.1", "localhost", 1), false},
+		{"socks5 wrong password", "socks5://user:wrong@" + socksProxy, target, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer, err := NewDialer(tt.proxyURL, 2*time.Second)
+			if err != nil {
+				t.Fatalf("NewDialer() error = %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			conn, err := dialer.DialContext(ctx, "https", tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if strings.Contains(err.Error(), "secret") || strings.Contains(err.Error(), "wrong") {
+					t.Errorf("error %q leaks the proxy password", err)
+				}
+				return
+			}
+			defer conn.Close()
+			assertEcho(t, conn)
+		})
+	}
+}
+
+func TestDialer_Environment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://secure-proxy:3128")
+	t.Setenv("HTTP_PROXY", "http://plain-proxy:3128")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	dialer, err := NewDialer("", time.Second)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+
+	tests := []struct {
+		scheme string
+		addr   string
+		want   string
+	}{
+		{"https", "tunnel.example.com:9000", "secure-proxy:3128"},
+		{"http", "tunnel.example.com:80", "plain-proxy:3128"},
+		{"https", "tunnel.internal.example.com:9000", ""},
+	}
+	for _, tt := range tests {
+		proxyURL, err := dialer.Proxy(tt.scheme, tt.addr)
+		if err != nil {
+			t.Fatalf("Proxy(%s, %s) error = %v", tt.scheme, tt.addr, err)
+		}
+		got := ""
+		if proxyURL != nil {
+			got = proxyURL.Host
+		}
+		if got != tt.want {
+			t.Errorf("Proxy(%s, %s) = %q, want %q", tt.scheme, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestDialer_StalledProxy(t *testing.T) {
+	// The proxy accepts connections and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	for _, scheme := range []string{"http", "socks5"} {
+		t.Run(scheme, func(t *testing.T) {
+			dialer, err := NewDialer(scheme+"://"+ln.Addr().String(), 200*time.Millisecond)
+			if err != nil {
+				t.Fatalf("NewDialer() error = %v", err)
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				conn, err := dialer.DialContext(context.Background(), "https", "tunnel.example.com:9000")
+				if conn != nil {
+					conn.Close()
+				}
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("DialContext() through a stalled proxy succeeded")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("DialContext() through a stalled proxy did not time out")
+			}
+		})
+	}
+}
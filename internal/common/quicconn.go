@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// QUICProtocol is the ALPN protocol negotiated for tunnel connections over
+// QUIC.
+const QUICProtocol = "gotunnel"
+
+// quicStreamOpenTimeout matches the yamux stream open timeout.
+const quicStreamOpenTimeout = 30 * time.Second
+
+// QUICConfig returns the QUIC settings shared by client and server.
+func QUICConfig() *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:     90 * time.Second,
+		KeepAlivePeriod:    30 * time.Second,
+		MaxIncomingStreams: 1024,
+	}
+}
+
+// QUICSession adapts a QUIC connection to the stream multiplexer methods of
+// yamux.Session, so tunnel streams are native QUIC streams. A stalled
+// stream then does not block the others, and the connection survives the
+// client changing networks.
+type QUICSession struct {
+	conn *quic.Conn
+}
+
+// NewQUICSession creates a new QUICSession wrapper.
+func NewQUICSession(conn *quic.Conn) *QUICSession {
+	return &QUICSession{conn: conn}
+}
+
+// Conn returns the underlying QUIC connection.
+func (s *QUICSession) Conn() *quic.Conn {
+	return s.conn
+}
+
+// Open opens a new stream to the peer.
+func (s *QUICSession) Open() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(s.conn.Context(), quicStreamOpenTimeout)
+	defer cancel()
+
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &QUICStream{Stream: stream, conn: s.conn}, nil
+}
+
+// Accept waits for the peer to open a stream.
+func (s *QUICSession) Accept() (net.Conn, error) {
+	stream, err := s.conn.AcceptStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &QUICStream{Stream: stream, conn: s.conn}, nil
+}
+
+// Close closes the connection and all its streams.
+func (s *QUICSession) Close() error {
+	return s.conn.CloseWithError(0, "")
+}
+
+// IsClosed reports whether the connection is closed.
+func (s *QUICSession) IsClosed() bool {
+	return s.conn.Context().Err() != nil
+}
+
+// LocalAddr returns the local network address.
+func (s *QUICSession) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (s *QUICSession) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// QUICStream wraps a QUIC stream to implement the net.Conn interface.
+type QUICStream struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+// Close closes both directions of the stream, like closing a net.Conn: it
+// stops reading and finishes writing.
+func (s *QUICStream) Close() error {
+	s.Stream.CancelRead(0)
+	return s.Stream.Close()
+}
+
+// LocalAddr returns the local network address.
+func (s *QUICStream) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (s *QUICStream) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
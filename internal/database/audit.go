@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a security-relevant action. Events are append-only:
+// this package offers no way to modify or delete them.
+type AuditEvent struct {
+	ID             string            `json:"id"`
+	OrganizationID string            `json:"organization_id,omitempty"`
+	ActorID        string            `json:"actor_id,omitempty"`
+	Action         string            `json:"action"`
+	TargetType     string            `json:"target_type,omitempty"`
+	TargetID       string            `json:"target_id,omitempty"`
+	RemoteAddr     string            `json:"remote_addr,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// AuditFilter selects audit events. Zero fields match everything.
+type AuditFilter struct {
+	OrganizationID string
+	ActorID        string
+	TargetID       string
+
+	// Action matches exactly, or as a prefix when it ends in "." (for
+	// example "org." matches every organization event).
+	Action string
+
+	Since time.Time
+	Until time.Time
+
+	Limit  int
+	Offset int
+}
+
+// InsertAuditEvent appends an event, assigning its ID and timestamp if unset.
+func (db *DB) InsertAuditEvent(event *AuditEvent) error {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	var metadata sql.NullString
+	if len(event.Metadata) > 0 {
+		data, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit metadata: %w", err)
+		}
+		metadata = sql.NullString{String: string(data), Valid: true}
+	}
+
+	_, err := db.Exec(`INSERT INTO audit_events
+		(id, organization_id, actor_id, action, target_type, target_id, remote_addr, metadata, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.ID, nullString(event.OrganizationID), nullString(event.ActorID), event.Action,
+		nullString(event.TargetType), nullString(event.TargetID), nullString(event.RemoteAddr),
+		metadata, event.CreatedAt)
+	return err
+}
+
+// ListAuditEvents returns matching events, newest first.
+func (db *DB) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	var where []string
+	var args []any
+	if filter.OrganizationID != "" {
+		where = append(where, "organization_id = ?")
+		args = append(args, filter.OrganizationID)
+	}
+	if filter.ActorID != "" {
+		where = append(where, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+	if filter.TargetID != "" {
+		where = append(where, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+	if strings.HasSuffix(filter.Action, ".") {
+		where = append(where, "action LIKE ?")
+		args = append(args, filter.Action+"%")
+	} else if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := `SELECT id, organization_id, actor_id, action, target_type, target_id, remote_addr, metadata, created_at
+		FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		var orgID, actorID, targetType, targetID, remoteAddr, metadata sql.NullString
+		if err := rows.Scan(&event.ID, &orgID, &actorID, &event.Action, &targetType,
+			&targetID, &remoteAddr, &metadata, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.OrganizationID = orgID.String
+		event.ActorID = actorID.String
+		event.TargetType = targetType.String
+		event.TargetID = targetID.String
+		event.RemoteAddr = remoteAddr.String
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &event.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to decode audit metadata: %w", err)
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
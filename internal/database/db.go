@@ -6,109 +6,64 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// DB is the application store. Queries are written once with ? placeholders
+// and rebound for the underlying dialect.
 type DB struct {
 	*sql.DB
+	dialect Dialect
+
+	requestLogs RequestLogOptions
 }
 
+// New opens a SQLite database at path, or a Postgres database if path is a
+// postgres:// URL, with default pool settings.
 func New(path string) (*DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	return Open(path, Options{})
+}
+
+// Open opens the database addressed by dsn and applies pending migrations,
+// unless opts.SkipMigrations is set. It fails with ErrSchemaTooNew if the
+// database was migrated by a newer release.
+func Open(dsn string, opts Options) (*DB, error) {
+	dialect, driverDSN := ParseDSN(dsn)
+
+	db, err := sql.Open(dialect.driverName(), driverDSN)
 	if err != nil {
 		return nil, err
 	}
-	
+
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
+
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	if err := migrate(db); err != nil {
-		return nil, fmt.Errorf("migration failed: %w", err)
-	}
-
-	return &DB{db}, nil
-}
-
-func migrate(db *sql.DB) error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id TEXT PRIMARY KEY,
-			email TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			is_admin BOOLEAN DEFAULT FALSE,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);`,
-		`CREATE TABLE IF NOT EXISTS api_tokens (
-			id TEXT PRIMARY KEY,
-			user_id TEXT NOT NULL,
-			token_hash TEXT NOT NULL,
-			name TEXT,
-			last_used_at DATETIME,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(user_id) REFERENCES users(id)
-		);`,
-		// Organizations/Teams table
-		`CREATE TABLE IF NOT EXISTS organizations (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			slug TEXT UNIQUE NOT NULL,
-			owner_id TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(owner_id) REFERENCES users(id)
-		);`,
-		// Organization membership (many-to-many)
-		`CREATE TABLE IF NOT EXISTS organization_members (
-			id TEXT PRIMARY KEY,
-			organization_id TEXT NOT NULL,
-			user_id TEXT NOT NULL,
-			role TEXT NOT NULL DEFAULT 'member',
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(organization_id, user_id),
-			FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
-			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
-		// Subdomains can belong to user OR organization
-		`CREATE TABLE IF NOT EXISTS subdomains (
-			id TEXT PRIMARY KEY,
-			user_id TEXT,
-			organization_id TEXT,
-			subdomain TEXT UNIQUE NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(user_id) REFERENCES users(id),
-			FOREIGN KEY(organization_id) REFERENCES organizations(id)
-		);`,
-		`CREATE TABLE IF NOT EXISTS request_logs (
-			id TEXT PRIMARY KEY,
-			subdomain TEXT NOT NULL,
-			method TEXT,
-			path TEXT,
-			status_code INTEGER,
-			duration_ms INTEGER,
-			client_ip TEXT,
-			user_agent TEXT,
-			request_headers TEXT,
-			response_headers TEXT,
-			request_body TEXT,
-			response_body TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);`,
-		// Create indexes for better query performance
-		`CREATE INDEX IF NOT EXISTS idx_request_logs_subdomain ON request_logs(subdomain);`,
-		`CREATE INDEX IF NOT EXISTS idx_request_logs_created_at ON request_logs(created_at);`,
-		`CREATE INDEX IF NOT EXISTS idx_subdomains_user_id ON subdomains(user_id);`,
-		`CREATE INDEX IF NOT EXISTS idx_subdomains_organization_id ON subdomains(organization_id);`,
-		`CREATE INDEX IF NOT EXISTS idx_org_members_org_id ON organization_members(organization_id);`,
-		`CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON organization_members(user_id);`,
-	}
-
-	for _, q := range queries {
-		if _, err := db.Exec(q); err != nil {
-			return err
+	store := &DB{DB: db, dialect: dialect}
+	if !opts.SkipMigrations {
+		if _, err := store.MigrateUp(0); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migration failed: %w", err)
 		}
 	}
-	return nil
+
+	return store, nil
 }
 
 // --- User Methods ---
@@ -136,6 +91,17 @@ func (db *DB) CreateUser(email, password string) (*User, error) {
 	return &User{ID: id, Email: email, CreatedAt: time.Now()}, nil
 }
 
+// GetUser retrieves a user by ID.
+func (db *DB) GetUser(id string) (*User, error) {
+	var user User
+	err := db.QueryRow("SELECT id, email, is_admin, created_at FROM users WHERE id = ?", id).Scan(
+		&user.ID, &user.Email, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (db *DB) AuthenticateUser(email, password string) (*User, error) {
 	var user User
 	var hash string
@@ -159,6 +125,26 @@ func (db *DB) UserExists(userID string) bool {
 	return err == nil && count > 0
 }
 
+// IsUserAdmin checks if a user has server-wide admin rights.
+func (db *DB) IsUserAdmin(userID string) bool {
+	var isAdmin bool
+	err := db.QueryRow("SELECT is_admin FROM users WHERE id = ?", userID).Scan(&isAdmin)
+	return err == nil && isAdmin
+}
+
+// SetUserAdmin grants or revokes server-wide admin rights for the user with
+// the given email. It returns sql.ErrNoRows if there is no such user.
+func (db *DB) SetUserAdmin(email string, isAdmin bool) error {
+	result, err := db.Exec("UPDATE users SET is_admin = ? WHERE email = ?", isAdmin, email)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // --- Subdomain Methods ---
 
 func (db *DB) ReserveSubdomain(userID, subdomain string) error {
@@ -168,13 +154,35 @@ func (db *DB) ReserveSubdomain(userID, subdomain string) error {
 	return err
 }
 
+// GetSubdomainOwner returns the user who reserved a subdomain, or empty if
+// it is unreserved or reserved by an organization.
 func (db *DB) GetSubdomainOwner(subdomain string) (string, error) {
-	var userID string
-	err := db.QueryRow("SELECT user_id FROM subdomains WHERE subdomain = ?", subdomain).Scan(&userID)
+	owner, err := db.GetSubdomainOwnership(subdomain)
+	if err != nil || owner == nil {
+		return "", err
+	}
+	return owner.UserID, nil
+}
+
+// SubdomainOwnership records who reserved a subdomain. Exactly one of
+// UserID and OrganizationID is set.
+type SubdomainOwnership struct {
+	UserID         string `json:"user_id,omitempty"`
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+// GetSubdomainOwnership returns who reserved a subdomain, or nil if nobody has.
+func (db *DB) GetSubdomainOwnership(subdomain string) (*SubdomainOwnership, error) {
+	var userID, orgID sql.NullString
+	err := db.QueryRow("SELECT user_id, organization_id FROM subdomains WHERE subdomain = ?", subdomain).
+		Scan(&userID, &orgID)
 	if err == sql.ErrNoRows {
-		return "", nil // Not reserved
+		return nil, nil // Not reserved
+	}
+	if err != nil {
+		return nil, err
 	}
-	return userID, err
+	return &SubdomainOwnership{UserID: userID.String, OrganizationID: orgID.String}, nil
 }
 
 func (db *DB) GetUserSubdomains(userID string) ([]string, error) {
@@ -209,10 +217,27 @@ type OrganizationMember struct {
 	ID             string    `json:"id"`
 	OrganizationID string    `json:"organization_id"`
 	UserID         string    `json:"user_id"`
-	Role           string    `json:"role"` // "owner", "admin", "member"
+	Role           string    `json:"role"` // one of the Role constants
 	CreatedAt      time.Time `json:"created_at"`
 }
 
+// Organization member roles, from most to least privileged.
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+)
+
+// IsValidRole reports whether role is one of the defined member roles.
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleOwner, RoleAdmin, RoleDeveloper, RoleViewer:
+		return true
+	}
+	return false
+}
+
 // CreateOrganization creates a new organization and adds the creator as owner.
 func (db *DB) CreateOrganization(name, slug, ownerID string) (*Organization, error) {
 	id := uuid.New().String()
@@ -236,7 +261,7 @@ func (db *DB) CreateOrganization(name, slug, ownerID string) (*Organization, err
 	memberID := uuid.New().String()
 	_, err = tx.Exec(
 		"INSERT INTO organization_members (id, organization_id, user_id, role, created_at) VALUES (?, ?, ?, ?, ?)",
-		memberID, id, ownerID, "owner", now)
+		memberID, id, ownerID, RoleOwner, now)
 	if err != nil {
 		return nil, err
 	}
@@ -319,6 +344,30 @@ func (db *DB) RemoveOrganizationMember(orgID, userID string) error {
 	return err
 }
 
+// UpdateOrganizationMemberRole changes a member's role. It returns
+// sql.ErrNoRows if the user is not a member.
+func (db *DB) UpdateOrganizationMemberRole(orgID, userID, role string) error {
+	res, err := db.Exec(
+		"UPDATE organization_members SET role = ? WHERE organization_id = ? AND user_id = ?",
+		role, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CountOrganizationOwners returns how many members hold the owner role.
+func (db *DB) CountOrganizationOwners(orgID string) (int, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM organization_members WHERE organization_id = ? AND role = ?",
+		orgID, RoleOwner).Scan(&count)
+	return count, err
+}
+
 // GetOrganizationMembers retrieves all members of an organization.
 func (db *DB) GetOrganizationMembers(orgID string) ([]OrganizationMember, error) {
 	rows, err := db.Query(`
@@ -387,6 +436,261 @@ func (db *DB) GetOrganizationSubdomains(orgID string) ([]string, error) {
 	return subs, nil
 }
 
+// --- Limit Overrides ---
+
+// LimitOverride holds per-user or per-organization overrides of server-wide limits.
+// A nil field inherits the next level (organization, then server config).
+type LimitOverride struct {
+	MaxConnections  *int   `json:"max_connections"`
+	MaxTunnels      *int   `json:"max_tunnels"`
+	MaxMonthlyBytes *int64 `json:"max_monthly_bytes"`
+}
+
+// SetUserLimitOverride creates or replaces the limit override for a user.
+func (db *DB) SetUserLimitOverride(userID string, o *LimitOverride) error {
+	_, err := db.Exec(`
+		INSERT INTO limit_overrides (id, user_id, max_connections, max_tunnels, max_monthly_bytes, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			max_connections = excluded.max_connections,
+			max_tunnels = excluded.max_tunnels,
+			max_monthly_bytes = excluded.max_monthly_bytes,
+			updated_at = excluded.updated_at`,
+		uuid.New().String(), userID, o.MaxConnections, o.MaxTunnels, o.MaxMonthlyBytes, time.Now())
+	return err
+}
+
+// SetOrganizationLimitOverride creates or replaces the limit override for an organization.
+func (db *DB) SetOrganizationLimitOverride(orgID string, o *LimitOverride) error {
+	_, err := db.Exec(`
+		INSERT INTO limit_overrides (id, organization_id, max_connections, max_tunnels, max_monthly_bytes, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(organization_id) DO UPDATE SET
+			max_connections = excluded.max_connections,
+			max_tunnels = excluded.max_tunnels,
+			max_monthly_bytes = excluded.max_monthly_bytes,
+			updated_at = excluded.updated_at`,
+		uuid.New().String(), orgID, o.MaxConnections, o.MaxTunnels, o.MaxMonthlyBytes, time.Now())
+	return err
+}
+
+// GetUserLimitOverride returns the limit override stored for a user.
+// Returns an empty override if none is set.
+func (db *DB) GetUserLimitOverride(userID string) (*LimitOverride, error) {
+	return db.getLimitOverride("SELECT max_connections, max_tunnels, max_monthly_bytes FROM limit_overrides WHERE user_id = ?", userID)
+}
+
+// GetOrganizationLimitOverride returns the limit override stored for an organization.
+// Returns an empty override if none is set.
+func (db *DB) GetOrganizationLimitOverride(orgID string) (*LimitOverride, error) {
+	return db.getLimitOverride("SELECT max_connections, max_tunnels, max_monthly_bytes FROM limit_overrides WHERE organization_id = ?", orgID)
+}
+
+// DeleteUserLimitOverride removes the limit override for a user.
+func (db *DB) DeleteUserLimitOverride(userID string) error {
+	_, err := db.Exec("DELETE FROM limit_overrides WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteOrganizationLimitOverride removes the limit override for an organization.
+func (db *DB) DeleteOrganizationLimitOverride(orgID string) error {
+	_, err := db.Exec("DELETE FROM limit_overrides WHERE organization_id = ?", orgID)
+	return err
+}
+
+// GetEffectiveLimitOverride resolves the override that applies to a user.
+// Fields set on the user win; unset fields take the most generous value
+// among the organizations the user belongs to, where 0 (unlimited) beats
+// any positive limit.
+func (db *DB) GetEffectiveLimitOverride(userID string) (*LimitOverride, error) {
+	override, err := db.GetUserLimitOverride(userID)
+	if err != nil {
+		return nil, err
+	}
+	if override.MaxConnections != nil && override.MaxTunnels != nil && override.MaxMonthlyBytes != nil {
+		return override, nil
+	}
+
+	orgOverride, err := db.getLimitOverride(`
+		SELECT
+			CASE WHEN MIN(lo.max_connections) = 0 THEN 0 ELSE MAX(lo.max_connections) END,
+			CASE WHEN MIN(lo.max_tunnels) = 0 THEN 0 ELSE MAX(lo.max_tunnels) END,
+			CASE WHEN MIN(lo.max_monthly_bytes) = 0 THEN 0 ELSE MAX(lo.max_monthly_bytes) END
+		FROM limit_overrides lo
+		JOIN organization_members om ON lo.organization_id = om.organization_id
+		WHERE om.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if override.MaxConnections == nil {
+		override.MaxConnections = orgOverride.MaxConnections
+	}
+	if override.MaxTunnels == nil {
+		override.MaxTunnels = orgOverride.MaxTunnels
+	}
+	if override.MaxMonthlyBytes == nil {
+		override.MaxMonthlyBytes = orgOverride.MaxMonthlyBytes
+	}
+	return override, nil
+}
+
+func (db *DB) getLimitOverride(query string, arg string) (*LimitOverride, error) {
+	var maxConns, maxTunnels, maxMonthly sql.NullInt64
+	err := db.QueryRow(query, arg).Scan(&maxConns, &maxTunnels, &maxMonthly)
+	if err == sql.ErrNoRows {
+		return &LimitOverride{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	override := &LimitOverride{}
+	if maxConns.Valid {
+		v := int(maxConns.Int64)
+		override.MaxConnections = &v
+	}
+	if maxTunnels.Valid {
+		v := int(maxTunnels.Int64)
+		override.MaxTunnels = &v
+	}
+	if maxMonthly.Valid {
+		v := maxMonthly.Int64
+		override.MaxMonthlyBytes = &v
+	}
+	return override, nil
+}
+
+// --- Transfer Usage ---
+
+// Transfer subject types for monthly usage accounting.
+const (
+	TransferSubjectUser         = "user"
+	TransferSubjectOrganization = "organization"
+)
+
+// TransferUsage holds the bytes transferred by a user or organization in a period.
+type TransferUsage struct {
+	SubjectType string `json:"subject_type"`
+	SubjectID   string `json:"subject_id"`
+	Period      string `json:"period"` // "YYYY-MM"
+	BytesIn     int64  `json:"bytes_in"`
+	BytesOut    int64  `json:"bytes_out"`
+}
+
+// AddTransferUsage adds transferred bytes to the running total for a period.
+func (db *DB) AddTransferUsage(subjectType, subjectID, period string, bytesIn, bytesOut int64) error {
+	_, err := db.Exec(`
+		INSERT INTO transfer_usage (id, subject_type, subject_id, period, bytes_in, bytes_out, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(subject_type, subject_id, period) DO UPDATE SET
+			bytes_in = transfer_usage.bytes_in + excluded.bytes_in,
+			bytes_out = transfer_usage.bytes_out + excluded.bytes_out,
+			updated_at = excluded.updated_at`,
+		uuid.New().String(), subjectType, subjectID, period, bytesIn, bytesOut, time.Now())
+	return err
+}
+
+// GetTransferUsage returns the bytes transferred by a subject in a period.
+func (db *DB) GetTransferUsage(subjectType, subjectID, period string) (*TransferUsage, error) {
+	usage := &TransferUsage{
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		Period:      period,
+	}
+	err := db.QueryRow(
+		"SELECT bytes_in, bytes_out FROM transfer_usage WHERE subject_type = ? AND subject_id = ? AND period = ?",
+		subjectType, subjectID, period).Scan(&usage.BytesIn, &usage.BytesOut)
+	if err == sql.ErrNoRows {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// GetSubdomainOrganization returns the organization that reserved a subdomain, or empty.
+func (db *DB) GetSubdomainOrganization(subdomain string) (string, error) {
+	var orgID sql.NullString
+	err := db.QueryRow("SELECT organization_id FROM subdomains WHERE subdomain = ?", subdomain).Scan(&orgID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return orgID.String, err
+}
+
+// --- Cluster Leases ---
+
+// HeartbeatNode records that a node is alive and reachable at addr for ttl.
+func (db *DB) HeartbeatNode(nodeID, addr string, ttl time.Duration) error {
+	_, err := db.Exec(`
+		INSERT INTO cluster_nodes (node_id, addr, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(node_id) DO UPDATE SET addr = excluded.addr, expires_at = excluded.expires_at`,
+		nodeID, addr, leaseExpiry(ttl))
+	return err
+}
+
+// ClaimSubdomainLease leases a subdomain to a node for ttl. It returns false
+// if another node holds an unexpired lease.
+func (db *DB) ClaimSubdomainLease(subdomain, nodeID string, ttl time.Duration) (bool, error) {
+	result, err := db.Exec(`
+		INSERT INTO subdomain_leases (subdomain, node_id, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(subdomain) DO UPDATE SET node_id = excluded.node_id, expires_at = excluded.expires_at
+		WHERE subdomain_leases.node_id = excluded.node_id OR subdomain_leases.expires_at < ?`,
+		subdomain, nodeID, leaseExpiry(ttl), time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// RenewSubdomainLeases extends every lease held by a node.
+func (db *DB) RenewSubdomainLeases(nodeID string, ttl time.Duration) error {
+	_, err := db.Exec("UPDATE subdomain_leases SET expires_at = ? WHERE node_id = ?", leaseExpiry(ttl), nodeID)
+	return err
+}
+
+// ReleaseSubdomainLease releases a subdomain lease if the node holds it.
+func (db *DB) ReleaseSubdomainLease(subdomain, nodeID string) error {
+	_, err := db.Exec("DELETE FROM subdomain_leases WHERE subdomain = ? AND node_id = ?", subdomain, nodeID)
+	return err
+}
+
+// ReleaseNodeLeases removes a node and every lease it holds.
+func (db *DB) ReleaseNodeLeases(nodeID string) error {
+	if _, err := db.Exec("DELETE FROM subdomain_leases WHERE node_id = ?", nodeID); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM cluster_nodes WHERE node_id = ?", nodeID)
+	return err
+}
+
+// GetSubdomainLeaseHolder returns the live node holding a subdomain and its
+// address, or empty strings if no live node holds it.
+func (db *DB) GetSubdomainLeaseHolder(subdomain string) (string, string, error) {
+	now := time.Now().Unix()
+	var nodeID, addr string
+	err := db.QueryRow(`
+		SELECT l.node_id, n.addr FROM subdomain_leases l
+		JOIN cluster_nodes n ON n.node_id = l.node_id
+		WHERE l.subdomain = ? AND l.expires_at >= ? AND n.expires_at >= ?`,
+		subdomain, now, now).Scan(&nodeID, &addr)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return nodeID, addr, err
+}
+
+// leaseExpiry returns the Unix time a lease taken now for ttl expires.
+func leaseExpiry(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).Unix()
+}
+
 // --- Request Logging ---
 
 // RequestLog represents a logged HTTP request.
@@ -403,20 +707,34 @@ type RequestLog struct {
 	ResponseHeaders string    `json:"response_headers,omitempty"`
 	RequestBody     string    `json:"request_body,omitempty"`
 	ResponseBody    string    `json:"response_body,omitempty"`
+	BodyTruncated   bool      `json:"body_truncated,omitempty"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
 // LogRequestFull logs a request with full details (for request inspector).
+// Bodies are truncated and compressed according to SetRequestLogOptions.
 func (db *DB) LogRequestFull(log *RequestLog) {
 	// Fire and forget (don't block)
 	go func() {
+		reqBody, reqTruncated := truncateBody(log.RequestBody, db.requestLogs.MaxBodySize)
+		respBody, respTruncated := truncateBody(log.ResponseBody, db.requestLogs.MaxBodySize)
+
+		encoding := bodyEncodingNone
+		if db.requestLogs.CompressBodies {
+			encoding = bodyEncodingGzip
+			reqBody = encodeBody(encoding, reqBody)
+			respBody = encodeBody(encoding, respBody)
+		}
+
 		db.Exec(`INSERT INTO request_logs
 			(id, subdomain, method, path, status_code, duration_ms, client_ip, user_agent,
-			 request_headers, response_headers, request_body, response_body, created_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			 request_headers, response_headers, request_body, response_body,
+			 body_encoding, body_truncated, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			uuid.New().String(), log.Subdomain, log.Method, log.Path, log.StatusCode,
 			log.DurationMs, log.ClientIP, log.UserAgent, log.RequestHeaders,
-			log.ResponseHeaders, log.RequestBody, log.ResponseBody, time.Now())
+			log.ResponseHeaders, reqBody, respBody,
+			encoding, reqTruncated || respTruncated, time.Now().UTC())
 	}()
 }
 
@@ -428,17 +746,43 @@ func (db *DB) LogRequest(subdomain, method, path string, status, duration int) {
 	}()
 }
 
-// GetRequestLogs retrieves request logs for a subdomain with pagination.
-func (db *DB) GetRequestLogs(subdomain string, limit, offset int) ([]RequestLog, error) {
-	rows, err := db.Query(`
-		SELECT id, subdomain, method, path, status_code, duration_ms,
+// requestLogColumns are the columns scanned by scanRequestLog.
+const requestLogColumns = `id, subdomain, method, path, status_code, duration_ms,
 		       COALESCE(client_ip, '') as client_ip,
 		       COALESCE(user_agent, '') as user_agent,
 		       COALESCE(request_headers, '') as request_headers,
 		       COALESCE(response_headers, '') as response_headers,
 		       COALESCE(request_body, '') as request_body,
 		       COALESCE(response_body, '') as response_body,
-		       created_at
+		       COALESCE(body_encoding, '') as body_encoding,
+		       COALESCE(body_truncated, FALSE) as body_truncated,
+		       created_at`
+
+// scanRequestLog scans a row of requestLogColumns, decoding stored bodies.
+func scanRequestLog(scan func(dest ...any) error) (*RequestLog, error) {
+	var log RequestLog
+	var encoding string
+	if err := scan(&log.ID, &log.Subdomain, &log.Method, &log.Path,
+		&log.StatusCode, &log.DurationMs, &log.ClientIP, &log.UserAgent,
+		&log.RequestHeaders, &log.ResponseHeaders, &log.RequestBody,
+		&log.ResponseBody, &encoding, &log.BodyTruncated, &log.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if log.RequestBody, err = decodeBody(encoding, log.RequestBody); err != nil {
+		return nil, fmt.Errorf("failed to decode request body: %w", err)
+	}
+	if log.ResponseBody, err = decodeBody(encoding, log.ResponseBody); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return &log, nil
+}
+
+// GetRequestLogs retrieves request logs for a subdomain with pagination.
+func (db *DB) GetRequestLogs(subdomain string, limit, offset int) ([]RequestLog, error) {
+	rows, err := db.Query(`
+		SELECT `+requestLogColumns+`
 		FROM request_logs
 		WHERE subdomain = ?
 		ORDER BY created_at DESC
@@ -450,37 +794,18 @@ func (db *DB) GetRequestLogs(subdomain string, limit, offset int) ([]RequestLog,
 
 	var logs []RequestLog
 	for rows.Next() {
-		var log RequestLog
-		if err := rows.Scan(&log.ID, &log.Subdomain, &log.Method, &log.Path,
-			&log.StatusCode, &log.DurationMs, &log.ClientIP, &log.UserAgent,
-			&log.RequestHeaders, &log.ResponseHeaders, &log.RequestBody,
-			&log.ResponseBody, &log.CreatedAt); err != nil {
+		log, err := scanRequestLog(rows.Scan)
+		if err != nil {
 			return nil, err
 		}
-		logs = append(logs, log)
+		logs = append(logs, *log)
 	}
 	return logs, nil
 }
 
 // GetRequestLog retrieves a single request log by ID.
 func (db *DB) GetRequestLog(id string) (*RequestLog, error) {
-	var log RequestLog
-	err := db.QueryRow(`
-		SELECT id, subdomain, method, path, status_code, duration_ms,
-		       COALESCE(client_ip, '') as client_ip,
-		       COALESCE(user_agent, '') as user_agent,
-		       COALESCE(request_headers, '') as request_headers,
-		       COALESCE(response_headers, '') as response_headers,
-		       COALESCE(request_body, '') as request_body,
-		       COALESCE(response_body, '') as response_body,
-		       created_at
-		FROM request_logs WHERE id = ?`, id).Scan(
-		&log.ID, &log.Subdomain, &log.Method, &log.Path,
-		&log.StatusCode, &log.DurationMs, &log.ClientIP, &log.UserAgent,
-		&log.RequestHeaders, &log.ResponseHeaders, &log.RequestBody,
-		&log.ResponseBody, &log.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &log, nil
-}
\ No newline at end of file
+	return scanRequestLog(db.QueryRow(`
+		SELECT `+requestLogColumns+`
+		FROM request_logs WHERE id = ?`, id).Scan)
+}
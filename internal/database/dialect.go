@@ -0,0 +1,126 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect identifies the SQL flavour of the underlying database.
+type Dialect string
+
+const (
+	// DialectSQLite is an embedded SQLite file (the default).
+	DialectSQLite Dialect = "sqlite"
+
+	// DialectPostgres is a PostgreSQL server.
+	DialectPostgres Dialect = "postgres"
+)
+
+// Options holds connection-pool settings. Zero values keep the driver defaults.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// SkipMigrations opens the database without migrating it, for tools
+	// that manage migrations explicitly.
+	SkipMigrations bool
+}
+
+// ParseDSN determines the dialect and driver DSN for a database address.
+// postgres:// and postgresql:// URLs select Postgres; anything else is
+// treated as a SQLite path, with an optional sqlite:// prefix.
+func ParseDSN(dsn string) (Dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return DialectPostgres, dsn
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return DialectSQLite, strings.TrimPrefix(dsn, "sqlite://")
+	default:
+		return DialectSQLite, dsn
+	}
+}
+
+// driverName returns the database/sql driver registered for a dialect.
+func (d Dialect) driverName() string {
+	if d == DialectPostgres {
+		return "pgx"
+	}
+	return "sqlite3"
+}
+
+// Rebind rewrites ? placeholders into the dialect's native form.
+// Queries in this package are written with ? and must not contain
+// literal question marks.
+func (d Dialect) Rebind(query string) string {
+	if d != DialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Dialect returns the SQL dialect of the database.
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+// Exec executes a query written with ? placeholders.
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.Rebind(query), args...)
+}
+
+// Query runs a query written with ? placeholders.
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.Rebind(query), args...)
+}
+
+// QueryRow runs a single-row query written with ? placeholders.
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.Rebind(query), args...)
+}
+
+// Begin starts a transaction whose queries are rebound for the dialect.
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// Tx is a transaction that accepts ? placeholders.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+// Exec executes a query written with ? placeholders.
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.Rebind(query), args...)
+}
+
+// Query runs a query written with ? placeholders.
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.Rebind(query), args...)
+}
+
+// QueryRow runs a single-row query written with ? placeholders.
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.Rebind(query), args...)
+}
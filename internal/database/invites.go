@@ -0,0 +1,217 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invite states.
+const (
+	InviteStatusPending  = "pending"
+	InviteStatusAccepted = "accepted"
+	InviteStatusDeclined = "declined"
+)
+
+var (
+	// ErrInviteNotFound is returned for unknown invite tokens.
+	ErrInviteNotFound = errors.New("invite not found")
+
+	// ErrInviteExpired is returned for invites past their expiry.
+	ErrInviteExpired = errors.New("invite has expired")
+
+	// ErrInviteUsed is returned for invites already accepted or declined.
+	ErrInviteUsed = errors.New("invite has already been used")
+)
+
+// Invite is an invitation for an email address to join an organization.
+type Invite struct {
+	ID             string     `json:"id"`
+	OrganizationID string     `json:"organization_id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	InvitedBy      string     `json:"invited_by"`
+	Status         string     `json:"status"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	RespondedAt    *time.Time `json:"responded_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Expired reports whether a pending invite can no longer be used.
+func (i *Invite) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+// hashInviteToken returns the stored form of an invite token.
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateInvite invites an email address to an organization. It returns the
+// invite and the single-use token to send to the invitee; only a hash of the
+// token is stored.
+func (db *DB) CreateInvite(orgID, email, role, invitedBy string, ttl time.Duration) (*Invite, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	now := time.Now().UTC()
+	invite := &Invite{
+		ID:             uuid.New().String(),
+		OrganizationID: orgID,
+		Email:          strings.ToLower(strings.TrimSpace(email)),
+		Role:           role,
+		InvitedBy:      invitedBy,
+		Status:         InviteStatusPending,
+		ExpiresAt:      now.Add(ttl),
+		CreatedAt:      now,
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO organization_invites
+			(id, organization_id, email, role, token_hash, invited_by, status, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invite.ID, invite.OrganizationID, invite.Email, invite.Role, hashInviteToken(token),
+		invite.InvitedBy, invite.Status, invite.ExpiresAt, invite.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return invite, token, nil
+}
+
+const inviteColumns = `id, organization_id, email, role, invited_by, status, expires_at, responded_at, created_at`
+
+func scanInvite(scan func(dest ...any) error) (*Invite, error) {
+	var invite Invite
+	var respondedAt sql.NullTime
+	if err := scan(&invite.ID, &invite.OrganizationID, &invite.Email, &invite.Role,
+		&invite.InvitedBy, &invite.Status, &invite.ExpiresAt, &respondedAt, &invite.CreatedAt); err != nil {
+		return nil, err
+	}
+	if respondedAt.Valid {
+		invite.RespondedAt = &respondedAt.Time
+	}
+	return &invite, nil
+}
+
+// GetInviteByToken returns the invite for a token.
+func (db *DB) GetInviteByToken(token string) (*Invite, error) {
+	invite, err := scanInvite(db.QueryRow(
+		"SELECT "+inviteColumns+" FROM organization_invites WHERE token_hash = ?",
+		hashInviteToken(token)).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrInviteNotFound
+	}
+	return invite, err
+}
+
+// GetOrganizationInvites lists an organization's invites, newest first.
+func (db *DB) GetOrganizationInvites(orgID string) ([]Invite, error) {
+	rows, err := db.Query(
+		"SELECT "+inviteColumns+" FROM organization_invites WHERE organization_id = ? ORDER BY created_at DESC",
+		orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		invite, err := scanInvite(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+	return invites, rows.Err()
+}
+
+// AcceptInvite marks an invite accepted and adds the user to the
+// organization with the invited role. Users who are already members keep
+// their current role.
+func (db *DB) AcceptInvite(token, userID string) (*Invite, error) {
+	invite, err := db.GetInviteByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.acceptInvite(invite, userID); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// DeclineInvite marks an invite declined.
+func (db *DB) DeclineInvite(token string) (*Invite, error) {
+	invite, err := db.GetInviteByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.respondToInvite(nil, invite, InviteStatusDeclined); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (db *DB) acceptInvite(invite *Invite, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.respondToInvite(tx, invite, InviteStatusAccepted); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (id, organization_id, user_id, role, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(organization_id, user_id) DO NOTHING`,
+		uuid.New().String(), invite.OrganizationID, userID, invite.Role, time.Now())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// respondToInvite moves a pending invite to status, in tx if given. The
+// status check is part of the update so a token can only be used once.
+func (db *DB) respondToInvite(tx *Tx, invite *Invite, status string) error {
+	if invite.Status != InviteStatusPending {
+		return ErrInviteUsed
+	}
+	if invite.Expired() {
+		return ErrInviteExpired
+	}
+
+	now := time.Now().UTC()
+	query := "UPDATE organization_invites SET status = ?, responded_at = ? WHERE id = ? AND status = ?"
+	args := []any{status, now, invite.ID, InviteStatusPending}
+
+	var res sql.Result
+	var err error
+	if tx != nil {
+		res, err = tx.Exec(query, args...)
+	} else {
+		res, err = db.Exec(query, args...)
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInviteUsed
+	}
+
+	invite.Status = status
+	invite.RespondedAt = &now
+	return nil
+}
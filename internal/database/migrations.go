@@ -0,0 +1,478 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this build does not know about, i.e. it was migrated by a newer release.
+var ErrSchemaTooNew = errors.New("database schema is newer than this server supports")
+
+// migrationLockID is the Postgres advisory lock key serializing migrations
+// when several servers start against the same database.
+const migrationLockID = 7231837021
+
+// Migration is a numbered, reversible schema change. Statements are run in
+// order inside a single transaction.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// MigrationState describes a known migration and whether it is applied.
+type MigrationState struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// migrations is the ordered schema history. Append new migrations with the
+// next version number; never edit one that has been released. Column types
+// are chosen to work unchanged on both SQLite and Postgres.
+//
+// The early migrations use IF NOT EXISTS so databases created before
+// versioning was introduced adopt them without error.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				id TEXT PRIMARY KEY,
+				email TEXT UNIQUE NOT NULL,
+				password_hash TEXT NOT NULL,
+				is_admin BOOLEAN DEFAULT FALSE,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS api_tokens (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				token_hash TEXT NOT NULL,
+				name TEXT,
+				last_used_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			// Organizations/Teams table
+			`CREATE TABLE IF NOT EXISTS organizations (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				slug TEXT UNIQUE NOT NULL,
+				owner_id TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(owner_id) REFERENCES users(id)
+			)`,
+			// Organization membership (many-to-many)
+			`CREATE TABLE IF NOT EXISTS organization_members (
+				id TEXT PRIMARY KEY,
+				organization_id TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				role TEXT NOT NULL DEFAULT 'member',
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(organization_id, user_id),
+				FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+				FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+			// Subdomains can belong to user OR organization
+			`CREATE TABLE IF NOT EXISTS subdomains (
+				id TEXT PRIMARY KEY,
+				user_id TEXT,
+				organization_id TEXT,
+				subdomain TEXT UNIQUE NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(user_id) REFERENCES users(id),
+				FOREIGN KEY(organization_id) REFERENCES organizations(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS request_logs (
+				id TEXT PRIMARY KEY,
+				subdomain TEXT NOT NULL,
+				method TEXT,
+				path TEXT,
+				status_code INTEGER,
+				duration_ms INTEGER,
+				client_ip TEXT,
+				user_agent TEXT,
+				request_headers TEXT,
+				response_headers TEXT,
+				request_body TEXT,
+				response_body TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_request_logs_subdomain ON request_logs(subdomain)`,
+			`CREATE INDEX IF NOT EXISTS idx_request_logs_created_at ON request_logs(created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_subdomains_user_id ON subdomains(user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_subdomains_organization_id ON subdomains(organization_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_org_members_org_id ON organization_members(organization_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON organization_members(user_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS request_logs`,
+			`DROP TABLE IF EXISTS subdomains`,
+			`DROP TABLE IF EXISTS organization_members`,
+			`DROP TABLE IF EXISTS organizations`,
+			`DROP TABLE IF EXISTS api_tokens`,
+			`DROP TABLE IF EXISTS users`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "limit_overrides",
+		Up: []string{
+			// Per-user or per-organization overrides of server-wide limits
+			`CREATE TABLE IF NOT EXISTS limit_overrides (
+				id TEXT PRIMARY KEY,
+				user_id TEXT UNIQUE,
+				organization_id TEXT UNIQUE,
+				max_connections INTEGER,
+				max_tunnels INTEGER,
+				max_monthly_bytes BIGINT,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS limit_overrides`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "transfer_usage",
+		Up: []string{
+			// Monthly transfer totals per user or organization
+			`CREATE TABLE IF NOT EXISTS transfer_usage (
+				id TEXT PRIMARY KEY,
+				subject_type TEXT NOT NULL,
+				subject_id TEXT NOT NULL,
+				period TEXT NOT NULL,
+				bytes_in BIGINT NOT NULL DEFAULT 0,
+				bytes_out BIGINT NOT NULL DEFAULT 0,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(subject_type, subject_id, period)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS transfer_usage`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "cluster_leases",
+		Up: []string{
+			// Server nodes participating in a cluster, kept alive by heartbeats
+			`CREATE TABLE IF NOT EXISTS cluster_nodes (
+				node_id TEXT PRIMARY KEY,
+				addr TEXT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			// Which node currently holds the session for a subdomain
+			`CREATE TABLE IF NOT EXISTS subdomain_leases (
+				subdomain TEXT PRIMARY KEY,
+				node_id TEXT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_subdomain_leases_node_id ON subdomain_leases(node_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS subdomain_leases`,
+			`DROP TABLE IF EXISTS cluster_nodes`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "request_log_retention",
+		Up: []string{
+			// How stored bodies are encoded ("" or "gzip") and whether they were cut short
+			`ALTER TABLE request_logs ADD COLUMN body_encoding TEXT`,
+			`ALTER TABLE request_logs ADD COLUMN body_truncated BOOLEAN DEFAULT FALSE`,
+			// Per-organization overrides of the server-wide retention
+			`CREATE TABLE log_retention_policies (
+				organization_id TEXT PRIMARY KEY,
+				max_age_seconds BIGINT,
+				max_rows INTEGER,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX idx_request_logs_subdomain_created_at ON request_logs(subdomain, created_at)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS idx_request_logs_subdomain_created_at`,
+			`DROP TABLE IF EXISTS log_retention_policies`,
+			`ALTER TABLE request_logs DROP COLUMN body_truncated`,
+			`ALTER TABLE request_logs DROP COLUMN body_encoding`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "organization_roles",
+		Up: []string{
+			// The free-text "member" role and any unknown roles become developers
+			`UPDATE organization_members SET role = 'developer'
+				WHERE role NOT IN ('owner', 'admin', 'developer', 'viewer')`,
+		},
+		Down: []string{
+			`UPDATE organization_members SET role = 'member' WHERE role = 'developer'`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "organization_invites",
+		Up: []string{
+			// Pending and answered invitations; only a hash of the token is stored
+			`CREATE TABLE organization_invites (
+				id TEXT PRIMARY KEY,
+				organization_id TEXT NOT NULL,
+				email TEXT NOT NULL,
+				role TEXT NOT NULL,
+				token_hash TEXT UNIQUE NOT NULL,
+				invited_by TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				expires_at TIMESTAMP NOT NULL,
+				responded_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+				FOREIGN KEY(invited_by) REFERENCES users(id)
+			)`,
+			`CREATE INDEX idx_org_invites_org_id ON organization_invites(organization_id)`,
+			`CREATE INDEX idx_org_invites_email ON organization_invites(email)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS organization_invites`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "audit_events",
+		Up: []string{
+			// Append-only record of security-relevant actions. No foreign
+			// keys, so events outlive the users and organizations they name.
+			`CREATE TABLE audit_events (
+				id TEXT PRIMARY KEY,
+				organization_id TEXT,
+				actor_id TEXT,
+				action TEXT NOT NULL,
+				target_type TEXT,
+				target_id TEXT,
+				remote_addr TEXT,
+				metadata TEXT,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX idx_audit_events_org_created_at ON audit_events(organization_id, created_at)`,
+			`CREATE INDEX idx_audit_events_actor_created_at ON audit_events(actor_id, created_at)`,
+			`CREATE INDEX idx_audit_events_action ON audit_events(action)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS audit_events`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "subdomain_last_used",
+		Up: []string{
+			// When a reserved subdomain last had a tunnel, for expiring unused reservations
+			`ALTER TABLE subdomains ADD COLUMN last_used_at TIMESTAMP`,
+		},
+		Down: []string{
+			`ALTER TABLE subdomains DROP COLUMN last_used_at`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "organization_tokens",
+		Up: []string{
+			// Tokens issued for an organization by a member who manages tokens
+			`ALTER TABLE api_tokens ADD COLUMN organization_id TEXT`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_hash ON api_tokens(token_hash)`,
+			`CREATE INDEX IF NOT EXISTS idx_api_tokens_org ON api_tokens(organization_id)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS idx_api_tokens_org`,
+			`DROP INDEX IF EXISTS idx_api_tokens_hash`,
+			`ALTER TABLE api_tokens DROP COLUMN organization_id`,
+		},
+	},
+}
+
+// LatestSchemaVersion returns the newest migration version this build knows.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// ensureMigrationsTable creates the table recording applied migrations.
+func (db *DB) ensureMigrationsTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	return err
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 for an
+// unversioned database.
+func (db *DB) SchemaVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// checkSchemaVersion fails with ErrSchemaTooNew if the database is ahead of
+// this build.
+func (db *DB) checkSchemaVersion() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); version > latest {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, version, latest)
+	}
+	return nil
+}
+
+// MigrateUp applies pending migrations up to and including target, or all
+// of them if target is 0. It returns the migrations that were applied.
+func (db *DB) MigrateUp(target int) ([]Migration, error) {
+	if err := db.checkSchemaVersion(); err != nil {
+		return nil, err
+	}
+	if target <= 0 {
+		target = LatestSchemaVersion()
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version > target {
+			break
+		}
+		ok, err := db.applyMigration(m, true)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the most recently applied steps migrations and returns
+// the migrations that were reverted, newest first.
+func (db *DB) MigrateDown(steps int) ([]Migration, error) {
+	if err := db.checkSchemaVersion(); err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		ok, err := db.applyMigration(m, false)
+		if err != nil {
+			return reverted, fmt.Errorf("failed to revert migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			reverted = append(reverted, m)
+		}
+	}
+	return reverted, nil
+}
+
+// applyMigration runs one migration in a transaction, up or down. It reports
+// false without changes if the migration is already in the requested state,
+// which may happen when another server migrated concurrently.
+func (db *DB) applyMigration(m Migration, up bool) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if db.dialect == DialectPostgres {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID); err != nil {
+			return false, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", m.Version).Scan(&count); err != nil {
+		return false, err
+	}
+	if (count > 0) == up {
+		return false, nil
+	}
+
+	statements := m.Down
+	if up {
+		statements = m.Up
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return false, err
+		}
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// MigrationStatus lists every known migration with its applied time, plus
+// any applied versions this build does not know about.
+func (db *DB) MigrationStatus() ([]MigrationState, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := db.Query("SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationState)
+	for rows.Next() {
+		var s MigrationState
+		var at time.Time
+		if err := rows.Scan(&s.Version, &s.Name, &at); err != nil {
+			return nil, err
+		}
+		s.AppliedAt = &at
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationState{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = a.AppliedAt
+			delete(applied, m.Version)
+		}
+		states = append(states, s)
+	}
+	unknown := make([]MigrationState, 0, len(applied))
+	for _, s := range applied {
+		unknown = append(unknown, s)
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(states, unknown...), nil
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/base64"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// Stored body encodings for request logs.
+const (
+	bodyEncodingNone = ""
+	bodyEncodingGzip = "gzip" // gzip, then base64 so it fits a TEXT column
+)
+
+// RequestLogOptions controls how captured bodies are stored.
+type RequestLogOptions struct {
+	// MaxBodySize truncates each stored body to this many bytes (0 = no limit).
+	MaxBodySize int
+
+	// CompressBodies stores bodies gzip-compressed.
+	CompressBodies bool
+}
+
+// SetRequestLogOptions sets how LogRequestFull stores bodies. It should be
+// called before any requests are logged.
+func (db *DB) SetRequestLogOptions(opts RequestLogOptions) {
+	db.requestLogs = opts
+}
+
+// truncateBody cuts body to at most max bytes without splitting a UTF-8
+// sequence, reporting whether anything was removed.
+func truncateBody(body string, max int) (string, bool) {
+	if max <= 0 || len(body) <= max {
+		return body, false
+	}
+	for max > 0 && !utf8.RuneStart(body[max]) {
+		max--
+	}
+	return body[:max], true
+}
+
+// encodeBody encodes a body for storage. Empty bodies are stored as-is.
+func encodeBody(encoding, body string) string {
+	if encoding != bodyEncodingGzip || body == "" {
+		return body
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(body))
+	zw.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// decodeBody reverses encodeBody.
+func decodeBody(encoding, stored string) (string, error) {
+	if encoding != bodyEncodingGzip || stored == "" {
+		return stored, nil
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// LogRetention overrides the server-wide request log retention for an
+// organization's subdomains. Nil fields use the server default; zero keeps
+// logs without that limit.
+type LogRetention struct {
+	MaxAgeSeconds *int64 `json:"max_age_seconds"`
+	MaxRows       *int   `json:"max_rows"`
+}
+
+// SetOrganizationLogRetention creates or replaces an organization's retention override.
+func (db *DB) SetOrganizationLogRetention(orgID string, r *LogRetention) error {
+	_, err := db.Exec(`
+		INSERT INTO log_retention_policies (organization_id, max_age_seconds, max_rows, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(organization_id) DO UPDATE SET
+			max_age_seconds = excluded.max_age_seconds,
+			max_rows = excluded.max_rows,
+			updated_at = excluded.updated_at`,
+		orgID, r.MaxAgeSeconds, r.MaxRows, time.Now())
+	return err
+}
+
+// GetOrganizationLogRetention returns an organization's retention override.
+// Returns an empty override if none is set.
+func (db *DB) GetOrganizationLogRetention(orgID string) (*LogRetention, error) {
+	var maxAge, maxRows sql.NullInt64
+	err := db.QueryRow("SELECT max_age_seconds, max_rows FROM log_retention_policies WHERE organization_id = ?", orgID).
+		Scan(&maxAge, &maxRows)
+	if err == sql.ErrNoRows {
+		return &LogRetention{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &LogRetention{}
+	if maxAge.Valid {
+		r.MaxAgeSeconds = &maxAge.Int64
+	}
+	if maxRows.Valid {
+		v := int(maxRows.Int64)
+		r.MaxRows = &v
+	}
+	return r, nil
+}
+
+// DeleteOrganizationLogRetention removes an organization's retention override.
+func (db *DB) DeleteOrganizationLogRetention(orgID string) error {
+	_, err := db.Exec("DELETE FROM log_retention_policies WHERE organization_id = ?", orgID)
+	return err
+}
+
+// PruneRequestLogs deletes request logs older than maxAge and beyond the
+// newest maxRows per subdomain, applying organization overrides to the
+// subdomains they own. A zero limit disables that check. It returns the
+// number of logs deleted.
+func (db *DB) PruneRequestLogs(maxAge time.Duration, maxRows int) (int64, error) {
+	now := time.Now().UTC()
+	var deleted int64
+
+	exec := func(query string, args ...any) error {
+		res, err := db.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+		return nil
+	}
+
+	// Age limit for subdomains without an organization override
+	if maxAge > 0 {
+		if err := exec(`
+			DELETE FROM request_logs
+			WHERE created_at < ? AND subdomain NOT IN (
+				SELECT s.subdomain FROM subdomains s
+				JOIN log_retention_policies p ON s.organization_id = p.organization_id
+				WHERE p.max_age_seconds IS NOT NULL)`, now.Add(-maxAge)); err != nil {
+			return deleted, err
+		}
+	}
+
+	// Age limits set per organization
+	rows, err := db.Query("SELECT organization_id, max_age_seconds FROM log_retention_policies WHERE max_age_seconds > 0")
+	if err != nil {
+		return deleted, err
+	}
+	orgAges := make(map[string]int64)
+	for rows.Next() {
+		var orgID string
+		var seconds int64
+		if err := rows.Scan(&orgID, &seconds); err != nil {
+			rows.Close()
+			return deleted, err
+		}
+		orgAges[orgID] = seconds
+	}
+	rows.Close()
+
+	for orgID, seconds := range orgAges {
+		if err := exec(`
+			DELETE FROM request_logs
+			WHERE created_at < ? AND subdomain IN (SELECT subdomain FROM subdomains WHERE organization_id = ?)`,
+			now.Add(-time.Duration(seconds)*time.Second), orgID); err != nil {
+			return deleted, err
+		}
+	}
+
+	// Row caps per subdomain
+	rows, err = db.Query(`
+		SELECT r.subdomain, COUNT(*), MAX(p.max_rows)
+		FROM request_logs r
+		LEFT JOIN subdomains s ON s.subdomain = r.subdomain
+		LEFT JOIN log_retention_policies p ON p.organization_id = s.organization_id
+		GROUP BY r.subdomain`)
+	if err != nil {
+		return deleted, err
+	}
+	over := make(map[string]int)
+	for rows.Next() {
+		var subdomain string
+		var count int
+		var override sql.NullInt64
+		if err := rows.Scan(&subdomain, &count, &override); err != nil {
+			rows.Close()
+			return deleted, err
+		}
+		limit := maxRows
+		if override.Valid {
+			limit = int(override.Int64)
+		}
+		if limit > 0 && count > limit {
+			over[subdomain] = limit
+		}
+	}
+	rows.Close()
+
+	for subdomain, limit := range over {
+		if err := exec(`
+			DELETE FROM request_logs
+			WHERE subdomain = ? AND id NOT IN (
+				SELECT id FROM request_logs WHERE subdomain = ?
+				ORDER BY created_at DESC, id DESC LIMIT ?)`,
+			subdomain, subdomain, limit); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// CompactRequestLogs reclaims space freed by pruning.
+func (db *DB) CompactRequestLogs() error {
+	if db.dialect == DialectPostgres {
+		_, err := db.Exec("VACUUM ANALYZE request_logs")
+		return err
+	}
+	_, err := db.Exec("VACUUM")
+	return err
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SubdomainReservation is a reserved subdomain and its owner. Exactly one of
+// UserID and OrganizationID is set.
+type SubdomainReservation struct {
+	Subdomain      string     `json:"subdomain"`
+	UserID         string     `json:"user_id,omitempty"`
+	OrganizationID string     `json:"organization_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ReleaseSubdomain deletes a reservation. It returns sql.ErrNoRows if the
+// subdomain is not reserved.
+func (db *DB) ReleaseSubdomain(subdomain string) error {
+	result, err := db.Exec("DELETE FROM subdomains WHERE subdomain = ?", subdomain)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TransferSubdomain moves a reservation to a user or, if orgID is set, an
+// organization. It returns sql.ErrNoRows if the subdomain is not reserved.
+func (db *DB) TransferSubdomain(subdomain, userID, orgID string) error {
+	result, err := db.Exec("UPDATE subdomains SET user_id = ?, organization_id = ? WHERE subdomain = ?",
+		nullString(userID), nullString(orgID), subdomain)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchSubdomain records that a reserved subdomain is in use. Unreserved
+// subdomains are ignored.
+func (db *DB) TouchSubdomain(subdomain string) error {
+	_, err := db.Exec("UPDATE subdomains SET last_used_at = ? WHERE subdomain = ?", time.Now().UTC(), subdomain)
+	return err
+}
+
+// ExpireSubdomainReservations releases reservations that have not been used
+// since cutoff, counting from creation for never-used ones. Subdomains in
+// keep and those leased to a live cluster node are skipped. It returns the
+// released reservations.
+func (db *DB) ExpireSubdomainReservations(cutoff time.Time, keep []string) ([]SubdomainReservation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT subdomain, user_id, organization_id, created_at, last_used_at FROM subdomains
+		WHERE COALESCE(last_used_at, created_at) < ?
+		AND subdomain NOT IN (SELECT subdomain FROM subdomain_leases WHERE expires_at >= ?)`,
+		cutoff.UTC(), time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[string]bool, len(keep))
+	for _, subdomain := range keep {
+		skip[subdomain] = true
+	}
+
+	var expired []SubdomainReservation
+	for rows.Next() {
+		var r SubdomainReservation
+		var userID, orgID sql.NullString
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&r.Subdomain, &userID, &orgID, &r.CreatedAt, &lastUsed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if skip[r.Subdomain] {
+			continue
+		}
+		r.UserID = userID.String
+		r.OrganizationID = orgID.String
+		if lastUsed.Valid {
+			r.LastUsedAt = &lastUsed.Time
+		}
+		expired = append(expired, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range expired {
+		if _, err := tx.Exec("DELETE FROM subdomains WHERE subdomain = ?", r.Subdomain); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// organizationTokenPrefix marks tokens issued for an organization, so they
+// are recognizable in client configuration and logs.
+const organizationTokenPrefix = "gto_"
+
+// ErrAPITokenNotFound is returned for unknown or revoked API tokens.
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// APIToken is a token a client can authenticate with. Organization tokens
+// act on behalf of the member who created them.
+type APIToken struct {
+	ID             string     `json:"id"`
+	UserID         string     `json:"created_by"`
+	OrganizationID string     `json:"organization_id"`
+	Name           string     `json:"name"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// hashAPIToken returns the stored form of an API token.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateOrganizationToken issues a token for an organization on behalf of
+// the creating member. It returns the token record and the token itself;
+// only a hash of the token is stored.
+func (db *DB) CreateOrganizationToken(orgID, createdBy, name string) (*APIToken, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := organizationTokenPrefix + hex.EncodeToString(buf)
+
+	apiToken := &APIToken{
+		ID:             uuid.New().String(),
+		UserID:         createdBy,
+		OrganizationID: orgID,
+		Name:           name,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO api_tokens (id, user_id, organization_id, token_hash, name, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		apiToken.ID, apiToken.UserID, apiToken.OrganizationID, hashAPIToken(token),
+		apiToken.Name, apiToken.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return apiToken, token, nil
+}
+
+const apiTokenColumns = `id, user_id, organization_id, name, last_used_at, created_at`
+
+func scanAPIToken(scan func(dest ...any) error) (*APIToken, error) {
+	var token APIToken
+	var orgID, name sql.NullString
+	var lastUsedAt sql.NullTime
+	if err := scan(&token.ID, &token.UserID, &orgID, &name, &lastUsedAt, &token.CreatedAt); err != nil {
+		return nil, err
+	}
+	token.OrganizationID = orgID.String
+	token.Name = name.String
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
+
+// GetOrganizationTokenByValue returns the organization token matching a
+// token value.
+func (db *DB) GetOrganizationTokenByValue(token string) (*APIToken, error) {
+	apiToken, err := scanAPIToken(db.QueryRow(
+		"SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ? AND organization_id IS NOT NULL",
+		hashAPIToken(token)).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPITokenNotFound
+	}
+	return apiToken, err
+}
+
+// GetOrganizationTokens lists an organization's tokens, newest first.
+func (db *DB) GetOrganizationTokens(orgID string) ([]APIToken, error) {
+	rows, err := db.Query(
+		"SELECT "+apiTokenColumns+" FROM api_tokens WHERE organization_id = ? ORDER BY created_at DESC",
+		orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken records that a token was just used to authenticate.
+func (db *DB) TouchAPIToken(tokenID string) error {
+	_, err := db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now().UTC(), tokenID)
+	return err
+}
+
+// RevokeOrganizationToken deletes one of an organization's tokens. It
+// returns ErrAPITokenNotFound if the organization has no such token.
+func (db *DB) RevokeOrganizationToken(orgID, tokenID string) error {
+	res, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND organization_id = ?", tokenID, orgID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
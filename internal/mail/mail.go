@@ -0,0 +1,158 @@
+// Package mail sends transactional email such as organization invites.
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Sender delivers email.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSender creates the sender selected by cfg.Driver: "smtp", "file" or
+// "log" (the default).
+func NewSender(cfg *common.MailConfig, logger *slog.Logger) (Sender, error) {
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTP.Host == "" || cfg.From == "" {
+			return nil, fmt.Errorf("mail.smtp.host and mail.from are required for the smtp driver")
+		}
+		return NewSMTPSender(cfg.From, &cfg.SMTP), nil
+	case "file":
+		if cfg.FilePath == "" {
+			return nil, fmt.Errorf("mail.file_path is required for the file driver")
+		}
+		return NewFileSender(cfg.FilePath), nil
+	case "", "log":
+		return NewLogSender(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// SMTPSender delivers email through an SMTP server, using STARTTLS when the
+// server offers it.
+type SMTPSender struct {
+	from   string
+	config *common.SMTPConfig
+}
+
+// NewSMTPSender creates an SMTP sender.
+func NewSMTPSender(from string, cfg *common.SMTPConfig) *SMTPSender {
+	return &SMTPSender{from: from, config: cfg}
+}
+
+// Send delivers msg. The context bounds the whole exchange.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	port := s.config.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, s.from, []string{msg.To}, formatMessage(s.from, msg))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send mail via %s: %w", addr, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// formatMessage renders msg as an RFC 5322 message.
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// LogSender writes email to the log instead of delivering it, for
+// development.
+type LogSender struct {
+	logger *slog.Logger
+}
+
+// NewLogSender creates a log sender.
+func NewLogSender(logger *slog.Logger) *LogSender {
+	return &LogSender{logger: logger.With(slog.String("component", "mail"))}
+}
+
+// Send logs msg.
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	s.logger.Info("email not delivered (log driver)",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body))
+	return nil
+}
+
+// FileSender appends each email as a JSON line to a file, for tests and
+// inspection.
+type FileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSender creates a file sender writing to path.
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path}
+}
+
+// Send appends msg to the file.
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
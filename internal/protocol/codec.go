@@ -158,3 +158,43 @@ func (c *Codec) SendShutdown(reason string, gracePeriodMs int) error {
 	}
 	return c.WriteMessage(envelope)
 }
+
+// SendAddTunnel sends a request to add a tunnel to an existing session.
+func (c *Codec) SendAddTunnel(requestID string, req *AddTunnelRequest) error {
+	envelope, err := NewEnvelope(MessageTypeAddTunnel, requestID, req)
+	if err != nil {
+		return fmt.Errorf("failed to create add tunnel envelope: %w", err)
+	}
+	return c.WriteMessage(envelope)
+}
+
+// SendRemoveTunnel sends a request to remove a tunnel from an existing session.
+func (c *Codec) SendRemoveTunnel(requestID string, req *RemoveTunnelRequest) error {
+	envelope, err := NewEnvelope(MessageTypeRemoveTunnel, requestID, req)
+	if err != nil {
+		return fmt.Errorf("failed to create remove tunnel envelope: %w", err)
+	}
+	return c.WriteMessage(envelope)
+}
+
+// SendTunnelUpdate sends the server's answer to an add or remove request.
+func (c *Codec) SendTunnelUpdate(requestID string, resp *TunnelUpdateResponse) error {
+	envelope, err := NewEnvelope(MessageTypeTunnelUpdate, requestID, resp)
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel update envelope: %w", err)
+	}
+	return c.WriteMessage(envelope)
+}
+
+// SendTunnelClosed tells the client the server unregistered one of its tunnels.
+func (c *Codec) SendTunnelClosed(subdomain, reason string) error {
+	msg := &TunnelClosedMessage{
+		Subdomain: subdomain,
+		Reason:    reason,
+	}
+	envelope, err := NewEnvelope(MessageTypeTunnelClosed, "", msg)
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel closed envelope: %w", err)
+	}
+	return c.WriteMessage(envelope)
+}
@@ -171,6 +171,9 @@ func TestHandshakeRequest_Validate(t *testing.T) {
 			wantErr: false,
 		},
 		{
+			// The server requires a token unless a client certificate
+			// authenticates the connection, which only it can tell (see
+			// TestControlPlane_TokenRequiredWithoutCertificate)
 			name: "missing token",
 			req: HandshakeRequest{
 				Version: 1,
@@ -178,7 +181,7 @@ func TestHandshakeRequest_Validate(t *testing.T) {
 					{Subdomain: "test", LocalPort: 3000},
 				},
 			},
-			wantErr: true,
+			wantErr: false,
 		},
 		{
 			name: "no tunnels",
@@ -43,6 +43,9 @@ var (
 	// ErrTunnelLimitReached indicates the maximum tunnel limit has been reached.
 	ErrTunnelLimitReached = errors.New("tunnel limit reached")
 
+	// ErrConnectionLimit indicates the maximum concurrent connection limit has been reached.
+	ErrConnectionLimit = errors.New("connection limit reached")
+
 	// ErrInvalidMessage indicates a malformed protocol message.
 	ErrInvalidMessage = errors.New("invalid protocol message")
 )
@@ -91,6 +94,8 @@ func ErrorToCode(err error) string {
 		return ErrorCodeRateLimited
 	case errors.Is(err, ErrTunnelLimitReached):
 		return ErrorCodeTunnelLimitReached
+	case errors.Is(err, ErrConnectionLimit):
+		return ErrorCodeConnectionLimit
 	default:
 		return ErrorCodeInternalError
 	}
@@ -112,7 +117,7 @@ func CodeToError(code string) error {
 	case ErrorCodeTunnelLimitReached:
 		return ErrTunnelLimitReached
 	case ErrorCodeConnectionLimit:
-		return ErrTunnelLimitReached
+		return ErrConnectionLimit
 	default:
 		return fmt.Errorf("unknown error: %s", code)
 	}
@@ -25,6 +25,10 @@ const (
 	// MessageTypeTunnelUpdate is sent by the server to confirm tunnel changes.
 	MessageTypeTunnelUpdate MessageType = "tunnel_update"
 
+	// MessageTypeTunnelClosed is sent by the server when it unregisters one
+	// of a session's tunnels without the client asking.
+	MessageTypeTunnelClosed MessageType = "tunnel_closed"
+
 	// MessageTypePing is a keepalive message.
 	MessageTypePing MessageType = "ping"
 
@@ -71,6 +75,10 @@ func (e *Envelope) DecodePayload(target interface{}) error {
 
 // TunnelConfig defines a single tunnel mapping from subdomain to local port.
 type TunnelConfig struct {
+	// Name identifies the tunnel in a client config file. It is not sent to
+	// the server.
+	Name string `json:"-" yaml:"name,omitempty"`
+
 	// Subdomain is the requested subdomain (e.g., "api" for api.example.com).
 	Subdomain string `json:"subdomain" yaml:"subdomain"`
 
@@ -84,6 +92,10 @@ type TunnelConfig struct {
 	// HTTP tunnels route based on Host header.
 	// TCP tunnels require dedicated ports on the server.
 	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+
+	// MaxRequestBodySize optionally lowers the server's maximum request body
+	// size (in bytes) for this tunnel. Zero uses the server default.
+	MaxRequestBodySize int64 `json:"max_request_body_size,omitempty" yaml:"max_request_body_size,omitempty"`
 }
 
 // Validate checks if the tunnel config is valid.
@@ -100,6 +112,9 @@ func (tc *TunnelConfig) Validate() error {
 	if tc.Protocol != "http" && tc.Protocol != "tcp" {
 		return fmt.Errorf("protocol must be 'http' or 'tcp'")
 	}
+	if tc.MaxRequestBodySize < 0 {
+		return fmt.Errorf("max_request_body_size cannot be negative")
+	}
 	if tc.LocalHost == "" {
 		tc.LocalHost = "127.0.0.1"
 	}
@@ -111,7 +126,8 @@ type HandshakeRequest struct {
 	// Version is the protocol version the client supports.
 	Version int `json:"version"`
 
-	// Token is the authentication token for this client.
+	// Token is the authentication token for this client. It may be empty
+	// when the client authenticates with a TLS certificate instead.
 	Token string `json:"token"`
 
 	// ClientID is a unique identifier for this client instance.
@@ -130,9 +146,6 @@ func (hr *HandshakeRequest) Validate() error {
 	if hr.Version < MinSupportedVersion {
 		return fmt.Errorf("unsupported protocol version %d, minimum is %d", hr.Version, MinSupportedVersion)
 	}
-	if hr.Token == "" {
-		return fmt.Errorf("token is required")
-	}
 	if len(hr.Tunnels) == 0 {
 		return fmt.Errorf("at least one tunnel configuration is required")
 	}
@@ -203,6 +216,13 @@ type PongMessage struct {
 	PingTimestamp time.Time `json:"ping_timestamp"`
 }
 
+// TunnelClosedMessage tells a client that the server unregistered one of
+// its tunnels.
+type TunnelClosedMessage struct {
+	Subdomain string `json:"subdomain"`
+	Reason    string `json:"reason,omitempty"`
+}
+
 // ShutdownMessage signals graceful shutdown intent.
 type ShutdownMessage struct {
 	Reason string `json:"reason,omitempty"`
@@ -19,6 +19,10 @@ const (
 
 	// StreamTypeWebSocket indicates a WebSocket connection.
 	StreamTypeWebSocket StreamType = "websocket"
+
+	// StreamTypeControl indicates a control message from the server,
+	// framed by Codec, follows the header instead of proxied traffic.
+	StreamTypeControl StreamType = "control"
 )
 
 // StreamHeader is sent at the beginning of each multiplexed stream
@@ -50,6 +54,13 @@ type StreamHeader struct {
 
 	// Path is the HTTP request path for request inspection.
 	Path string `json:"path,omitempty"`
+
+	// TraceParent is the W3C traceparent of the server span, so client
+	// spans join the same trace.
+	TraceParent string `json:"traceparent,omitempty"`
+
+	// TraceState is the W3C tracestate accompanying TraceParent.
+	TraceState string `json:"tracestate,omitempty"`
 }
 
 // MaxStreamHeaderSize is the maximum allowed size for a stream header.
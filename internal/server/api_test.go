@@ -0,0 +1,706 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/anyhost/gotunnel/internal/mail"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/google/uuid"
+	"github.com/hashicorp/yamux"
+)
+
+// apiTestEnv routes API requests through Server.handleAPI against a
+// temporary database.
+type apiTestEnv struct {
+	db     *database.DB
+	server *Server
+}
+
+func newAPITestEnv(t *testing.T) *apiTestEnv {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("database.New() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := newProxyTestConfig()
+	registry := NewRegistry(cfg.Domain, nil)
+	registry.SetOwnerChecker(db)
+	cp := NewControlPlane(cfg, registry, &NoOpAuthenticator{}, slog.Default())
+
+	return &apiTestEnv{
+		db:     db,
+		server: &Server{config: cfg, api: NewAPI(db, registry, cp)},
+	}
+}
+
+// do sends a request as userID and returns the recorded response.
+func (e *apiTestEnv) do(t *testing.T, method, path, userID string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("failed to encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+userID)
+	rec := httptest.NewRecorder()
+	e.server.handleAPI(rec, req)
+	return rec
+}
+
+// createUser creates a user with a unique email and returns its ID.
+func (e *apiTestEnv) createUser(t *testing.T) string {
+	t.Helper()
+	user, err := e.db.CreateUser(uuid.New().String()+"@example.com", "secret")
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	return user.ID
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{database.RoleOwner, PermManageMembers, true},
+		{database.RoleAdmin, PermManageTokens, true},
+		{database.RoleAdmin, PermViewAuditLog, true},
+		{database.RoleDeveloper, PermManageTokens, false},
+		{database.RoleDeveloper, PermConnectTunnels, true},
+		{database.RoleDeveloper, PermManageMembers, false},
+		{database.RoleViewer, PermViewRequestLogs, true},
+		{database.RoleViewer, PermViewRequestBodies, false},
+		{database.RoleViewer, PermConnectTunnels, false},
+		{"member", PermConnectTunnels, false},
+		{"", PermViewRequestLogs, false},
+	}
+	for _, tt := range tests {
+		if got := RoleAllows(tt.role, tt.perm); got != tt.want {
+			t.Errorf("RoleAllows(%q, %q) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestAPI_OrganizationRoles(t *testing.T) {
+	env := newAPITestEnv(t)
+
+	owner := env.createUser(t)
+	admin := env.createUser(t)
+	viewer := env.createUser(t)
+
+	org, err := env.db.CreateOrganization("Acme", "acme", owner)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	members := "/api/orgs/" + org.ID + "/members"
+
+	if rec := env.do(t, "POST", members, owner, map[string]string{"user_id": admin, "role": "admin"}); rec.Code != http.StatusOK {
+		t.Fatalf("owner adding admin: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := env.do(t, "POST", members, admin, map[string]string{"user_id": viewer, "role": "viewer"}); rec.Code != http.StatusOK {
+		t.Fatalf("admin adding viewer: got %d %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		caller string
+		body   any
+		want   int
+	}{
+		{"viewer cannot add members", "POST", members, viewer, map[string]string{"user_id": owner}, http.StatusForbidden},
+		{"unknown role rejected", "PATCH", members + "/" + viewer, admin, map[string]string{"role": "member"}, http.StatusBadRequest},
+		{"admin cannot grant owner", "PATCH", members + "/" + viewer, admin, map[string]string{"role": "owner"}, http.StatusForbidden},
+		{"admin cannot remove owner", "DELETE", members + "/" + owner, admin, nil, http.StatusForbidden},
+		{"last owner cannot step down", "PATCH", members + "/" + owner, owner, map[string]string{"role": "admin"}, http.StatusConflict},
+		{"viewer cannot remove others", "DELETE", members + "/" + admin, viewer, nil, http.StatusForbidden},
+		{"admin promotes viewer", "PATCH", members + "/" + viewer, admin, map[string]string{"role": "developer"}, http.StatusOK},
+		{"developer reserves subdomain", "POST", "/api/orgs/" + org.ID + "/subdomains", viewer, map[string]string{"subdomain": "teamapp"}, http.StatusCreated},
+		{"member leaves", "DELETE", members + "/" + viewer, viewer, nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := env.do(t, tt.method, tt.path, tt.caller, tt.body); rec.Code != tt.want {
+				t.Errorf("got %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+
+	if role, _ := env.db.GetUserRoleInOrganization(org.ID, viewer); role != "" {
+		t.Errorf("removed member still has role %q", role)
+	}
+}
+
+func TestAPI_RequestLogsRedactBodiesForViewers(t *testing.T) {
+	env := newAPITestEnv(t)
+
+	owner := env.createUser(t)
+	viewer := env.createUser(t)
+	org, err := env.db.CreateOrganization("Acme", "acme", owner)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	env.db.AddOrganizationMember(org.ID, viewer, database.RoleViewer)
+	env.db.ReserveSubdomainForOrg(org.ID, "teamapp")
+
+	if _, err := env.db.Exec(`INSERT INTO request_logs (id, subdomain, method, path, status_code, duration_ms, request_body, response_body)
+		VALUES (?, 'teamapp', 'POST', '/', 200, 1, 'secret-request', 'secret-response')`, uuid.New().String()); err != nil {
+		t.Fatalf("failed to insert log: %v", err)
+	}
+
+	bodies := func(userID string) (int, string) {
+		rec := env.do(t, "GET", "/api/requests/teamapp", userID, nil)
+		var resp struct {
+			Logs []database.RequestLog `json:"logs"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if len(resp.Logs) == 0 {
+			return rec.Code, ""
+		}
+		return rec.Code, resp.Logs[0].RequestBody
+	}
+
+	if code, body := bodies(owner); code != http.StatusOK || body != "secret-request" {
+		t.Errorf("owner got %d %q, want body", code, body)
+	}
+	if code, body := bodies(viewer); code != http.StatusOK || body != "" {
+		t.Errorf("viewer got %d %q, want redacted body", code, body)
+	}
+	if code, _ := bodies(env.createUser(t)); code != http.StatusForbidden {
+		t.Errorf("outsider got %d, want 403", code)
+	}
+}
+
+// recordingMailer keeps sent messages for inspection.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var inviteTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// lastToken returns the invite token linked from the most recent message.
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		t.Fatal("no invite email sent")
+	}
+	match := inviteTokenPattern.FindStringSubmatch(m.sent[len(m.sent)-1].Body)
+	if match == nil {
+		t.Fatalf("no token in email body %q", m.sent[len(m.sent)-1].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("invalid token %q: %v", match[1], err)
+	}
+	return token
+}
+
+func TestAPI_OrganizationInvites(t *testing.T) {
+	env := newAPITestEnv(t)
+	mailer := &recordingMailer{}
+	env.server.api.SetMailer(mailer)
+
+	owner := env.createUser(t)
+	viewer := env.createUser(t)
+	org, err := env.db.CreateOrganization("Acme", "acme", owner)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	env.db.AddOrganizationMember(org.ID, viewer, database.RoleViewer)
+	invites := "/api/orgs/" + org.ID + "/invites"
+
+	if rec := env.do(t, "POST", invites, viewer, map[string]string{"email": "x@example.com"}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer inviting: got %d, want 403", rec.Code)
+	}
+	if rec := env.do(t, "POST", invites, owner, map[string]string{"email": "x@example.com", "role": "member"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: got %d, want 400", rec.Code)
+	}
+
+	// An existing user accepts; only the invited email may use the token
+	dev, err := env.db.CreateUser("dev@example.com", "secret")
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if rec := env.do(t, "POST", invites, owner, map[string]string{"email": "dev@example.com"}); rec.Code != http.StatusCreated {
+		t.Fatalf("creating invite: got %d %s", rec.Code, rec.Body)
+	}
+	token := mailer.lastToken(t)
+	if rec := env.do(t, "POST", "/api/invites/accept", viewer, map[string]string{"token": token}); rec.Code != http.StatusForbidden {
+		t.Errorf("accepting someone else's invite: got %d, want 403", rec.Code)
+	}
+	if rec := env.do(t, "POST", "/api/invites/accept", dev.ID, map[string]string{"token": token}); rec.Code != http.StatusOK {
+		t.Fatalf("accepting invite: got %d %s", rec.Code, rec.Body)
+	}
+	if role, _ := env.db.GetUserRoleInOrganization(org.ID, dev.ID); role != database.RoleDeveloper {
+		t.Errorf("role = %q, want %q", role, database.RoleDeveloper)
+	}
+	if rec := env.do(t, "POST", "/api/invites/decline", "", map[string]string{"token": token}); rec.Code != http.StatusConflict {
+		t.Errorf("declining used invite: got %d, want 409", rec.Code)
+	}
+
+	// Registering with the invited email alone does not join the organization
+	if rec := env.do(t, "POST", invites, owner, map[string]string{"email": "new@example.com", "role": "viewer"}); rec.Code != http.StatusCreated {
+		t.Fatalf("creating invite: got %d %s", rec.Code, rec.Body)
+	}
+	rec := env.do(t, "POST", "/api/auth/register", "", map[string]string{"email": "new@example.com", "password": "secret"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("registering: got %d %s", rec.Code, rec.Body)
+	}
+	var user database.User
+	json.NewDecoder(rec.Body).Decode(&user)
+	if role, _ := env.db.GetUserRoleInOrganization(org.ID, user.ID); role != "" {
+		t.Errorf("user registered without the token has role %q, want none", role)
+	}
+
+	// With the token, only the invited email joins
+	if rec := env.do(t, "POST", invites, owner, map[string]string{"email": "newer@example.com", "role": "viewer"}); rec.Code != http.StatusCreated {
+		t.Fatalf("creating invite: got %d %s", rec.Code, rec.Body)
+	}
+	token = mailer.lastToken(t)
+	rec = env.do(t, "POST", "/api/auth/register", "", map[string]string{"email": "other@example.com", "password": "secret", "invite_token": token})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("registering another email with the token: got %d, want 403", rec.Code)
+	}
+	if _, err := env.db.AuthenticateUser("other@example.com", "secret"); err == nil {
+		t.Error("rejected registration still created the account")
+	}
+	rec = env.do(t, "POST", "/api/auth/register", "", map[string]string{"email": "newer@example.com", "password": "secret", "invite_token": token})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("registering with the token: got %d %s", rec.Code, rec.Body)
+	}
+	json.NewDecoder(rec.Body).Decode(&user)
+	if role, _ := env.db.GetUserRoleInOrganization(org.ID, user.ID); role != database.RoleViewer {
+		t.Errorf("registered user role = %q, want %q", role, database.RoleViewer)
+	}
+
+	rec = env.do(t, "GET", invites, owner, nil)
+	var listed []database.Invite
+	json.NewDecoder(rec.Body).Decode(&listed)
+	if rec.Code != http.StatusOK || len(listed) != 3 {
+		t.Errorf("listing invites: got %d with %d invites, want 200 with 3", rec.Code, len(listed))
+	}
+}
+
+func TestAPI_AuditLog(t *testing.T) {
+	env := newAPITestEnv(t)
+	auditor := NewAuditor(env.db, slog.Default())
+	env.server.api.SetAuditor(auditor)
+
+	owner := env.createUser(t)
+	admin := env.createUser(t)
+	viewer := env.createUser(t)
+
+	rec := env.do(t, "POST", "/api/orgs", owner, map[string]string{"name": "Acme", "slug": "acme"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating organization: got %d %s", rec.Code, rec.Body)
+	}
+	var org database.Organization
+	json.NewDecoder(rec.Body).Decode(&org)
+	members := "/api/orgs/" + org.ID + "/members"
+	env.do(t, "POST", members, owner, map[string]string{"user_id": admin, "role": "admin"})
+	env.do(t, "POST", members, admin, map[string]string{"user_id": viewer, "role": "viewer"})
+	auditor.Close()
+
+	audit := "/api/orgs/" + org.ID + "/audit"
+	list := func(query string) []database.AuditEvent {
+		t.Helper()
+		rec := env.do(t, "GET", audit+query, owner, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d %s", query, rec.Code, rec.Body)
+		}
+		var resp struct {
+			Events []database.AuditEvent `json:"events"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Events
+	}
+
+	if events := list(""); len(events) != 3 || events[2].Action != AuditOrgCreated {
+		t.Errorf("got %+v, want 3 events ending with %s", events, AuditOrgCreated)
+	}
+	if events := list("?action=org.member_added&actor=" + admin); len(events) != 1 || events[0].TargetID != viewer {
+		t.Errorf("filtered events = %+v, want the admin adding the viewer", events)
+	}
+	if events := list("?since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))); len(events) != 0 {
+		t.Errorf("got %d future events, want 0", len(events))
+	}
+	if rec := env.do(t, "GET", audit+"?since=yesterday", owner, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid since: got %d, want 400", rec.Code)
+	}
+	if rec := env.do(t, "GET", audit, viewer, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer reading audit log: got %d, want 403", rec.Code)
+	}
+
+	rec = env.do(t, "GET", audit+"?format=jsonl", owner, nil)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("export has %d lines, want 3", len(lines))
+	}
+	var event database.AuditEvent
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil || event.ActorID != admin {
+		t.Errorf("first export line = %s (%v), want the admin's event", lines[0], err)
+	}
+}
+
+func TestAPI_DeclineInviteIgnoresForgedActor(t *testing.T) {
+	env := newAPITestEnv(t)
+	auditor := NewAuditor(env.db, slog.Default())
+	env.server.api.SetAuditor(auditor)
+
+	owner := env.createUser(t)
+	org, err := env.db.CreateOrganization("Acme", "acme", owner)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	_, token, err := env.db.CreateInvite(org.ID, "dev@example.com", database.RoleViewer, owner, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvite() error = %v", err)
+	}
+
+	// The decline route is unauthenticated, so a claimed user ID is forged
+	body, _ := json.Marshal(map[string]string{"token": token})
+	req := httptest.NewRequest("POST", "/api/invites/decline", bytes.NewReader(body))
+	req.Header.Set("X-User-ID", owner)
+	rec := httptest.NewRecorder()
+	env.server.handleAPI(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("declining invite: got %d %s", rec.Code, rec.Body)
+	}
+	auditor.Close()
+
+	events, err := env.db.ListAuditEvents(database.AuditFilter{OrganizationID: org.ID, Action: AuditOrgInviteDeclined})
+	if err != nil {
+		t.Fatalf("ListAuditEvents() error = %v", err)
+	}
+	if len(events) != 1 || events[0].ActorID != "" || events[0].Metadata["email"] != "dev@example.com" {
+		t.Errorf("events = %+v, want one decline with no actor and the invite email", events)
+	}
+}
+
+func TestAPI_OrganizationTokens(t *testing.T) {
+	env := newAPITestEnv(t)
+
+	owner := env.createUser(t)
+	developer := env.createUser(t)
+	org, err := env.db.CreateOrganization("Acme", "acme", owner)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	env.db.AddOrganizationMember(org.ID, developer, database.RoleDeveloper)
+	tokens := "/api/orgs/" + org.ID + "/tokens"
+
+	if rec := env.do(t, "GET", tokens, developer, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("developer listing tokens: got %d, want 403", rec.Code)
+	}
+	if rec := env.do(t, "POST", tokens, developer, map[string]string{"name": "ci"}); rec.Code != http.StatusForbidden {
+		t.Errorf("developer creating token: got %d, want 403", rec.Code)
+	}
+	if rec := env.do(t, "POST", tokens, owner, map[string]string{"name": " "}); rec.Code != http.StatusBadRequest {
+		t.Errorf("blank name: got %d, want 400", rec.Code)
+	}
+
+	rec := env.do(t, "POST", tokens, owner, map[string]string{"name": "ci"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating token: got %d %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		Token database.APIToken `json:"token"`
+		Value string            `json:"value"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.Value == "" {
+		t.Fatalf("decoding created token: %v %s", err, rec.Body)
+	}
+
+	rec = env.do(t, "GET", tokens, owner, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing tokens: got %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), created.Value) {
+		t.Error("listing exposed the token value")
+	}
+	if !strings.Contains(rec.Body.String(), created.Token.ID) {
+		t.Errorf("listing is missing token %s: %s", created.Token.ID, rec.Body)
+	}
+
+	revoke := tokens + "/" + created.Token.ID
+	if rec := env.do(t, "DELETE", revoke, developer, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("developer revoking token: got %d, want 403", rec.Code)
+	}
+	if rec := env.do(t, "DELETE", revoke, owner, nil); rec.Code != http.StatusOK {
+		t.Fatalf("revoking token: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := env.do(t, "DELETE", revoke, owner, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("revoking twice: got %d, want 404", rec.Code)
+	}
+}
+
+func TestControlPlane_OrganizationTokenHandshake(t *testing.T) {
+	env := newAPITestEnv(t)
+
+	owner := env.createUser(t)
+	admin := env.createUser(t)
+	org, err := env.db.CreateOrganization("Acme", "acme", owner)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	env.db.AddOrganizationMember(org.ID, admin, database.RoleAdmin)
+	_, token, err := env.db.CreateOrganizationToken(org.ID, admin, "ci")
+	if err != nil {
+		t.Fatalf("CreateOrganizationToken() error = %v", err)
+	}
+
+	auth := NewDatabaseAuthenticator(env.db, nil)
+	auth.SetOrganizationTokenStore(env.db)
+	cp := NewControlPlane(newProxyTestConfig(), NewRegistry("example.com", nil), auth, slog.Default())
+
+	if resp := tryHandshake(t, cp, token, "ci-app"); !resp.Success {
+		t.Fatalf("handshake with organization token failed: %+v", resp)
+	}
+	if sessions, _ := cp.GetUserUsage(admin); sessions != 1 {
+		t.Errorf("sessions for token creator = %d, want 1", sessions)
+	}
+
+	// The token stops working once its creator can no longer manage tokens
+	if err := env.db.UpdateOrganizationMemberRole(org.ID, admin, database.RoleDeveloper); err != nil {
+		t.Fatalf("UpdateOrganizationMemberRole() error = %v", err)
+	}
+	resp := tryHandshake(t, cp, token, "ci-app-2")
+	if resp.Success || resp.ErrorCode != protocol.ErrorCodeUnauthorized {
+		t.Errorf("handshake after demotion: got %+v, want unauthorized", resp)
+	}
+}
+
+// connectSession registers a live session with tunnels on the environment's
+// control plane, as a completed handshake would.
+func (e *apiTestEnv) connectSession(t *testing.T, userID string, subdomains ...string) *Session {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	muxServer, err := yamux.Server(serverConn, DefaultYamuxConfig())
+	if err != nil {
+		t.Fatalf("yamux.Server() error = %v", err)
+	}
+	muxClient, err := yamux.Client(clientConn, DefaultYamuxConfig())
+	if err != nil {
+		t.Fatalf("yamux.Client() error = %v", err)
+	}
+	session, err := NewSessionWithMux(&SessionConfig{Conn: serverConn, Token: userID, UserID: userID, ClientID: "laptop"}, muxServer)
+	if err != nil {
+		t.Fatalf("NewSessionWithMux() error = %v", err)
+	}
+	session.SetState(SessionStateActive)
+	t.Cleanup(func() {
+		muxClient.Close()
+		session.Close()
+	})
+
+	var tunnels []protocol.TunnelConfig
+	for _, subdomain := range subdomains {
+		tunnels = append(tunnels, protocol.TunnelConfig{Subdomain: subdomain, LocalPort: 3000, Protocol: "http"})
+	}
+	for i, status := range e.server.api.registry.Register(session, tunnels) {
+		if status.Status != "active" {
+			t.Fatalf("Register(%s) = %+v", subdomains[i], status)
+		}
+		session.RegisterTunnel(&tunnels[i])
+	}
+
+	cp := e.server.api.control
+	cp.mu.Lock()
+	cp.sessions[session.ID] = session
+	cp.mu.Unlock()
+	return session
+}
+
+func TestAPI_AdminSessions(t *testing.T) {
+	env := newAPITestEnv(t)
+
+	admin, err := env.db.CreateUser("admin@example.com", "secret")
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if err := env.db.SetUserAdmin(admin.Email, true); err != nil {
+		t.Fatalf("SetUserAdmin() error = %v", err)
+	}
+	user := env.createUser(t)
+	session := env.connectSession(t, user, "alpha", "beta")
+
+	if rec := env.do(t, "GET", "/api/admin/sessions", user, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin listing sessions: got %d, want 403", rec.Code)
+	}
+
+	rec := env.do(t, "GET", "/api/admin/sessions", admin.ID, nil)
+	var sessions []adminSession
+	json.NewDecoder(rec.Body).Decode(&sessions)
+	if rec.Code != http.StatusOK || len(sessions) != 1 {
+		t.Fatalf("listing sessions: got %d with %d sessions", rec.Code, len(sessions))
+	}
+	if got := sessions[0]; got.ID != session.ID || got.ClientID != "laptop" || got.UserID != user || len(got.Tunnels) != 2 || got.State != "active" {
+		t.Errorf("session = %+v", got)
+	}
+
+	// Unregistering one tunnel keeps the session and its other tunnel
+	if rec := env.do(t, "DELETE", "/api/admin/tunnels/alpha", admin.ID, nil); rec.Code != http.StatusOK {
+		t.Fatalf("unregistering tunnel: got %d %s", rec.Code, rec.Body)
+	}
+	if _, ok := env.server.api.registry.Lookup("alpha"); ok {
+		t.Error("alpha is still registered")
+	}
+	if _, ok := env.server.api.registry.Lookup("beta"); !ok {
+		t.Error("beta was unregistered too")
+	}
+	if rec := env.do(t, "DELETE", "/api/admin/tunnels/alpha", admin.ID, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unregistering missing tunnel: got %d, want 404", rec.Code)
+	}
+
+	if rec := env.do(t, "DELETE", "/api/admin/sessions/"+session.ID, admin.ID, nil); rec.Code != http.StatusOK {
+		t.Fatalf("disconnecting session: got %d %s", rec.Code, rec.Body)
+	}
+	if !session.IsClosed() {
+		t.Error("session is still open")
+	}
+	if rec := env.do(t, "GET", "/api/admin/sessions/missing", admin.ID, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown session: got %d, want 404", rec.Code)
+	}
+}
+
+func TestAPI_AdminReservedSubdomains(t *testing.T) {
+	env := newAPITestEnv(t)
+
+	admin, _ := env.db.CreateUser("admin@example.com", "secret")
+	env.db.SetUserAdmin(admin.Email, true)
+
+	reserved := func(method, subdomain string, want int) []string {
+		t.Helper()
+		rec := env.do(t, method, "/api/admin/reserved/"+subdomain, admin.ID, nil)
+		if rec.Code != want {
+			t.Fatalf("%s %s: got %d %s, want %d", method, subdomain, rec.Code, rec.Body, want)
+		}
+		var resp struct {
+			Reserved []string `json:"reserved_subdomains"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Reserved
+	}
+
+	if got := reserved("PUT", "Launch", http.StatusOK); !slices.Contains(got, "launch") {
+		t.Errorf("reserved = %v, want launch", got)
+	}
+	if err := env.server.api.registry.ValidateSubdomain("launch"); !errors.Is(err, protocol.ErrSubdomainReserved) {
+		t.Errorf("ValidateSubdomain(launch) = %v, want ErrSubdomainReserved", err)
+	}
+	if got := reserved("DELETE", "launch", http.StatusOK); slices.Contains(got, "launch") {
+		t.Errorf("reserved = %v, want launch removed", got)
+	}
+	reserved("DELETE", "launch", http.StatusNotFound)
+	if err := env.server.api.registry.ValidateSubdomain("launch"); err != nil {
+		t.Errorf("ValidateSubdomain(launch) = %v, want nil", err)
+	}
+}
+
+func TestAPI_ReleaseAndTransferSubdomain(t *testing.T) {
+	env := newAPITestEnv(t)
+	env.server.api.registry.AddReservedSubdomain("www")
+
+	alice := env.createUser(t)
+	bob := env.createUser(t)
+	carol := env.createUser(t)
+	org, err := env.db.CreateOrganization("Acme", "acme", alice)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	if err := env.db.AddOrganizationMember(org.ID, bob, database.RoleViewer); err != nil {
+		t.Fatalf("AddOrganizationMember() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		caller string
+		body   any
+		want   int
+	}{
+		{"reserved word rejected", "POST", "/api/tunnels", alice, map[string]string{"subdomain": "www"}, http.StatusBadRequest},
+		{"invalid name rejected", "POST", "/api/tunnels", alice, map[string]string{"subdomain": "my_app"}, http.StatusBadRequest},
+		{"reserve", "POST", "/api/tunnels", alice, map[string]string{"subdomain": "MyApp"}, http.StatusOK},
+		{"others cannot release", "DELETE", "/api/tunnels/myapp", bob, nil, http.StatusForbidden},
+		{"others cannot transfer", "POST", "/api/tunnels/myapp/transfer", bob, map[string]string{"user_id": bob}, http.StatusForbidden},
+		{"target required", "POST", "/api/tunnels/myapp/transfer", alice, map[string]string{}, http.StatusBadRequest},
+		{"unknown user", "POST", "/api/tunnels/myapp/transfer", alice, map[string]string{"user_id": "nobody"}, http.StatusNotFound},
+		{"non-member cannot target org", "POST", "/api/tunnels/myapp/transfer", alice, map[string]string{"organization_id": "other"}, http.StatusForbidden},
+		{"unrelated user cannot receive", "POST", "/api/tunnels/myapp/transfer", alice, map[string]string{"user_id": carol}, http.StatusForbidden},
+		{"transfer to org", "POST", "/api/tunnels/myapp/transfer", alice, map[string]string{"organization_id": org.ID}, http.StatusOK},
+		{"non-member cannot receive from org", "POST", "/api/tunnels/myapp/transfer", alice, map[string]string{"user_id": carol}, http.StatusForbidden},
+		{"transfer to user", "POST", "/api/tunnels/myapp/transfer", alice, map[string]string{"user_id": bob}, http.StatusOK},
+		{"previous owner cannot release", "DELETE", "/api/tunnels/myapp", alice, nil, http.StatusForbidden},
+		{"owner releases", "DELETE", "/api/tunnels/myapp", bob, nil, http.StatusOK},
+		{"release unreserved", "DELETE", "/api/tunnels/myapp", bob, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := env.do(t, tt.method, tt.path, tt.caller, tt.body); rec.Code != tt.want {
+				t.Errorf("got %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+
+	if owner, _ := env.db.GetSubdomainOwnership("myapp"); owner != nil {
+		t.Errorf("owner after release = %+v, want none", owner)
+	}
+}
+
+func TestAPI_Me(t *testing.T) {
+	env := newAPITestEnv(t)
+	userID := env.createUser(t)
+
+	rec := env.do(t, "GET", "/api/auth/me", userID, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", rec.Code, rec.Body)
+	}
+	var user database.User
+	if err := json.NewDecoder(rec.Body).Decode(&user); err != nil || user.ID != userID {
+		t.Errorf("user = %+v, %v, want %s", user, err, userID)
+	}
+
+	if rec := env.do(t, "GET", "/api/auth/me", "unknown", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anyhost/gotunnel/internal/database"
+)
+
+// Audit actions.
+const (
+	AuditHandshakeSucceeded       = "handshake.succeeded"
+	AuditHandshakeFailed          = "handshake.failed"
+	AuditTunnelRegistered         = "tunnel.registered"
+	AuditTunnelRejected           = "tunnel.rejected"
+	AuditTunnelUnregistered       = "tunnel.unregistered"
+	AuditUserRegistered           = "user.registered"
+	AuditUserLogin                = "user.login"
+	AuditUserLoginFailed          = "user.login_failed"
+	AuditSubdomainReserved        = "subdomain.reserved"
+	AuditSubdomainReleased        = "subdomain.released"
+	AuditSubdomainTransferred     = "subdomain.transferred"
+	AuditSubdomainExpired         = "subdomain.expired"
+	AuditOrgCreated               = "org.created"
+	AuditOrgSubdomainReserved     = "org.subdomain_reserved"
+	AuditOrgMemberAdded           = "org.member_added"
+	AuditOrgMemberRoleChanged     = "org.member_role_changed"
+	AuditOrgMemberRemoved         = "org.member_removed"
+	AuditOrgInviteCreated         = "org.invite_created"
+	AuditOrgInviteAccepted        = "org.invite_accepted"
+	AuditOrgInviteDeclined        = "org.invite_declined"
+	AuditOrgTokenCreated          = "org.token_created"
+	AuditOrgTokenRevoked          = "org.token_revoked"
+	AuditOrgLimitsChanged         = "org.limits_changed"
+	AuditOrgRetentionChanged      = "org.retention_changed"
+	AuditUserLimitsChanged        = "user.limits_changed"
+	AuditAdminSessionDisconnected = "admin.session_disconnected"
+	AuditAdminTunnelUnregistered  = "admin.tunnel_unregistered"
+	AuditAdminReservedAdded       = "admin.reserved_added"
+	AuditAdminReservedRemoved     = "admin.reserved_removed"
+)
+
+// auditQueueSize is how many events may wait to be written.
+const auditQueueSize = 1024
+
+// AuditStore persists audit events.
+type AuditStore interface {
+	InsertAuditEvent(event *database.AuditEvent) error
+}
+
+// Auditor writes audit events in the background so callers on hot paths,
+// such as the registry under its lock, never wait on the database. Events
+// recorded while the queue is full or after Close are dropped and counted.
+// A nil *Auditor discards events.
+type Auditor struct {
+	store  AuditStore
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	closed  bool
+	events  chan *database.AuditEvent
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+}
+
+// NewAuditor creates an auditor writing to store and starts its writer.
+func NewAuditor(store AuditStore, logger *slog.Logger) *Auditor {
+	a := &Auditor{
+		store:  store,
+		logger: logger.With(slog.String("component", "audit")),
+		events: make(chan *database.AuditEvent, auditQueueSize),
+	}
+	a.wg.Add(1)
+	go a.run()
+	return a
+}
+
+// Record queues an event for writing. It never blocks: the event is dropped
+// if the queue is full or the auditor is closed.
+func (a *Auditor) Record(event *database.AuditEvent) {
+	if a == nil {
+		return
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.drop(event)
+		return
+	}
+	select {
+	case a.events <- event:
+	default:
+		a.drop(event)
+	}
+}
+
+// Dropped returns how many events have been dropped.
+func (a *Auditor) Dropped() int64 {
+	if a == nil {
+		return 0
+	}
+	return a.dropped.Load()
+}
+
+// drop counts a discarded event, logging the first drop and then one in
+// every auditQueueSize so a stalled database does not flood the log.
+func (a *Auditor) drop(event *database.AuditEvent) {
+	if n := a.dropped.Add(1); n == 1 || n%auditQueueSize == 0 {
+		a.logger.Warn("dropped audit event",
+			slog.String("action", event.Action),
+			slog.String("actor_id", event.ActorID),
+			slog.Int64("dropped", n))
+	}
+}
+
+// Close writes any queued events and stops the writer. Events recorded
+// afterwards are dropped.
+func (a *Auditor) Close() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	close(a.events)
+	a.mu.Unlock()
+
+	a.wg.Wait()
+}
+
+func (a *Auditor) run() {
+	defer a.wg.Done()
+	for event := range a.events {
+		a.write(event)
+	}
+}
+
+func (a *Auditor) write(event *database.AuditEvent) {
+	if err := a.store.InsertAuditEvent(event); err != nil {
+		a.logger.Error("failed to write audit event",
+			slog.String("action", event.Action),
+			slog.String("actor_id", event.ActorID),
+			slog.Any("error", err))
+	}
+}
+
+// tokenFingerprint identifies a token in audit events without storing it.
+func tokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// recordingAuditStore keeps audit events in memory.
+type recordingAuditStore struct {
+	mu     sync.Mutex
+	events []database.AuditEvent
+}
+
+func (s *recordingAuditStore) InsertAuditEvent(event *database.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func TestAuditor_NilDiscardsEvents(t *testing.T) {
+	var auditor *Auditor
+	auditor.Record(&database.AuditEvent{Action: AuditUserLogin})
+	auditor.Close()
+}
+
+// blockingAuditStore holds every write until release is closed.
+type blockingAuditStore struct {
+	recordingAuditStore
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (s *blockingAuditStore) InsertAuditEvent(event *database.AuditEvent) error {
+	s.once.Do(func() { close(s.writing) })
+	<-s.release
+	return s.recordingAuditStore.InsertAuditEvent(event)
+}
+
+func TestAuditor_DropsWhenFull(t *testing.T) {
+	store := &blockingAuditStore{writing: make(chan struct{}), release: make(chan struct{})}
+	auditor := NewAuditor(store, slog.Default())
+
+	// The first event holds the writer, so the queue fills and one more is
+	// dropped without blocking
+	auditor.Record(&database.AuditEvent{Action: AuditUserLogin})
+	<-store.writing
+	for i := 0; i < auditQueueSize+1; i++ {
+		auditor.Record(&database.AuditEvent{Action: AuditUserLogin})
+	}
+	if got := auditor.Dropped(); got != 1 {
+		t.Errorf("Dropped() with a full queue = %d, want 1", got)
+	}
+
+	close(store.release)
+	auditor.Close()
+	auditor.Record(&database.AuditEvent{Action: AuditUserLoginFailed})
+
+	if got := auditor.Dropped(); got != 2 {
+		t.Errorf("Dropped() after Close() = %d, want 2", got)
+	}
+	if len(store.events) != auditQueueSize+1 {
+		t.Errorf("got %d events, want %d", len(store.events), auditQueueSize+1)
+	}
+}
+
+func TestRegistry_AuditsTunnelEvents(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("database.New() error = %v", err)
+	}
+	defer db.Close()
+
+	owner, _ := db.CreateUser("owner@example.com", "secret")
+	outsider, _ := db.CreateUser("outsider@example.com", "secret")
+	org, err := db.CreateOrganization("Acme", "acme", owner.ID)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	if err := db.ReserveSubdomainForOrg(org.ID, "teamapp"); err != nil {
+		t.Fatalf("ReserveSubdomainForOrg() error = %v", err)
+	}
+
+	store := &recordingAuditStore{}
+	auditor := NewAuditor(store, slog.Default())
+	registry := NewRegistry("example.com", nil)
+	registry.SetOwnerChecker(db)
+	registry.SetAuditor(auditor)
+
+	tunnels := []protocol.TunnelConfig{{Subdomain: "teamapp", LocalPort: 3000}}
+	registry.Register(&Session{ID: "s1", UserID: outsider.ID, ClientID: "laptop"}, tunnels)
+	registry.Register(&Session{ID: "s2", UserID: owner.ID, RemoteAddr: "192.0.2.1:5000"}, tunnels)
+	registry.Unregister("s2")
+	auditor.Close()
+
+	want := []struct {
+		action, actor string
+	}{
+		{AuditTunnelRejected, outsider.ID},
+		{AuditTunnelRegistered, owner.ID},
+		{AuditTunnelUnregistered, owner.ID},
+	}
+	if len(store.events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(store.events), len(want), store.events)
+	}
+	for i, w := range want {
+		got := store.events[i]
+		if got.Action != w.action || got.ActorID != w.actor || got.OrganizationID != org.ID || got.TargetID != "teamapp" {
+			t.Errorf("event %d = %+v, want %s by %s in %s", i, got, w.action, w.actor, org.ID)
+		}
+	}
+	if store.events[0].Metadata["client_id"] != "laptop" || store.events[0].Metadata["error"] == "" {
+		t.Errorf("rejection metadata = %v, want client_id and error", store.events[0].Metadata)
+	}
+	if store.events[1].RemoteAddr != "192.0.2.1:5000" {
+		t.Errorf("RemoteAddr = %q, want the session's address", store.events[1].RemoteAddr)
+	}
+}
@@ -3,12 +3,15 @@ package server
 import (
 	"bufio"
 	"crypto/subtle"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"sync"
 
 	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
 	"github.com/anyhost/gotunnel/internal/protocol"
 )
 
@@ -143,10 +146,21 @@ func (a *TokenAuthenticator) TokenCount() int {
 	return len(a.tokens)
 }
 
+// OrganizationTokenStore resolves organization tokens and the roles of the
+// members who created them.
+type OrganizationTokenStore interface {
+	GetOrganizationTokenByValue(token string) (*database.APIToken, error)
+	GetUserRoleInOrganization(orgID, userID string) (string, error)
+	TouchAPIToken(tokenID string) error
+}
+
 // DatabaseAuthenticator validates tokens against the database (user IDs).
 type DatabaseAuthenticator struct {
 	db       interface{ UserExists(userID string) bool }
 	fallback Authenticator
+
+	// orgTokens resolves organization tokens (optional).
+	orgTokens OrganizationTokenStore
 }
 
 // NewDatabaseAuthenticator creates a new database authenticator with optional fallback.
@@ -157,8 +171,41 @@ func NewDatabaseAuthenticator(db interface{ UserExists(userID string) bool }, fa
 	}
 }
 
+// SetOrganizationTokenStore sets the store used to accept organization
+// tokens. A client presenting one authenticates as the member who created
+// it, as long as that member may still manage the organization's tokens.
+func (a *DatabaseAuthenticator) SetOrganizationTokenStore(store OrganizationTokenStore) {
+	a.orgTokens = store
+}
+
+// organizationToken looks up an organization token. It returns nil if the
+// token is not one, and reports whether its creator may still manage the
+// organization's tokens.
+func (a *DatabaseAuthenticator) organizationToken(token string) (*database.APIToken, bool, error) {
+	if a.orgTokens == nil {
+		return nil, false, nil
+	}
+	apiToken, err := a.orgTokens.GetOrganizationTokenByValue(token)
+	if errors.Is(err, database.ErrAPITokenNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up organization token: %w", err)
+	}
+
+	role, err := a.orgTokens.GetUserRoleInOrganization(apiToken.OrganizationID, apiToken.UserID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get token creator's role: %w", err)
+	}
+	return apiToken, RoleAllows(role, PermManageTokens), nil
+}
+
 // Validate checks if a token (user ID) exists in the database.
 func (a *DatabaseAuthenticator) Validate(token string) (bool, error) {
+	if apiToken, allowed, err := a.organizationToken(token); apiToken != nil || err != nil {
+		return allowed, err
+	}
+
 	// First check if it's a valid user ID in the database
 	if a.db != nil {
 		exists := a.db.UserExists(token)
@@ -175,8 +222,20 @@ func (a *DatabaseAuthenticator) Validate(token string) (bool, error) {
 	return false, nil
 }
 
-// GetUserID returns the token as the user ID (since token IS the user ID).
+// GetUserID returns the token as the user ID (since token IS the user ID),
+// or the creator of an organization token.
 func (a *DatabaseAuthenticator) GetUserID(token string) (string, error) {
+	if apiToken, allowed, err := a.organizationToken(token); apiToken != nil || err != nil {
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return "", protocol.ErrUnauthorized
+		}
+		a.orgTokens.TouchAPIToken(apiToken.ID)
+		return apiToken.UserID, nil
+	}
+
 	valid, err := a.Validate(token)
 	if err != nil {
 		return "", err
@@ -187,6 +246,100 @@ func (a *DatabaseAuthenticator) GetUserID(token string) (string, error) {
 	return token, nil
 }
 
+// CertificateAuthenticator maps client certificate identities to users. It
+// is consulted with the identities of verified certificates, never with
+// client-supplied tokens.
+type CertificateAuthenticator struct {
+	mu         sync.RWMutex
+	identities map[string]string // identity -> userID
+}
+
+// NewCertificateAuthenticator creates a new certificate authenticator.
+func NewCertificateAuthenticator() *CertificateAuthenticator {
+	return &CertificateAuthenticator{
+		identities: make(map[string]string),
+	}
+}
+
+// AddIdentity maps a certificate identity to a user.
+func (a *CertificateAuthenticator) AddIdentity(identity, userID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.identities[identity] = userID
+}
+
+// LoadFromFile loads identities from a file, one "identity userID" pair per
+// line. Identities such as SPIFFE IDs contain colons, so the pair is
+// separated by whitespace.
+func (a *CertificateAuthenticator) LoadFromFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open identities file: %w", err)
+	}
+	defer file.Close()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid identity on line %d: want \"identity userID\"", lineNum)
+		}
+		a.identities[fields[0]] = fields[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read identities file: %w", err)
+	}
+
+	return nil
+}
+
+// Validate reports whether the identity maps to a user.
+func (a *CertificateAuthenticator) Validate(identity string) (bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.identities[identity]
+	return ok, nil
+}
+
+// GetUserID returns the user an identity maps to.
+func (a *CertificateAuthenticator) GetUserID(identity string) (string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if userID, ok := a.identities[identity]; ok {
+		return userID, nil
+	}
+	return "", protocol.ErrUnauthorized
+}
+
+// CertificateIdentities returns the identities a certificate asserts, most
+// specific first: URI SANs (such as SPIFFE IDs), DNS and email SANs, then
+// the subject common name.
+func CertificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	return identities
+}
+
 // NewAuthenticatorFromConfig creates an authenticator based on the auth configuration.
 func NewAuthenticatorFromConfig(cfg *common.AuthConfig) (Authenticator, error) {
 	switch cfg.Mode {
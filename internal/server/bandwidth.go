@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleChunk is the largest chunk passed through a bandwidth limiter at once.
+const maxThrottleChunk = 32 * 1024
+
+// NewBandwidthLimiter creates a token-bucket limiter for the given rate.
+// Returns nil (unlimited) if bytesPerSec is zero or negative.
+func NewBandwidthLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := int(bytesPerSec)
+	if burst > maxThrottleChunk {
+		burst = maxThrottleChunk
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
+
+// MeteredStream wraps a tunnel stream, throttling traffic through an optional
+// limiter and counting bytes in both directions. Writes go towards the tunnel
+// client (request data); reads come back from it (response data).
+type MeteredStream struct {
+	stream  io.ReadWriteCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+	metrics *SessionMetrics
+
+	bytesWritten atomic.Int64
+	bytesRead    atomic.Int64
+}
+
+// NewMeteredStream wraps a stream. limiter and metrics may be nil.
+func NewMeteredStream(ctx context.Context, stream io.ReadWriteCloser, limiter *rate.Limiter, metrics *SessionMetrics) *MeteredStream {
+	return &MeteredStream{
+		stream:  stream,
+		ctx:     ctx,
+		limiter: limiter,
+		metrics: metrics,
+	}
+}
+
+// Read reads from the stream, waiting on the limiter for the bytes read.
+func (m *MeteredStream) Read(p []byte) (int, error) {
+	if m.limiter != nil && len(p) > m.limiter.Burst() {
+		p = p[:m.limiter.Burst()]
+	}
+
+	n, err := m.stream.Read(p)
+	if n > 0 {
+		m.bytesRead.Add(int64(n))
+		if m.metrics != nil {
+			m.metrics.BytesReceived.Add(int64(n))
+		}
+		if m.limiter != nil {
+			if waitErr := m.limiter.WaitN(m.ctx, n); waitErr != nil && err == nil {
+				err = waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// Write writes to the stream in limiter-sized chunks.
+func (m *MeteredStream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if m.limiter != nil {
+			if len(chunk) > m.limiter.Burst() {
+				chunk = chunk[:m.limiter.Burst()]
+			}
+			if err := m.limiter.WaitN(m.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := m.stream.Write(chunk)
+		written += n
+		m.bytesWritten.Add(int64(n))
+		if m.metrics != nil {
+			m.metrics.BytesSent.Add(int64(n))
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close closes the underlying stream.
+func (m *MeteredStream) Close() error {
+	return m.stream.Close()
+}
+
+// BytesWritten returns the number of bytes written towards the tunnel client.
+func (m *MeteredStream) BytesWritten() int64 {
+	return m.bytesWritten.Load()
+}
+
+// BytesRead returns the number of bytes read back from the tunnel client.
+func (m *MeteredStream) BytesRead() int64 {
+	return m.bytesRead.Load()
+}
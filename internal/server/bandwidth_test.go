@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+type bufferStream struct {
+	bytes.Buffer
+}
+
+func (b *bufferStream) Close() error { return nil }
+
+func TestMeteredStream_CountsBytes(t *testing.T) {
+	buf := &bufferStream{}
+	metrics := &SessionMetrics{}
+	stream := NewMeteredStream(context.Background(), buf, nil, metrics)
+
+	payload := bytes.Repeat([]byte("x"), 100*1024)
+	if _, err := stream.Write(payload); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("read data does not match written data")
+	}
+
+	if stream.BytesWritten() != int64(len(payload)) || stream.BytesRead() != int64(len(payload)) {
+		t.Errorf("counters = (%d, %d), want (%d, %d)",
+			stream.BytesWritten(), stream.BytesRead(), len(payload), len(payload))
+	}
+	if metrics.BytesSent.Load() != int64(len(payload)) || metrics.BytesReceived.Load() != int64(len(payload)) {
+		t.Errorf("session metrics = (%d, %d), want (%d, %d)",
+			metrics.BytesSent.Load(), metrics.BytesReceived.Load(), len(payload), len(payload))
+	}
+}
+
+func TestMeteredStream_Throttles(t *testing.T) {
+	// 10KB/s with a 10KB burst: writing 20KB must take roughly a second.
+	limiter := NewBandwidthLimiter(10 * 1024)
+	stream := NewMeteredStream(context.Background(), &bufferStream{}, limiter, nil)
+
+	start := time.Now()
+	if _, err := stream.Write(make([]byte, 20*1024)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("Write() took %v, expected throttling to ~1s", elapsed)
+	}
+}
+
+func TestMeteredStream_ThrottleCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	limiter := NewBandwidthLimiter(1024)
+	stream := NewMeteredStream(ctx, &bufferStream{}, limiter, nil)
+
+	if _, err := stream.Write(make([]byte, 4096)); err == nil {
+		t.Error("Write() with cancelled context: expected error")
+	}
+}
+
+func TestNewBandwidthLimiter_Unlimited(t *testing.T) {
+	if NewBandwidthLimiter(0) != nil {
+		t.Error("NewBandwidthLimiter(0) should return nil")
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/telemetry"
+)
+
+// Headers used to authenticate requests forwarded between nodes.
+const (
+	headerForwardNode      = "X-Gotunnel-Node"
+	headerForwardTimestamp = "X-Gotunnel-Timestamp"
+	headerForwardSignature = "X-Gotunnel-Signature"
+)
+
+// maxForwardClockSkew bounds how old a forwarded request's signature may be.
+const maxForwardClockSkew = 30 * time.Second
+
+// Cluster keeps this node's subdomain leases alive and forwards requests for
+// tunnels held by other nodes over an authenticated internal hop.
+type Cluster struct {
+	config  *common.ClusterConfig
+	backend RegistryBackend
+	logger  *slog.Logger
+
+	transport http.RoundTripper
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCluster creates a cluster member. cfg.NodeID must be set.
+func NewCluster(cfg *common.ClusterConfig, backend RegistryBackend, logger *slog.Logger) *Cluster {
+	return &Cluster{
+		config:    cfg,
+		backend:   backend,
+		logger:    logger.With(slog.String("component", "cluster"), slog.String("node_id", cfg.NodeID)),
+		transport: http.DefaultTransport,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// NodeID returns this node's identifier.
+func (c *Cluster) NodeID() string {
+	return c.config.NodeID
+}
+
+// Start announces the node and starts renewing its leases.
+func (c *Cluster) Start() error {
+	if err := c.backend.HeartbeatNode(c.config.NodeID, c.config.AdvertiseAddr, c.config.LeaseTTL); err != nil {
+		return err
+	}
+
+	c.logger.Info("joined cluster", slog.String("advertise_addr", c.config.AdvertiseAddr))
+
+	c.wg.Add(1)
+	go c.renewLoop()
+	return nil
+}
+
+// Stop stops renewing and releases every lease this node holds.
+func (c *Cluster) Stop() {
+	close(c.stopCh)
+	c.wg.Wait()
+
+	if err := c.backend.ReleaseNodeLeases(c.config.NodeID); err != nil {
+		c.logger.Warn("failed to release leases", slog.Any("error", err))
+	}
+}
+
+// renewLoop refreshes the node heartbeat and leases well before they expire.
+func (c *Cluster) renewLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if err := c.backend.HeartbeatNode(c.config.NodeID, c.config.AdvertiseAddr, c.config.LeaseTTL); err != nil {
+				c.logger.Error("failed to send heartbeat", slog.Any("error", err))
+			}
+			if err := c.backend.RenewSubdomainLeases(c.config.NodeID, c.config.LeaseTTL); err != nil {
+				c.logger.Error("failed to renew leases", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// RemoteOwner returns the address of another node holding the subdomain.
+func (c *Cluster) RemoteOwner(subdomain string) (string, bool) {
+	if c == nil || subdomain == "" {
+		return "", false
+	}
+
+	nodeID, addr, err := c.backend.GetSubdomainLeaseHolder(subdomain)
+	if err != nil {
+		c.logger.Warn("failed to look up subdomain lease",
+			slog.String("subdomain", subdomain),
+			slog.Any("error", err))
+		return "", false
+	}
+	if nodeID == "" || nodeID == c.config.NodeID {
+		return "", false
+	}
+	return addr, true
+}
+
+// Forward proxies a request to the node at addr, signing it so the owning
+// node accepts it and does not forward it again.
+func (c *Cluster) Forward(w http.ResponseWriter, r *http.Request, addr string) {
+	target := &url.URL{Scheme: "http", Host: addr}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+			telemetry.InjectHTTP(pr.In.Context(), pr.Out.Header)
+			c.sign(pr.Out)
+		},
+		Transport:     c.transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			c.logger.Error("failed to forward request to node",
+				slog.String("addr", addr),
+				slog.String("host", r.Host),
+				slog.Any("error", err))
+			http.Error(w, "Tunnel node unavailable", http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// Authenticate reports whether a request was forwarded by another node and
+// strips the forwarding headers so they never reach the tunnel client.
+func (c *Cluster) Authenticate(r *http.Request) bool {
+	if c == nil {
+		return false
+	}
+
+	node := r.Header.Get(headerForwardNode)
+	timestamp := r.Header.Get(headerForwardTimestamp)
+	signature := r.Header.Get(headerForwardSignature)
+	r.Header.Del(headerForwardNode)
+	r.Header.Del(headerForwardTimestamp)
+	r.Header.Del(headerForwardSignature)
+
+	if node == "" || signature == "" {
+		return false
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > maxForwardClockSkew || age < -maxForwardClockSkew {
+		c.logger.Warn("rejected stale forwarded request", slog.String("from_node", node))
+		return false
+	}
+
+	expected := c.signature(node, timestamp, r)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		c.logger.Warn("rejected forwarded request with invalid signature", slog.String("from_node", node))
+		return false
+	}
+	return true
+}
+
+// sign adds the forwarding headers to an outgoing request.
+func (c *Cluster) sign(r *http.Request) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set(headerForwardNode, c.config.NodeID)
+	r.Header.Set(headerForwardTimestamp, timestamp)
+	r.Header.Set(headerForwardSignature, c.signature(c.config.NodeID, timestamp, r))
+}
+
+// signature computes the HMAC binding a forwarded request to its origin
+// node, time, method, host and URI.
+func (c *Cluster) signature(node, timestamp string, r *http.Request) string {
+	mac := hmac.New(sha256.New, []byte(c.config.Secret))
+	for _, part := range []string{node, timestamp, r.Method, r.Host, r.URL.RequestURI()} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
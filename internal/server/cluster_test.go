@@ -0,0 +1,219 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// memoryBackend is an in-memory RegistryBackend shared by test nodes.
+type memoryBackend struct {
+	mu     sync.Mutex
+	nodes  map[string]string // node ID -> addr
+	leases map[string]string // subdomain -> node ID
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{nodes: make(map[string]string), leases: make(map[string]string)}
+}
+
+func (b *memoryBackend) HeartbeatNode(nodeID, addr string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nodes[nodeID] = addr
+	return nil
+}
+
+func (b *memoryBackend) ClaimSubdomainLease(subdomain, nodeID string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if holder, ok := b.leases[subdomain]; ok && holder != nodeID {
+		return false, nil
+	}
+	b.leases[subdomain] = nodeID
+	return true, nil
+}
+
+func (b *memoryBackend) RenewSubdomainLeases(nodeID string, ttl time.Duration) error {
+	return nil
+}
+
+func (b *memoryBackend) ReleaseSubdomainLease(subdomain, nodeID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.leases[subdomain] == nodeID {
+		delete(b.leases, subdomain)
+	}
+	return nil
+}
+
+func (b *memoryBackend) ReleaseNodeLeases(nodeID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subdomain, holder := range b.leases {
+		if holder == nodeID {
+			delete(b.leases, subdomain)
+		}
+	}
+	delete(b.nodes, nodeID)
+	return nil
+}
+
+func (b *memoryBackend) GetSubdomainLeaseHolder(subdomain string) (string, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	nodeID := b.leases[subdomain]
+	return nodeID, b.nodes[nodeID], nil
+}
+
+func newTestCluster(nodeID string, backend RegistryBackend) *Cluster {
+	return NewCluster(&common.ClusterConfig{
+		Enabled:       true,
+		NodeID:        nodeID,
+		AdvertiseAddr: nodeID + ":8080",
+		Secret:        "test-secret",
+		LeaseTTL:      30 * time.Second,
+	}, backend, slog.Default())
+}
+
+func TestRegistry_BackendPreventsDuplicateAcrossNodes(t *testing.T) {
+	backend := newMemoryBackend()
+
+	nodeA := NewRegistry("example.com", nil)
+	nodeA.SetBackend(backend, "node-a", time.Minute)
+	nodeB := NewRegistry("example.com", nil)
+	nodeB.SetBackend(backend, "node-b", time.Minute)
+
+	tunnels := []protocol.TunnelConfig{{Subdomain: "myapp", LocalPort: 3000}}
+
+	if status := nodeA.Register(&Session{ID: "a"}, tunnels); status[0].Status != "active" {
+		t.Fatalf("node A Register() = %+v, want active", status[0])
+	}
+	if status := nodeB.Register(&Session{ID: "b"}, tunnels); status[0].Status != "error" {
+		t.Fatalf("node B Register() = %+v, want error", status[0])
+	}
+
+	// Releasing on node A frees the subdomain for node B
+	nodeA.Unregister("a")
+	if status := nodeB.Register(&Session{ID: "b"}, tunnels); status[0].Status != "active" {
+		t.Errorf("node B Register() after release = %+v, want active", status[0])
+	}
+}
+
+// stalledBackend is a memoryBackend whose lease claims wait for release.
+type stalledBackend struct {
+	*memoryBackend
+	claiming chan struct{}
+	release  chan struct{}
+}
+
+func (b *stalledBackend) ClaimSubdomainLease(subdomain, nodeID string, ttl time.Duration) (bool, error) {
+	b.claiming <- struct{}{}
+	<-b.release
+	return b.memoryBackend.ClaimSubdomainLease(subdomain, nodeID, ttl)
+}
+
+func TestRegistry_LeaseClaimOutsideLock(t *testing.T) {
+	backend := &stalledBackend{memoryBackend: newMemoryBackend(), claiming: make(chan struct{}), release: make(chan struct{})}
+	registry := NewRegistry("example.com", nil)
+	registry.SetBackend(backend, "node-a", time.Minute)
+
+	done := make(chan []protocol.TunnelStatus)
+	go func() {
+		done <- registry.Register(&Session{ID: "a"}, []protocol.TunnelConfig{{Subdomain: "myapp", LocalPort: 3000}})
+	}()
+	<-backend.claiming
+
+	// Lookups go on while the backend is slow
+	lookedUp := make(chan struct{})
+	go func() {
+		registry.Lookup("other")
+		close(lookedUp)
+	}()
+	select {
+	case <-lookedUp:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Lookup() blocked on a lease claim")
+	}
+
+	close(backend.release)
+	if status := <-done; status[0].Status != "active" {
+		t.Fatalf("Register() = %+v, want active", status[0])
+	}
+
+	// A rejected duplicate on the same node keeps the holder's lease
+	go func() { <-backend.claiming }()
+	if status := registry.Register(&Session{ID: "b"}, []protocol.TunnelConfig{{Subdomain: "myapp", LocalPort: 3000}}); status[0].Status != "error" {
+		t.Fatalf("duplicate Register() = %+v, want error", status[0])
+	}
+	if holder, _, _ := backend.GetSubdomainLeaseHolder("myapp"); holder != "node-a" {
+		t.Errorf("lease holder after rejected duplicate = %q, want node-a", holder)
+	}
+
+	registry.Unregister("a")
+	if holder, _, _ := backend.GetSubdomainLeaseHolder("myapp"); holder != "" {
+		t.Errorf("lease holder after Unregister() = %q, want none", holder)
+	}
+}
+
+func TestHTTPProxy_ForwardsToOwningNode(t *testing.T) {
+	backend := newMemoryBackend()
+
+	// Node B holds the tunnel session
+	envB := newProxyTestEnv(t, newProxyTestConfig(), protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000}, echoHandler)
+	envB.proxy.SetCluster(newTestCluster("node-b", backend))
+	serverB := httptest.NewServer(envB.proxy)
+	defer serverB.Close()
+
+	backend.HeartbeatNode("node-b", strings.TrimPrefix(serverB.URL, "http://"), time.Minute)
+	backend.ClaimSubdomainLease("myapp", "node-b", time.Minute)
+
+	// Node A has no local tunnels
+	cfg := newProxyTestConfig()
+	registryA := NewRegistry(cfg.Domain, nil)
+	proxyA := NewHTTPProxy(cfg, registryA, NewControlPlane(cfg, registryA, &NoOpAuthenticator{}, slog.Default()), slog.Default())
+	proxyA.SetCluster(newTestCluster("node-a", backend))
+
+	req := httptest.NewRequest("POST", "http://myapp.example.com/echo", strings.NewReader("hello"))
+	rec := httptest.NewRecorder()
+	proxyA.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("got %d %q, want 200 %q", rec.Code, rec.Body.String(), "hello")
+	}
+}
+
+func TestCluster_Authenticate(t *testing.T) {
+	sender := newTestCluster("node-a", newMemoryBackend())
+	receiver := newTestCluster("node-b", newMemoryBackend())
+
+	req := httptest.NewRequest("GET", "http://myapp.example.com/path?q=1", nil)
+	sender.sign(req)
+	if !receiver.Authenticate(req) {
+		t.Fatal("Authenticate() = false for a signed request")
+	}
+	if req.Header.Get(headerForwardSignature) != "" {
+		t.Error("Authenticate() should strip forwarding headers")
+	}
+
+	tampered := httptest.NewRequest("GET", "http://myapp.example.com/path?q=1", nil)
+	sender.sign(tampered)
+	tampered.URL.RawQuery = "q=2"
+	if receiver.Authenticate(tampered) {
+		t.Error("Authenticate() = true for a tampered request")
+	}
+
+	other := NewCluster(&common.ClusterConfig{NodeID: "node-c", Secret: "other-secret"}, newMemoryBackend(), slog.Default())
+	foreign := httptest.NewRequest("GET", "http://myapp.example.com/", nil)
+	other.sign(foreign)
+	if receiver.Authenticate(foreign) {
+		t.Error("Authenticate() = true for a request signed with another secret")
+	}
+}
@@ -2,23 +2,31 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
 	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/anyhost/gotunnel/internal/telemetry"
 	"github.com/hashicorp/yamux"
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ControlPlane handles client connections and manages the tunnel registry.
 type ControlPlane struct {
 	config   *common.ServerConfig
-	listener net.Listener
 	registry *Registry
 	auth     Authenticator
 	logger   *slog.Logger
@@ -26,6 +34,29 @@ type ControlPlane struct {
 	mu       sync.RWMutex
 	sessions map[string]*Session
 
+	// usage tracks live sessions and tunnels per user ID.
+	usage map[string]*userUsage
+
+	// limits resolves per-user limit overrides (optional).
+	limits LimitOverrideProvider
+
+	// metrics records handshake and session metrics (optional).
+	metrics *Metrics
+
+	// auditor records handshake outcomes (optional).
+	auditor *Auditor
+
+	// certAuth maps verified client certificate identities to users
+	// (optional).
+	certAuth Authenticator
+
+	// transports are the sources of client connections, closed on Stop.
+	transports []io.Closer
+
+	// webSocket accepts connections from HandleWebSocket once first used.
+	webSocket     *WebSocketTransport
+	webSocketOnce sync.Once
+
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
@@ -41,27 +72,124 @@ func NewControlPlane(cfg *common.ServerConfig, registry *Registry, auth Authenti
 		auth:     auth,
 		logger:   logger.With(slog.String("component", "control_plane")),
 		sessions: make(map[string]*Session),
+		usage:    make(map[string]*userUsage),
 		ctx:      ctx,
 		cancel:   cancel,
 	}
 }
 
-// Start starts listening for client connections.
+// SetMetrics sets the Prometheus metrics to record handshakes and sessions in.
+func (cp *ControlPlane) SetMetrics(metrics *Metrics) {
+	cp.metrics = metrics
+}
+
+// SetAuditor sets the auditor that records handshake outcomes.
+func (cp *ControlPlane) SetAuditor(auditor *Auditor) {
+	cp.auditor = auditor
+}
+
+// SetCertificateAuthenticator sets the authenticator consulted with the
+// identities of verified client certificates. A client whose certificate
+// identity it accepts authenticates as that user without a token.
+func (cp *ControlPlane) SetCertificateAuthenticator(auth Authenticator) {
+	cp.certAuth = auth
+}
+
+// Start starts listening for client connections, over TLS if tls.control
+// is enabled.
 func (cp *ControlPlane) Start() error {
-	listener, err := net.Listen("tcp", cp.config.ControlAddr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", cp.config.ControlAddr, err)
+	var transport *ListenerTransport
+	if cp.config.TLS.Control {
+		tlsConfig, err := cp.config.TLS.ControlTLSConfig()
+		if err != nil {
+			return err
+		}
+		transport, err = ListenTLS(cp.config.ControlAddr, tlsConfig)
+		if err != nil {
+			return err
+		}
+	} else {
+		var err error
+		transport, err = ListenTCP(cp.config.ControlAddr)
+		if err != nil {
+			return err
+		}
 	}
-	cp.listener = listener
 
-	cp.logger.Info("control plane listening", slog.String("addr", cp.config.ControlAddr))
+	cp.logger.Info("control plane listening",
+		slog.String("addr", cp.config.ControlAddr),
+		slog.String("transport", transport.Name()))
+	cp.Serve(transport)
 
-	cp.wg.Add(1)
-	go cp.acceptLoop()
+	if cp.config.QUICAddr != "" {
+		tlsConfig, err := cp.config.TLS.ControlTLSConfig()
+		if err != nil {
+			return err
+		}
+		quicTransport, err := ListenQUIC(cp.config.QUICAddr, tlsConfig)
+		if err != nil {
+			return err
+		}
+
+		cp.logger.Info("control plane listening",
+			slog.String("addr", cp.config.QUICAddr),
+			slog.String("transport", quicTransport.Name()))
+		cp.ServeQUIC(quicTransport)
+	}
 
 	return nil
 }
 
+// Serve accepts client connections from transport in the background until
+// Stop, which also closes the transport.
+func (cp *ControlPlane) Serve(transport Transport) {
+	cp.serve(transport.Name(), transport, func() error {
+		conn, err := transport.Accept()
+		if err != nil {
+			return err
+		}
+		cp.wg.Add(1)
+		go cp.handleConnection(conn, transport.Name())
+		return nil
+	})
+}
+
+// ServeQUIC accepts QUIC connections from transport in the background until
+// Stop, which also closes the transport.
+func (cp *ControlPlane) ServeQUIC(transport *QUICTransport) {
+	cp.serve(transport.Name(), transport, func() error {
+		conn, err := transport.Accept()
+		if err != nil {
+			return err
+		}
+		cp.wg.Add(1)
+		go cp.handleQUICConnection(conn, transport.Name())
+		return nil
+	})
+}
+
+// serve runs accept in the background until it fails because transport is
+// closed.
+func (cp *ControlPlane) serve(name string, transport io.Closer, accept func() error) {
+	cp.mu.Lock()
+	cp.transports = append(cp.transports, transport)
+	cp.mu.Unlock()
+
+	cp.wg.Add(1)
+	go cp.acceptLoop(name, accept)
+}
+
+// HandleWebSocket accepts a client connection over WebSocket, so clients
+// can connect through the HTTP port instead of raw TCP.
+func (cp *ControlPlane) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// The unified server never calls Start, so serve the transport lazily
+	cp.webSocketOnce.Do(func() {
+		cp.webSocket = NewWebSocketTransport(cp.config, cp.logger)
+		cp.Serve(cp.webSocket)
+	})
+	cp.webSocket.ServeHTTP(w, r)
+}
+
 // Stop gracefully stops the control plane.
 func (cp *ControlPlane) Stop(gracePeriod time.Duration) error {
 	cp.logger.Info("stopping control plane", slog.Duration("grace_period", gracePeriod))
@@ -78,9 +206,11 @@ func (cp *ControlPlane) Stop(gracePeriod time.Duration) error {
 
 	// Stop accepting new connections
 	cp.cancel()
-	if cp.listener != nil {
-		cp.listener.Close()
+	cp.mu.RLock()
+	for _, transport := range cp.transports {
+		transport.Close()
 	}
+	cp.mu.RUnlock()
 
 	// Wait for goroutines with timeout
 	done := make(chan struct{})
@@ -99,168 +229,248 @@ func (cp *ControlPlane) Stop(gracePeriod time.Duration) error {
 	return nil
 }
 
-// acceptLoop accepts incoming client connections.
-func (cp *ControlPlane) acceptLoop() {
+// acceptLoop calls accept until the transport is closed.
+func (cp *ControlPlane) acceptLoop(transport string, accept func() error) {
 	defer cp.wg.Done()
 
 	for {
-		conn, err := cp.listener.Accept()
-		if err != nil {
+		if err := accept(); err != nil {
 			select {
 			case <-cp.ctx.Done():
 				return
 			default:
-				if !errors.Is(err, net.ErrClosed) {
-					cp.logger.Error("failed to accept connection", slog.Any("error", err))
-				}
-				continue
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			cp.logger.Error("failed to accept connection",
+				slog.String("transport", transport),
+				slog.Any("error", err))
 		}
-
-		cp.wg.Add(1)
-		go cp.handleConnection(conn)
 	}
 }
 
-// handleConnection handles a new client connection.
-func (cp *ControlPlane) handleConnection(conn net.Conn) {
+// clientConn is a client connection entering the handshake pipeline.
+type clientConn struct {
+	// mux multiplexes the connection's streams.
+	mux StreamMux
+
+	// conn is the byte stream under mux, or nil for QUIC.
+	conn net.Conn
+
+	// tlsState is the connection's TLS state, or nil without TLS.
+	tlsState *tls.ConnectionState
+
+	// transport names the transport the connection arrived on.
+	transport string
+}
+
+// handleConnection runs a client connection from a byte-stream transport
+// by multiplexing it with yamux.
+func (cp *ControlPlane) handleConnection(conn net.Conn, transport string) {
 	defer cp.wg.Done()
+	defer conn.Close()
 
-	remoteAddr := conn.RemoteAddr().String()
-	logger := cp.logger.With(slog.String("remote_addr", remoteAddr))
-	logger.Debug("new connection")
+	client := &clientConn{conn: conn, transport: transport}
 
-	// Set handshake deadline
-	if err := conn.SetDeadline(time.Now().Add(cp.config.Timeouts.HandshakeTimeout)); err != nil {
-		logger.Error("failed to set deadline", slog.Any("error", err))
-		conn.Close()
-		return
+	// Complete the TLS handshake up front so certificate errors are logged
+	// as such and the peer certificate is available to authentication
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(cp.ctx, cp.config.Timeouts.HandshakeTimeout)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			cp.logger.Warn("TLS handshake failed",
+				slog.String("remote_addr", conn.RemoteAddr().String()),
+				slog.String("transport", transport),
+				slog.Any("error", err))
+			return
+		}
+		state := tlsConn.ConnectionState()
+		client.tlsState = &state
 	}
 
 	// Create yamux session first (server mode) - client wraps connection in yamux
-	yamuxConfig := DefaultYamuxConfig()
-	muxSession, err := yamux.Server(conn, yamuxConfig)
+	muxSession, err := yamux.Server(conn, DefaultYamuxConfig())
 	if err != nil {
-		logger.Error("failed to create yamux session", slog.Any("error", err))
-		conn.Close()
+		cp.logger.Error("failed to create yamux session", slog.Any("error", err))
 		return
 	}
+	defer muxSession.Close()
+	client.mux = muxSession
+
+	cp.serveClient(client)
+}
+
+// handleQUICConnection runs a client connection from a QUIC transport,
+// whose streams need no further multiplexing.
+func (cp *ControlPlane) handleQUICConnection(conn *quic.Conn, transport string) {
+	defer cp.wg.Done()
+
+	mux := common.NewQUICSession(conn)
+	defer mux.Close()
+
+	state := conn.ConnectionState().TLS
+	cp.serveClient(&clientConn{mux: mux, tlsState: &state, transport: transport})
+}
+
+// serveClient runs a client connection from any transport: the handshake,
+// then the session until it ends.
+func (cp *ControlPlane) serveClient(client *clientConn) {
+	remoteAddr := client.mux.RemoteAddr().String()
+	logger := cp.logger.With(
+		slog.String("remote_addr", remoteAddr),
+		slog.String("transport", client.transport))
+	logger.Debug("new connection")
+
+	// Drop clients that do not complete the handshake in time
+	timer := time.AfterFunc(cp.config.Timeouts.HandshakeTimeout, func() {
+		client.mux.Close()
+	})
 
 	// Accept the handshake stream from client
-	stream, err := muxSession.AcceptStream()
+	stream, err := client.mux.Accept()
 	if err != nil {
+		timer.Stop()
 		logger.Error("failed to accept handshake stream", slog.Any("error", err))
-		muxSession.Close()
-		conn.Close()
 		return
 	}
 
-	// Create codec for handshake on the stream (not raw connection)
+	attempt := &handshakeAttempt{RemoteAddr: remoteAddr, Transport: client.transport}
+	session, err := cp.handshake(client, stream, attempt)
+	timer.Stop()
+	// The handshake stream is no longer needed
+	stream.Close()
+	if err != nil {
+		logger.Warn("handshake failed", slog.Any("error", err))
+		return
+	}
+	logger = logger.With(slog.String("user_id", session.UserID))
+
+	// Store session
+	cp.mu.Lock()
+	cp.sessions[session.ID] = session
+	cp.mu.Unlock()
+
+	session.SetState(SessionStateActive)
+	cp.auditHandshake(attempt, session.ID, "")
+
+	logger.Info("session established",
+		slog.String("session_id", session.ID),
+		slog.Int("tunnels", len(session.GetTunnels())))
+
+	// Handle session lifecycle
+	cp.handleSession(session)
+
+	// Cleanup on disconnect
+	cp.mu.Lock()
+	delete(cp.sessions, session.ID)
+	cp.mu.Unlock()
+
+	cp.registry.Unregister(session.ID)
+	// Tunnels may have been added or removed since the handshake
+	cp.releaseQuota(session.QuotaKey, len(session.GetTunnels()))
+	cp.metrics.SessionEnded(time.Since(session.CreatedAt))
+	logger.Info("session ended", slog.String("session_id", session.ID))
+}
+
+// handshake reads the client's handshake from stream, authenticates it,
+// reserves quota and registers the requested tunnels. On success the new
+// session holds one session and its tunnels in the user's quota. On failure
+// the client has been sent the reason and nothing is held.
+func (cp *ControlPlane) handshake(client *clientConn, stream net.Conn, attempt *handshakeAttempt) (*Session, error) {
 	codec := protocol.NewCodec(stream, stream)
 
 	// Read handshake request
 	envelope, err := codec.ReadMessage()
 	if err != nil {
-		logger.Error("failed to read handshake", slog.Any("error", err))
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
 	}
 
 	if envelope.Type != protocol.MessageTypeHandshake {
-		logger.Warn("unexpected message type", slog.String("type", string(envelope.Type)))
-		cp.sendHandshakeError(codec, "expected handshake message", protocol.ErrorCodeProtocolError)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+		cp.sendHandshakeError(codec, attempt, "expected handshake message", protocol.ErrorCodeProtocolError)
+		return nil, fmt.Errorf("unexpected message type %s", envelope.Type)
 	}
 
 	var handshake protocol.HandshakeRequest
 	if err := envelope.DecodePayload(&handshake); err != nil {
-		logger.Error("failed to decode handshake", slog.Any("error", err))
-		cp.sendHandshakeError(codec, "invalid handshake payload", protocol.ErrorCodeProtocolError)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+		cp.sendHandshakeError(codec, attempt, "invalid handshake payload", protocol.ErrorCodeProtocolError)
+		return nil, err
 	}
 
+	attempt.ClientID = handshake.ClientID
+	attempt.Token = handshake.Token
+
 	// Validate handshake
 	if err := handshake.Validate(); err != nil {
-		logger.Warn("invalid handshake", slog.Any("error", err))
-		cp.sendHandshakeError(codec, err.Error(), protocol.ErrorCodeProtocolError)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+		cp.sendHandshakeError(codec, attempt, err.Error(), protocol.ErrorCodeProtocolError)
+		return nil, fmt.Errorf("invalid handshake: %w", err)
 	}
 
 	// Check protocol version
 	if !protocol.IsVersionSupported(handshake.Version) {
-		logger.Warn("unsupported protocol version", slog.Int("version", handshake.Version))
-		cp.sendHandshakeError(codec, fmt.Sprintf("unsupported protocol version %d", handshake.Version), protocol.ErrorCodeProtocolError)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+		cp.sendHandshakeError(codec, attempt, fmt.Sprintf("unsupported protocol version %d", handshake.Version), protocol.ErrorCodeProtocolError)
+		return nil, fmt.Errorf("unsupported protocol version %d", handshake.Version)
 	}
 
-	// Authenticate
-	valid, err := cp.auth.Validate(handshake.Token)
-	if err != nil {
-		logger.Error("authentication error", slog.Any("error", err))
-		cp.sendHandshakeError(codec, "authentication failed", protocol.ErrorCodeUnauthorized)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
-	}
-	if !valid {
-		logger.Warn("authentication failed")
-		cp.sendHandshakeError(codec, "invalid token", protocol.ErrorCodeUnauthorized)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+	// Authenticate by client certificate, falling back to the token
+	userID := cp.certificateUser(client.tlsState)
+	certUser := userID != ""
+	if userID == "" {
+		if handshake.Token == "" {
+			cp.sendHandshakeError(codec, attempt, "token is required", protocol.ErrorCodeUnauthorized)
+			return nil, errors.New("no token or recognized client certificate")
+		}
+
+		valid, err := cp.auth.Validate(handshake.Token)
+		if err != nil {
+			cp.sendHandshakeError(codec, attempt, "authentication failed", protocol.ErrorCodeUnauthorized)
+			return nil, fmt.Errorf("authentication error: %w", err)
+		}
+		if !valid {
+			cp.sendHandshakeError(codec, attempt, "invalid token", protocol.ErrorCodeUnauthorized)
+			return nil, errors.New("invalid token")
+		}
 	}
 
 	// Check tunnel limits
 	if len(handshake.Tunnels) > cp.config.Limits.MaxTunnelsPerConnection {
-		logger.Warn("too many tunnels requested",
-			slog.Int("requested", len(handshake.Tunnels)),
-			slog.Int("max", cp.config.Limits.MaxTunnelsPerConnection))
-		cp.sendHandshakeError(codec, fmt.Sprintf("maximum %d tunnels allowed", cp.config.Limits.MaxTunnelsPerConnection), protocol.ErrorCodeTunnelLimitReached)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+		message := fmt.Sprintf("maximum %d tunnels allowed", cp.config.Limits.MaxTunnelsPerConnection)
+		cp.sendHandshakeError(codec, attempt, message, protocol.ErrorCodeTunnelLimitReached)
+		return nil, fmt.Errorf("too many tunnels requested: %s", message)
 	}
 
-	// Clear deadline for normal operation
-	if err := conn.SetDeadline(time.Time{}); err != nil {
-		logger.Error("failed to clear deadline", slog.Any("error", err))
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+	// Resolve the user behind the token and enforce per-user quotas
+	if userID == "" {
+		userID, err = cp.auth.GetUserID(handshake.Token)
+		if err != nil {
+			cp.sendHandshakeError(codec, attempt, "authentication failed", protocol.ErrorCodeUnauthorized)
+			return nil, fmt.Errorf("failed to resolve user: %w", err)
+		}
+	}
+	attempt.UserID = userID
+
+	quotaKey := cp.quotaKey(userID, certUser, client.mux.RemoteAddr())
+	limits, err := cp.acquireQuota(quotaKey, len(handshake.Tunnels))
+	if err != nil {
+		cp.sendHandshakeError(codec, attempt, err.Error(), protocol.ErrorToCode(err))
+		return nil, fmt.Errorf("user quota exceeded: %w", err)
 	}
 
 	// Create session with the existing yamux session
 	session, err := NewSessionWithMux(&SessionConfig{
-		Conn:     conn,
+		Conn:     client.conn,
 		Token:    handshake.Token,
+		UserID:   userID,
+		QuotaKey: quotaKey,
 		ClientID: handshake.ClientID,
+		Limits:   limits,
 		Logger:   cp.logger,
-	}, muxSession)
+	}, client.mux)
 	if err != nil {
-		logger.Error("failed to create session", slog.Any("error", err))
-		cp.sendHandshakeError(codec, "internal error", protocol.ErrorCodeInternalError)
-		stream.Close()
-		muxSession.Close()
-		conn.Close()
-		return
+		cp.releaseQuota(quotaKey, len(handshake.Tunnels))
+		cp.sendHandshakeError(codec, attempt, "internal error", protocol.ErrorCodeInternalError)
+		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
 	// Register tunnels
@@ -274,25 +484,28 @@ func (cp *ControlPlane) handleConnection(conn net.Conn) {
 	}
 
 	// Check if at least one tunnel was registered
-	hasActiveTunnel := false
+	activeTunnels := 0
 	for _, status := range tunnelStatuses {
 		if status.Status == "active" {
-			hasActiveTunnel = true
-			break
+			activeTunnels++
 		}
 	}
 
-	if !hasActiveTunnel {
-		logger.Warn("no tunnels could be registered")
+	// Only count tunnels that were actually registered against the quota
+	cp.adjustQuota(quotaKey, activeTunnels-len(handshake.Tunnels))
+
+	if activeTunnels == 0 {
+		cp.metrics.HandshakeFailed(handshakeFailureNoTunnels)
+		cp.auditHandshake(attempt, "", "no tunnels could be registered")
 		cp.sendHandshakeResponse(codec, &protocol.HandshakeResponse{
 			Success:       false,
 			Tunnels:       tunnelStatuses,
 			ServerVersion: protocol.ProtocolVersion,
 			Error:         "no tunnels could be registered",
 		})
-		stream.Close()
+		cp.releaseQuota(quotaKey, 0)
 		session.Close()
-		return
+		return nil, errors.New("no tunnels could be registered")
 	}
 
 	// Send success response
@@ -304,42 +517,46 @@ func (cp *ControlPlane) handleConnection(conn net.Conn) {
 	}
 
 	if err := cp.sendHandshakeResponse(codec, response); err != nil {
-		logger.Error("failed to send handshake response", slog.Any("error", err))
 		cp.registry.Unregister(session.ID)
-		stream.Close()
+		cp.releaseQuota(quotaKey, activeTunnels)
 		session.Close()
-		return
+		return nil, fmt.Errorf("failed to send handshake response: %w", err)
 	}
 
-	// Close the handshake stream - it's no longer needed
-	stream.Close()
-
-	// Store session
-	cp.mu.Lock()
-	cp.sessions[session.ID] = session
-	cp.mu.Unlock()
-
-	session.SetState(SessionStateActive)
-
-	logger.Info("session established",
-		slog.String("session_id", session.ID),
-		slog.Int("tunnels", len(session.GetTunnels())))
-
-	// Handle session lifecycle
-	cp.handleSession(session)
+	return session, nil
+}
 
-	// Cleanup on disconnect
-	cp.mu.Lock()
-	delete(cp.sessions, session.ID)
-	cp.mu.Unlock()
+// certificateUser returns the user a verified client certificate
+// authenticates, or "" if there is none or its identity is not recognized.
+func (cp *ControlPlane) certificateUser(state *tls.ConnectionState) string {
+	if state == nil || cp.certAuth == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
 
-	cp.registry.Unregister(session.ID)
-	logger.Info("session ended", slog.String("session_id", session.ID))
+	for _, identity := range CertificateIdentities(state.VerifiedChains[0][0]) {
+		if valid, err := cp.certAuth.Validate(identity); err != nil || !valid {
+			continue
+		}
+		if userID, err := cp.certAuth.GetUserID(identity); err == nil {
+			return userID
+		}
+	}
+	return ""
 }
 
 // handleSession monitors a session for keepalive and handles control messages.
 func (cp *ControlPlane) handleSession(session *Session) {
-	defer session.Close()
+	updatesDone := make(chan struct{})
+	go func() {
+		defer close(updatesDone)
+		cp.serveTunnelUpdates(session)
+	}()
+	defer func() {
+		session.Close()
+		// Let an in-flight tunnel update finish so the session's tunnels
+		// match its quota when the caller releases it
+		<-updatesDone
+	}()
 
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -350,6 +567,10 @@ func (cp *ControlPlane) handleSession(session *Session) {
 			return
 		case <-session.Context().Done():
 			return
+		case <-updatesDone:
+			// The yamux session is gone, so no more streams will arrive
+			session.Logger().Info("session connection closed")
+			return
 		case <-ticker.C:
 			// Check if session is still alive
 			if session.IsClosed() {
@@ -366,8 +587,46 @@ func (cp *ControlPlane) handleSession(session *Session) {
 	}
 }
 
+// handshakeAttempt describes a client handshake for auditing. Fields are
+// filled in as the handshake progresses.
+type handshakeAttempt struct {
+	RemoteAddr string
+	Transport  string
+	ClientID   string
+	Token      string
+	UserID     string
+}
+
+// auditHandshake records a handshake outcome; an empty failure means success.
+func (cp *ControlPlane) auditHandshake(attempt *handshakeAttempt, sessionID, failure string) {
+	event := &database.AuditEvent{
+		Action:     AuditHandshakeSucceeded,
+		ActorID:    attempt.UserID,
+		TargetType: "session",
+		TargetID:   sessionID,
+		RemoteAddr: attempt.RemoteAddr,
+		Metadata: map[string]string{
+			"transport": attempt.Transport,
+		},
+	}
+	if attempt.ClientID != "" {
+		event.Metadata["client_id"] = attempt.ClientID
+	}
+	if attempt.Token != "" {
+		event.Metadata["token"] = tokenFingerprint(attempt.Token)
+	}
+	if failure != "" {
+		event.Action = AuditHandshakeFailed
+		event.Metadata["error"] = failure
+	}
+	cp.auditor.Record(event)
+}
+
 // sendHandshakeError sends an error response during handshake.
-func (cp *ControlPlane) sendHandshakeError(codec *protocol.Codec, message, code string) {
+func (cp *ControlPlane) sendHandshakeError(codec *protocol.Codec, attempt *handshakeAttempt, message, code string) {
+	cp.metrics.HandshakeFailed(code)
+	cp.auditHandshake(attempt, "", message)
+
 	response := &protocol.HandshakeResponse{
 		Success:       false,
 		ServerVersion: protocol.ProtocolVersion,
@@ -391,6 +650,75 @@ func (cp *ControlPlane) GetSession(id string) (*Session, bool) {
 	return s, ok
 }
 
+// ListSessions returns the live sessions, oldest first.
+func (cp *ControlPlane) ListSessions() []*Session {
+	cp.mu.RLock()
+	sessions := make([]*Session, 0, len(cp.sessions))
+	for _, s := range cp.sessions {
+		sessions = append(sessions, s)
+	}
+	cp.mu.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// DisconnectSession closes a live session. Its tunnels are unregistered as
+// the session's handler exits. It reports whether the session existed.
+func (cp *ControlPlane) DisconnectSession(id string) bool {
+	session, ok := cp.GetSession(id)
+	if !ok {
+		return false
+	}
+	session.Logger().Info("disconnecting session on request")
+	session.Close()
+	return true
+}
+
+// UnregisterTunnel removes one tunnel from whichever session serves it,
+// leaving the session's other tunnels running and releasing the tunnel's
+// quota slot. The owning client is told the tunnel was closed.
+func (cp *ControlPlane) UnregisterTunnel(subdomain string) (*TunnelEntry, error) {
+	entry, ok := cp.registry.Lookup(subdomain)
+	if !ok {
+		return nil, protocol.ErrTunnelNotFound
+	}
+	if err := cp.registry.UnregisterTunnel(entry.Session.ID, entry.Subdomain); err != nil {
+		return nil, err
+	}
+	entry.Session.UnregisterTunnel(entry.Subdomain)
+	cp.adjustQuota(entry.Session.QuotaKey, -1)
+	go cp.notifyTunnelClosed(entry.Session, entry.Subdomain, "unregistered by an administrator")
+	return entry, nil
+}
+
+// notifyTunnelClosed tells a session's client that one of its tunnels was
+// unregistered, so it stops serving it. Best-effort: a client that misses
+// it sees requests for the subdomain stop.
+func (cp *ControlPlane) notifyTunnelClosed(session *Session, subdomain, reason string) {
+	stream, err := session.OpenStreamWithHeader(&protocol.StreamHeader{
+		Type:      protocol.StreamTypeControl,
+		Subdomain: subdomain,
+	})
+	if err != nil {
+		session.Logger().Debug("failed to notify client of closed tunnel",
+			slog.String("subdomain", subdomain),
+			slog.Any("error", err))
+		return
+	}
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(tunnelUpdateTimeout))
+	codec := protocol.NewCodec(stream, stream)
+	if err := codec.SendTunnelClosed(subdomain, reason); err != nil {
+		session.Logger().Debug("failed to notify client of closed tunnel",
+			slog.String("subdomain", subdomain),
+			slog.Any("error", err))
+	}
+}
+
 // GetSessionCount returns the number of active sessions.
 func (cp *ControlPlane) GetSessionCount() int {
 	cp.mu.RLock()
@@ -423,8 +751,19 @@ func (cp *ControlPlane) BroadcastShutdown(reason string, gracePeriodMs int) {
 }
 
 // ProxyRequest proxies an incoming HTTP request to the appropriate client.
-func (cp *ControlPlane) ProxyRequest(entry *TunnelEntry, requestID, method, path string) (io.ReadWriteCloser, error) {
+// The trace context of ctx is passed to the client in the stream header.
+func (cp *ControlPlane) ProxyRequest(ctx context.Context, entry *TunnelEntry, requestID, method, path string) (io.ReadWriteCloser, error) {
+	ctx, span := tracer.Start(ctx, "ControlPlane.ProxyRequest",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gotunnel.subdomain", entry.Subdomain),
+			attribute.String("gotunnel.session_id", entry.Session.ID),
+			attribute.Int("gotunnel.local_port", entry.LocalPort),
+		))
+	defer span.End()
+
 	if !entry.Session.IsActive() {
+		span.SetStatus(codes.Error, "session is not active")
 		return nil, fmt.Errorf("session is not active")
 	}
 
@@ -437,9 +776,12 @@ func (cp *ControlPlane) ProxyRequest(entry *TunnelEntry, requestID, method, path
 		Method:    method,
 		Path:      path,
 	}
+	telemetry.InjectStreamHeader(ctx, header)
 
 	stream, err := entry.Session.OpenStreamWithHeader(header)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to open stream")
 		return nil, fmt.Errorf("failed to open stream: %w", err)
 	}
 
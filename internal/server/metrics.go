@@ -0,0 +1,159 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// handshakeFailureNoTunnels labels handshakes where every requested tunnel was rejected.
+const handshakeFailureNoTunnels = "NO_ACTIVE_TUNNELS"
+
+// Metrics holds the Prometheus collectors for the server.
+// All methods are safe to call on a nil *Metrics, which disables collection.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requestsTotal     *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	bytesTotal        *prometheus.CounterVec
+	activeStreams     *prometheus.GaugeVec
+	handshakeFailures *prometheus.CounterVec
+	sessionDuration   prometheus.Histogram
+}
+
+// NewMetrics creates the server metrics. Live session and tunnel counts are
+// read from the control plane and registry at scrape time.
+func NewMetrics(cp *ControlPlane, registry *Registry) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gotunnel",
+			Name:      "http_requests_total",
+			Help:      "Proxied HTTP requests by subdomain and status class.",
+		}, []string{"subdomain", "status_class"}),
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gotunnel",
+			Name:      "http_request_duration_seconds",
+			Help:      "End-to-end latency of proxied HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"subdomain"}),
+
+		bytesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gotunnel",
+			Name:      "tunnel_bytes_total",
+			Help:      "Bytes proxied through tunnels; direction is \"in\" (to the client) or \"out\" (from it).",
+		}, []string{"subdomain", "direction"}),
+
+		activeStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gotunnel",
+			Name:      "active_streams",
+			Help:      "Streams currently open to tunnel clients.",
+		}, []string{"subdomain"}),
+
+		handshakeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gotunnel",
+			Name:      "handshake_failures_total",
+			Help:      "Rejected client handshakes by error code.",
+		}, []string{"code"}),
+
+		sessionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "gotunnel",
+			Name:      "session_duration_seconds",
+			Help:      "Lifetime of client sessions.",
+			Buckets:   []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requestsTotal,
+		m.requestDuration,
+		m.bytesTotal,
+		m.activeStreams,
+		m.handshakeFailures,
+		m.sessionDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "gotunnel",
+			Name:      "sessions_active",
+			Help:      "Currently connected client sessions.",
+		}, func() float64 { return float64(cp.GetSessionCount()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "gotunnel",
+			Name:      "tunnels_active",
+			Help:      "Currently registered tunnels.",
+		}, func() float64 { return float64(registry.GetTunnelCount()) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler returns the HTTP handler that serves the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a completed proxied request.
+func (m *Metrics) ObserveRequest(subdomain string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestsTotal.WithLabelValues(subdomain, statusClass(status)).Inc()
+	m.requestDuration.WithLabelValues(subdomain).Observe(duration.Seconds())
+}
+
+// AddBytes records bytes sent to (in) and received from (out) a tunnel client.
+func (m *Metrics) AddBytes(subdomain string, bytesIn, bytesOut int64) {
+	if m == nil {
+		return
+	}
+	m.bytesTotal.WithLabelValues(subdomain, "in").Add(float64(bytesIn))
+	m.bytesTotal.WithLabelValues(subdomain, "out").Add(float64(bytesOut))
+}
+
+// StreamOpened increments the active stream gauge for a subdomain.
+func (m *Metrics) StreamOpened(subdomain string) {
+	if m == nil {
+		return
+	}
+	m.activeStreams.WithLabelValues(subdomain).Inc()
+}
+
+// StreamClosed decrements the active stream gauge for a subdomain.
+func (m *Metrics) StreamClosed(subdomain string) {
+	if m == nil {
+		return
+	}
+	m.activeStreams.WithLabelValues(subdomain).Dec()
+}
+
+// HandshakeFailed records a rejected handshake.
+func (m *Metrics) HandshakeFailed(code string) {
+	if m == nil {
+		return
+	}
+	m.handshakeFailures.WithLabelValues(code).Inc()
+}
+
+// SessionEnded records the lifetime of a finished session.
+func (m *Metrics) SessionEnded(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.sessionDuration.Observe(duration.Seconds())
+}
+
+// statusClass maps an HTTP status code to its class label (e.g., "2xx").
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
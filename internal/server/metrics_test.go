@@ -0,0 +1,104 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+func TestMetrics_RecordsProxiedRequests(t *testing.T) {
+	env := newProxyTestEnv(t, newProxyTestConfig(), protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000}, echoHandler)
+	metrics := NewMetrics(env.proxy.controlPlane, env.proxy.registry)
+	env.proxy.SetMetrics(metrics)
+
+	req := httptest.NewRequest("POST", "http://myapp.example.com/echo", strings.NewReader("hello"))
+	env.proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape status = %d, want 200", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`gotunnel_http_requests_total{status_class="2xx",subdomain="myapp"} 1`,
+		`gotunnel_tunnel_bytes_total{direction="out",subdomain="myapp"}`,
+		`gotunnel_active_streams{subdomain="myapp"} 0`,
+		`gotunnel_tunnels_active 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestMetrics_NilSafe(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("x", 200, 0)
+	m.AddBytes("x", 1, 1)
+	m.StreamOpened("x")
+	m.StreamClosed("x")
+	m.HandshakeFailed(protocol.ErrorCodeUnauthorized)
+	m.SessionEnded(0)
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := map[int]string{200: "2xx", 302: "3xx", 404: "4xx", 502: "5xx", 0: "unknown"}
+	for status, want := range tests {
+		if got := statusClass(status); got != want {
+			t.Errorf("statusClass(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestUnifiedHandler_NoMetrics(t *testing.T) {
+	cfg := common.DefaultServerConfig()
+	cfg.Domain = "example.com"
+	cfg.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	srv, err := NewServer(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer srv.Close()
+
+	// Metrics name every tenant's subdomains; the public listener must not
+	// serve them for any host
+	for _, url := range []string{"http://example.com/metrics", "http://example.com/_metrics", "http://myapp.example.com/metrics"} {
+		rec := httptest.NewRecorder()
+		srv.UnifiedHandler().ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if strings.Contains(rec.Body.String(), "gotunnel_") {
+			t.Errorf("GET %s served metrics on the public listener", url)
+		}
+	}
+
+	if host, _, _ := net.SplitHostPort(cfg.Metrics.Addr); host != "127.0.0.1" {
+		t.Errorf("default metrics address = %q, want localhost only", cfg.Metrics.Addr)
+	}
+}
+
+func TestServer_CloseTwice(t *testing.T) {
+	cfg := common.DefaultServerConfig()
+	cfg.Domain = "example.com"
+	cfg.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	srv, err := NewServer(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	srv.meter.Stop()
+}
@@ -2,24 +2,49 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/anyhost/gotunnel/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer creates spans for proxied requests.
+var tracer = telemetry.Tracer("github.com/anyhost/gotunnel/internal/server")
+
+// errNoResponse indicates the tunnel client never sent a response header,
+// so an error status can still be written to the public client.
+var errNoResponse = errors.New("no response from tunnel")
+
+// RequestLogger stores proxied requests for the request inspector.
+type RequestLogger interface {
+	LogRequestFull(log *database.RequestLog)
+}
+
 // HTTPProxy handles incoming HTTP requests and proxies them to tunnel clients.
 type HTTPProxy struct {
 	config       *common.ServerConfig
 	registry     *Registry
 	controlPlane *ControlPlane
+	meter        *TransferMeter
+	metrics      *Metrics
+	cluster      *Cluster
+	requestLogs  RequestLogger
 	httpServer   *http.Server
 	httpsServer  *http.Server
 	logger       *slog.Logger
@@ -43,6 +68,27 @@ func NewHTTPProxy(cfg *common.ServerConfig, registry *Registry, cp *ControlPlane
 	}
 }
 
+// SetTransferMeter sets the meter used to account and cap monthly transfer.
+func (p *HTTPProxy) SetTransferMeter(meter *TransferMeter) {
+	p.meter = meter
+}
+
+// SetMetrics sets the Prometheus metrics to record proxied traffic in.
+func (p *HTTPProxy) SetMetrics(metrics *Metrics) {
+	p.metrics = metrics
+}
+
+// SetRequestLogger sets where proxied requests are logged, with their
+// headers and bodies, for the request inspector.
+func (p *HTTPProxy) SetRequestLogger(requestLogs RequestLogger) {
+	p.requestLogs = requestLogs
+}
+
+// SetCluster enables forwarding requests for tunnels held by other nodes.
+func (p *HTTPProxy) SetCluster(cluster *Cluster) {
+	p.cluster = cluster
+}
+
 // Start starts the HTTP proxy servers.
 func (p *HTTPProxy) Start() error {
 	handler := http.HandlerFunc(p.handleRequest)
@@ -134,7 +180,21 @@ func (p *HTTPProxy) Stop(gracePeriod time.Duration) error {
 
 // handleRequest handles an incoming HTTP request.
 func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
 	requestID := common.GenerateRequestID()
+
+	// Continue the caller's trace, if any
+	ctx, span := tracer.Start(telemetry.ExtractHTTP(r.Context(), r.Header), "HTTPProxy.handleRequest",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("server.address", r.Host),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("gotunnel.request_id", requestID),
+		))
+	defer span.End()
+	r = r.WithContext(ctx)
+
 	logger := p.logger.With(
 		slog.String("request_id", requestID),
 		slog.String("host", r.Host),
@@ -143,6 +203,9 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		slog.String("remote_addr", r.RemoteAddr),
 	)
 
+	// Requests forwarded by another node are served locally or not at all
+	forwarded := p.cluster.Authenticate(r)
+
 	// Check for WebSocket upgrade
 	isWebSocket := isWebSocketUpgrade(r)
 	if isWebSocket {
@@ -164,7 +227,18 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// The tunnel may be connected to another node
+	if !found && !forwarded {
+		if addr, ok := p.remoteOwner(r); ok {
+			logger.Debug("forwarding request to owning node", slog.String("node_addr", addr))
+			span.SetAttributes(attribute.String("gotunnel.node_addr", addr))
+			p.cluster.Forward(w, r, addr)
+			return
+		}
+	}
+
 	if !found {
+		span.SetAttributes(attribute.Int("http.response.status_code", http.StatusNotFound))
 		logger.Debug("no tunnel found for host or path")
 		http.Error(w, "Tunnel not found", http.StatusNotFound)
 		return
@@ -175,6 +249,27 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		slog.String("session_id", entry.Session.ID),
 	)
 
+	// Record status and latency for every request routed to a tunnel
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+	var requestBody *captureBuffer
+	if p.requestLogs != nil && !isWebSocket {
+		requestBody = p.newCaptureBuffer()
+		recorder.body = p.newCaptureBuffer()
+	}
+	span.SetAttributes(attribute.String("gotunnel.subdomain", entry.Subdomain))
+	defer func() {
+		status := recorder.Status()
+		p.metrics.ObserveRequest(entry.Subdomain, status, time.Since(startTime))
+		if requestBody != nil {
+			p.logRequest(entry.Subdomain, r, recorder, requestBody, time.Since(startTime))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}()
+
 	// Check if session is active
 	if !entry.Session.IsActive() {
 		logger.Warn("session is not active")
@@ -182,14 +277,69 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Enforce the monthly fair-use transfer cap
+	if p.meter != nil && p.meter.Exceeded(entry.Session.UserID, entry.Session.Limits().MaxMonthlyBytes) {
+		logger.Warn("monthly transfer cap exceeded", slog.String("user_id", entry.Session.UserID))
+		http.Error(w, "Monthly transfer limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if p.meter != nil && p.meter.OrganizationExceeded(entry.Subdomain, p.config.Limits.MaxMonthlyOrgTransferBytes) {
+		logger.Warn("organization monthly transfer cap exceeded")
+		http.Error(w, "Monthly transfer limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	// Reject oversized bodies up front; chunked bodies are cut off mid-stream
+	if maxBody := p.maxRequestBodySize(entry); maxBody > 0 && !isWebSocket {
+		if r.ContentLength > maxBody {
+			logger.Warn("request body too large",
+				slog.Int64("content_length", r.ContentLength),
+				slog.Int64("max", maxBody))
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+	}
+	if requestBody != nil && r.Body != nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, requestBody), r.Body}
+	}
+
+	// Apply the end-to-end request deadline (not to long-lived WebSockets)
+	if timeout := p.config.Timeouts.RequestTimeout; timeout > 0 && !isWebSocket {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	// Open stream to client
-	stream, err := p.controlPlane.ProxyRequest(entry, requestID, r.Method, r.URL.Path)
+	rawStream, err := p.controlPlane.ProxyRequest(ctx, entry, requestID, r.Method, r.URL.Path)
 	if err != nil {
 		logger.Error("failed to open stream", slog.Any("error", err))
 		http.Error(w, "Failed to connect to tunnel", http.StatusBadGateway)
 		return
 	}
-	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if conn, ok := rawStream.(net.Conn); ok {
+			conn.SetDeadline(deadline)
+		}
+	}
+
+	// Throttle and count all traffic through the tunnel
+	stream := NewMeteredStream(ctx, rawStream, entry.Limiter, entry.Session.Metrics())
+	p.metrics.StreamOpened(entry.Subdomain)
+	defer func() {
+		stream.Close()
+		p.metrics.StreamClosed(entry.Subdomain)
+		p.metrics.AddBytes(entry.Subdomain, stream.BytesWritten(), stream.BytesRead())
+		if p.meter != nil {
+			p.meter.Record(entry.Session.UserID, entry.Subdomain, stream.BytesWritten(), stream.BytesRead())
+		}
+	}()
 
 	// Handle WebSocket upgrade differently
 	if isWebSocket {
@@ -199,15 +349,32 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	// Forward request to client
 	if err := p.forwardRequest(stream, r); err != nil {
-		logger.Error("failed to forward request", slog.Any("error", err))
-		http.Error(w, "Failed to forward request", http.StatusBadGateway)
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			logger.Warn("request body too large", slog.Int64("max", maxBytesErr.Limit))
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		case isTimeout(ctx, err):
+			logger.Warn("request timed out while forwarding", slog.Any("error", err))
+			http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+		default:
+			logger.Error("failed to forward request", slog.Any("error", err))
+			http.Error(w, "Failed to forward request", http.StatusBadGateway)
+		}
 		return
 	}
 
 	// Read response from client
 	if err := p.forwardResponse(w, stream, logger); err != nil {
 		logger.Error("failed to forward response", slog.Any("error", err))
-		// Response may have already started, so we can't send an error
+		// Once the response has started we can't send an error
+		if errors.Is(err, errNoResponse) {
+			if isTimeout(ctx, err) {
+				http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+			} else {
+				http.Error(w, "Bad gateway", http.StatusBadGateway)
+			}
+		}
 		return
 	}
 
@@ -216,6 +383,9 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 // forwardRequest forwards an HTTP request to the tunnel stream.
 func (p *HTTPProxy) forwardRequest(stream io.Writer, r *http.Request) error {
+	// Let the local service join the trace
+	telemetry.InjectHTTP(r.Context(), r.Header)
+
 	// Write request line
 	requestLine := fmt.Sprintf("%s %s %s\r\n", r.Method, r.URL.RequestURI(), r.Proto)
 	if _, err := stream.Write([]byte(requestLine)); err != nil {
@@ -234,6 +404,21 @@ func (p *HTTPProxy) forwardRequest(stream io.Writer, r *http.Request) error {
 		}
 	}
 
+	// Frame the body: keep a known Content-Length, otherwise re-chunk it
+	// (net/http strips Transfer-Encoding and de-chunks incoming bodies)
+	hasBody := r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+	chunked := hasBody && r.ContentLength < 0
+	if r.ContentLength > 0 && r.Header.Get("Content-Length") == "" {
+		if _, err := fmt.Fprintf(stream, "Content-Length: %d\r\n", r.ContentLength); err != nil {
+			return fmt.Errorf("failed to write Content-Length: %w", err)
+		}
+	}
+	if chunked {
+		if _, err := stream.Write([]byte("Transfer-Encoding: chunked\r\n")); err != nil {
+			return fmt.Errorf("failed to write Transfer-Encoding: %w", err)
+		}
+	}
+
 	// Add X-Forwarded headers
 	if _, err := fmt.Fprintf(stream, "X-Forwarded-For: %s\r\n", getClientIP(r)); err != nil {
 		return fmt.Errorf("failed to write X-Forwarded-For: %w", err)
@@ -248,7 +433,18 @@ func (p *HTTPProxy) forwardRequest(stream io.Writer, r *http.Request) error {
 	}
 
 	// Copy body if present
-	if r.Body != nil && r.ContentLength != 0 {
+	if chunked {
+		cw := httputil.NewChunkedWriter(stream)
+		if _, err := io.Copy(cw, r.Body); err != nil {
+			return fmt.Errorf("failed to copy request body: %w", err)
+		}
+		if err := cw.Close(); err != nil {
+			return fmt.Errorf("failed to terminate chunked body: %w", err)
+		}
+		if _, err := stream.Write([]byte("\r\n")); err != nil {
+			return fmt.Errorf("failed to terminate chunked body: %w", err)
+		}
+	} else if hasBody {
 		if _, err := io.Copy(stream, r.Body); err != nil {
 			return fmt.Errorf("failed to copy request body: %w", err)
 		}
@@ -264,7 +460,7 @@ func (p *HTTPProxy) forwardResponse(w http.ResponseWriter, stream io.Reader, log
 	// Read response
 	resp, err := http.ReadResponse(reader, nil)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return fmt.Errorf("%w: %w", errNoResponse, err)
 	}
 	defer resp.Body.Close()
 
@@ -333,6 +529,118 @@ func (p *HTTPProxy) handleWebSocket(w http.ResponseWriter, r *http.Request, stre
 	logger.Debug("WebSocket connection closed")
 }
 
+// logRequest stores a completed request for the request inspector.
+func (p *HTTPProxy) logRequest(subdomain string, r *http.Request, recorder *statusRecorder, requestBody *captureBuffer, duration time.Duration) {
+	requestHeaders, _ := json.Marshal(r.Header)
+	responseHeaders, _ := json.Marshal(recorder.Header())
+	p.requestLogs.LogRequestFull(&database.RequestLog{
+		Subdomain:       subdomain,
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		StatusCode:      recorder.Status(),
+		DurationMs:      int(duration.Milliseconds()),
+		ClientIP:        getClientIP(r),
+		UserAgent:       r.UserAgent(),
+		RequestHeaders:  string(requestHeaders),
+		ResponseHeaders: string(responseHeaders),
+		RequestBody:     requestBody.String(),
+		ResponseBody:    recorder.body.String(),
+	})
+}
+
+// newCaptureBuffer returns a buffer for a logged body. It keeps one byte more
+// than the stored limit so the store can tell the body was truncated.
+func (p *HTTPProxy) newCaptureBuffer() *captureBuffer {
+	limit := p.config.RequestLogs.MaxBodySize
+	if limit > 0 {
+		limit++
+	}
+	return &captureBuffer{limit: limit}
+}
+
+// captureBuffer keeps the first limit bytes written to it, or everything if
+// limit is 0. Writes never fail, so it can sit beside the proxied stream.
+type captureBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+// Write keeps as much of b as fits under the limit.
+func (c *captureBuffer) Write(b []byte) (int, error) {
+	if c.limit > 0 {
+		room := c.limit - c.buf.Len()
+		if room <= 0 {
+			return len(b), nil
+		}
+		if len(b) > room {
+			c.buf.Write(b[:room])
+			return len(b), nil
+		}
+	}
+	c.buf.Write(b)
+	return len(b), nil
+}
+
+// String returns the captured bytes.
+func (c *captureBuffer) String() string {
+	return c.buf.String()
+}
+
+// statusRecorder captures the status code written to a ResponseWriter and,
+// if body is set, the start of the response body.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *captureBuffer
+}
+
+// WriteHeader records the status code and forwards it.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status and forwards the data.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.body != nil {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer if it supports flushing.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack forwards to the underlying writer, recording a protocol switch.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Status returns the recorded status code (200 if nothing was written).
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
 // isWebSocketUpgrade checks if the request is a WebSocket upgrade request.
 func isWebSocketUpgrade(r *http.Request) bool {
 	connection := strings.ToLower(r.Header.Get("Connection"))
@@ -379,6 +687,25 @@ func getScheme(r *http.Request) string {
 	return "http"
 }
 
+// maxRequestBodySize returns the body size limit for a tunnel. A tunnel may
+// lower the server-wide limit but never raise it.
+func (p *HTTPProxy) maxRequestBodySize(entry *TunnelEntry) int64 {
+	limit := p.config.Limits.MaxRequestBodySize
+	if entry.MaxRequestBodySize > 0 && (limit <= 0 || entry.MaxRequestBodySize < limit) {
+		limit = entry.MaxRequestBodySize
+	}
+	return limit
+}
+
+// isTimeout checks if an error was caused by the request deadline.
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // isConnectionReset checks if the error is a connection reset.
 func isConnectionReset(err error) bool {
 	if err == nil {
@@ -389,6 +716,30 @@ func isConnectionReset(err error) bool {
 		strings.Contains(errStr, "broken pipe")
 }
 
+// remoteOwner finds another node holding the tunnel a request is addressed
+// to, trying the same host, path and header routing as local lookups.
+func (p *HTTPProxy) remoteOwner(r *http.Request) (string, bool) {
+	if p.cluster == nil {
+		return "", false
+	}
+
+	if subdomain, ok := p.registry.SubdomainFromHost(r.Host); ok {
+		if addr, ok := p.cluster.RemoteOwner(subdomain); ok {
+			return addr, true
+		}
+	}
+	if path := strings.TrimPrefix(r.URL.Path, "/"); path != "" {
+		subdomain := strings.ToLower(strings.SplitN(path, "/", 2)[0])
+		if addr, ok := p.cluster.RemoteOwner(subdomain); ok {
+			return addr, true
+		}
+	}
+	if subdomain := r.Header.Get("X-Tunnel-Subdomain"); subdomain != "" {
+		return p.cluster.RemoteOwner(strings.ToLower(subdomain))
+	}
+	return "", false
+}
+
 // lookupByPath extracts subdomain from path and looks up the tunnel.
 // Supports format: /subdomain/... which gets rewritten to /...
 func (p *HTTPProxy) lookupByPath(r *http.Request) (*TunnelEntry, bool) {
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/hashicorp/yamux"
+)
+
+// proxyTestEnv wires an HTTPProxy to a session backed by an in-memory yamux
+// pipe. handler plays the tunnel client for every stream the proxy opens.
+type proxyTestEnv struct {
+	proxy   *HTTPProxy
+	session *Session
+	client  *yamux.Session
+}
+
+func newProxyTestEnv(t *testing.T, cfg *common.ServerConfig, tunnel protocol.TunnelConfig, handler func(net.Conn)) *proxyTestEnv {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+
+	muxServer, err := yamux.Server(serverConn, DefaultYamuxConfig())
+	if err != nil {
+		t.Fatalf("yamux.Server() error = %v", err)
+	}
+	muxClient, err := yamux.Client(clientConn, DefaultYamuxConfig())
+	if err != nil {
+		t.Fatalf("yamux.Client() error = %v", err)
+	}
+
+	session, err := NewSessionWithMux(&SessionConfig{
+		Conn:   serverConn,
+		Token:  "test-token",
+		UserID: "test-user",
+	}, muxServer)
+	if err != nil {
+		t.Fatalf("NewSessionWithMux() error = %v", err)
+	}
+	session.SetState(SessionStateActive)
+
+	registry := NewRegistry(cfg.Domain, nil)
+	if statuses := registry.Register(session, []protocol.TunnelConfig{tunnel}); statuses[0].Status != "active" {
+		t.Fatalf("Register() status = %+v", statuses[0])
+	}
+
+	cp := NewControlPlane(cfg, registry, &NoOpAuthenticator{}, slog.Default())
+	proxy := NewHTTPProxy(cfg, registry, cp, slog.Default())
+
+	go func() {
+		for {
+			stream, err := muxClient.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer stream.Close()
+				if _, err := protocol.ReadStreamHeader(stream); err != nil {
+					return
+				}
+				handler(stream)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		muxClient.Close()
+		session.Close()
+	})
+
+	return &proxyTestEnv{proxy: proxy, session: session, client: muxClient}
+}
+
+// echoHandler reads the forwarded request and answers 200 with its body.
+func echoHandler(stream net.Conn) {
+	req, err := http.ReadRequest(bufio.NewReader(stream))
+	if err != nil {
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(string(body))),
+	}
+	resp.Write(stream)
+}
+
+func newProxyTestConfig() *common.ServerConfig {
+	cfg := common.DefaultServerConfig()
+	cfg.Domain = "example.com"
+	return cfg
+}
+
+func TestHTTPProxy_ForwardsRequest(t *testing.T) {
+	env := newProxyTestEnv(t, newProxyTestConfig(), protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000}, echoHandler)
+
+	req := httptest.NewRequest("POST", "http://myapp.example.com/echo", strings.NewReader("hello"))
+	rec := httptest.NewRecorder()
+	env.proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if rec.Body.String() != "hello" {
+		t.Errorf("body = %q, want %q", rec.Body.String(), "hello")
+	}
+}
+
+func TestHTTPProxy_ForwardsChunkedBody(t *testing.T) {
+	env := newProxyTestEnv(t, newProxyTestConfig(), protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000}, echoHandler)
+
+	req := httptest.NewRequest("POST", "http://myapp.example.com/echo", strings.NewReader("streamed body"))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	env.proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "streamed body" {
+		t.Errorf("got %d %q, want 200 %q", rec.Code, rec.Body.String(), "streamed body")
+	}
+}
+
+// recordingRequestLogger keeps logged requests in memory.
+type recordingRequestLogger struct {
+	logs []*database.RequestLog
+}
+
+func (l *recordingRequestLogger) LogRequestFull(log *database.RequestLog) {
+	l.logs = append(l.logs, log)
+}
+
+func TestHTTPProxy_LogsRequests(t *testing.T) {
+	cfg := newProxyTestConfig()
+	cfg.RequestLogs.MaxBodySize = 4
+	env := newProxyTestEnv(t, cfg, protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000}, echoHandler)
+	requestLogs := &recordingRequestLogger{}
+	env.proxy.SetRequestLogger(requestLogs)
+
+	req := httptest.NewRequest("POST", "http://myapp.example.com/echo", strings.NewReader("hello"))
+	req.Header.Set("User-Agent", "test-agent")
+	rec := httptest.NewRecorder()
+	env.proxy.ServeHTTP(rec, req)
+
+	if rec.Body.String() != "hello" {
+		t.Errorf("body = %q, want the full body forwarded", rec.Body.String())
+	}
+	if len(requestLogs.logs) != 1 {
+		t.Fatalf("logged %d requests, want 1", len(requestLogs.logs))
+	}
+	log := requestLogs.logs[0]
+	if log.Subdomain != "myapp" || log.Method != "POST" || log.Path != "/echo" || log.StatusCode != http.StatusOK {
+		t.Errorf("log = %+v, want POST /echo on myapp with 200", log)
+	}
+	if log.UserAgent != "test-agent" || !strings.Contains(log.RequestHeaders, "test-agent") {
+		t.Errorf("user agent = %q, headers = %s", log.UserAgent, log.RequestHeaders)
+	}
+	// One byte beyond the limit is kept so the store marks the body truncated
+	if log.RequestBody != "hello" || log.ResponseBody != "hello" {
+		t.Errorf("bodies = %q, %q, want %q", log.RequestBody, log.ResponseBody, "hello")
+	}
+
+	req = httptest.NewRequest("POST", "http://myapp.example.com/echo", strings.NewReader("hello world"))
+	env.proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if got := requestLogs.logs[1].RequestBody; got != "hello" {
+		t.Errorf("request body = %q, want it cut to %q", got, "hello")
+	}
+}
+
+func TestHTTPProxy_RejectsLargeContentLength(t *testing.T) {
+	cfg := newProxyTestConfig()
+	cfg.Limits.MaxRequestBodySize = 10
+	env := newProxyTestEnv(t, cfg, protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000}, echoHandler)
+
+	req := httptest.NewRequest("POST", "http://myapp.example.com/", strings.NewReader(strings.Repeat("x", 11)))
+	rec := httptest.NewRecorder()
+	env.proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestHTTPProxy_RejectsLargeChunkedBody(t *testing.T) {
+	cfg := newProxyTestConfig()
+	cfg.Limits.MaxRequestBodySize = 1024
+	// The tunnel lowers the server-wide limit
+	tunnel := protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000, MaxRequestBodySize: 10}
+	env := newProxyTestEnv(t, cfg, tunnel, func(stream net.Conn) {
+		io.Copy(io.Discard, stream)
+	})
+
+	req := httptest.NewRequest("POST", "http://myapp.example.com/", strings.NewReader(strings.Repeat("x", 100)))
+	req.ContentLength = -1 // chunked
+	rec := httptest.NewRecorder()
+	env.proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestHTTPProxy_RequestTimeout(t *testing.T) {
+	cfg := newProxyTestConfig()
+	cfg.Timeouts.RequestTimeout = 100 * time.Millisecond
+	env := newProxyTestEnv(t, cfg, protocol.TunnelConfig{Subdomain: "myapp", LocalPort: 3000}, func(stream net.Conn) {
+		// Never respond
+		io.Copy(io.Discard, stream)
+	})
+
+	req := httptest.NewRequest("GET", "http://myapp.example.com/", nil)
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	env.proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, expected to time out after ~100ms", elapsed)
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// LimitOverrideProvider resolves per-user limit overrides from storage.
+type LimitOverrideProvider interface {
+	GetEffectiveLimitOverride(userID string) (*database.LimitOverride, error)
+}
+
+// UserLimits holds the effective connection and tunnel limits for a user.
+// A zero value means unlimited.
+type UserLimits struct {
+	MaxConnections  int   `json:"max_connections"`
+	MaxTunnels      int   `json:"max_tunnels"`
+	MaxMonthlyBytes int64 `json:"max_monthly_bytes"`
+}
+
+// userUsage tracks live sessions and tunnels held by a single user.
+type userUsage struct {
+	sessions int
+	tunnels  int
+}
+
+// SetLimitProvider sets the provider used to look up per-user limit overrides.
+func (cp *ControlPlane) SetLimitProvider(provider LimitOverrideProvider) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.limits = provider
+}
+
+// LimitsForUser returns the effective limits for a user, applying any
+// stored override on top of the server configuration.
+func (cp *ControlPlane) LimitsForUser(userID string) UserLimits {
+	limits := UserLimits{
+		MaxConnections:  cp.config.Limits.MaxConnectionsPerUser,
+		MaxTunnels:      cp.config.Limits.MaxTunnelsPerUser,
+		MaxMonthlyBytes: cp.config.Limits.MaxMonthlyTransferBytes,
+	}
+
+	cp.mu.RLock()
+	provider := cp.limits
+	cp.mu.RUnlock()
+
+	if provider == nil {
+		return limits
+	}
+
+	override, err := provider.GetEffectiveLimitOverride(userID)
+	if err != nil {
+		cp.logger.Warn("failed to load limit override, using defaults",
+			slog.String("user_id", userID),
+			slog.Any("error", err))
+		return limits
+	}
+	if override.MaxConnections != nil {
+		limits.MaxConnections = *override.MaxConnections
+	}
+	if override.MaxTunnels != nil {
+		limits.MaxTunnels = *override.MaxTunnels
+	}
+	if override.MaxMonthlyBytes != nil {
+		limits.MaxMonthlyBytes = *override.MaxMonthlyBytes
+	}
+	return limits
+}
+
+// quotaKey returns the key a client's quota is counted against. With no
+// authentication every client resolves to the token it sends, often the
+// same one, so anonymous clients are counted per remote IP instead.
+func (cp *ControlPlane) quotaKey(userID string, certUser bool, remoteAddr net.Addr) string {
+	if !acceptsAnyToken(cp.auth) || certUser {
+		return userID
+	}
+	host, _, err := net.SplitHostPort(remoteAddr.String())
+	if err != nil {
+		host = remoteAddr.String()
+	}
+	return "anonymous:" + host
+}
+
+// acceptsAnyToken reports whether auth lets every client in, as with
+// auth.mode none, including behind the database authenticator.
+func acceptsAnyToken(auth Authenticator) bool {
+	switch a := auth.(type) {
+	case *NoOpAuthenticator:
+		return true
+	case *DatabaseAuthenticator:
+		return acceptsAnyToken(a.fallback)
+	}
+	return false
+}
+
+// acquireQuota reserves one session and the given number of tunnels for a user
+// and returns the limits that applied. It returns ErrConnectionLimit or
+// ErrTunnelLimitReached if the user is over quota.
+func (cp *ControlPlane) acquireQuota(userID string, tunnels int) (UserLimits, error) {
+	limits := cp.LimitsForUser(userID)
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	usage, exists := cp.usage[userID]
+	if !exists {
+		usage = &userUsage{}
+	}
+
+	if limits.MaxConnections > 0 && usage.sessions >= limits.MaxConnections {
+		return limits, fmt.Errorf("%w: maximum %d concurrent connections allowed", protocol.ErrConnectionLimit, limits.MaxConnections)
+	}
+	if limits.MaxTunnels > 0 && usage.tunnels+tunnels > limits.MaxTunnels {
+		return limits, fmt.Errorf("%w: maximum %d tunnels allowed per user (%d in use)", protocol.ErrTunnelLimitReached, limits.MaxTunnels, usage.tunnels)
+	}
+
+	usage.sessions++
+	usage.tunnels += tunnels
+	cp.usage[userID] = usage
+	return limits, nil
+}
+
+// adjustQuota changes the number of tunnels held by a user without
+// affecting the session count (e.g., after some tunnels failed to register).
+func (cp *ControlPlane) adjustQuota(userID string, delta int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if usage, exists := cp.usage[userID]; exists {
+		usage.tunnels += delta
+		if usage.tunnels < 0 {
+			usage.tunnels = 0
+		}
+	}
+}
+
+// acquireTunnelQuota reserves one more tunnel for a user with an
+// established session. It returns ErrTunnelLimitReached if the user is
+// already at their limit.
+func (cp *ControlPlane) acquireTunnelQuota(userID string, limits UserLimits) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	usage, exists := cp.usage[userID]
+	if !exists {
+		usage = &userUsage{}
+	}
+	if limits.MaxTunnels > 0 && usage.tunnels+1 > limits.MaxTunnels {
+		return fmt.Errorf("%w: maximum %d tunnels allowed per user (%d in use)", protocol.ErrTunnelLimitReached, limits.MaxTunnels, usage.tunnels)
+	}
+
+	usage.tunnels++
+	cp.usage[userID] = usage
+	return nil
+}
+
+// releaseQuota returns one session and the given number of tunnels for a user.
+func (cp *ControlPlane) releaseQuota(userID string, tunnels int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	usage, exists := cp.usage[userID]
+	if !exists {
+		return
+	}
+
+	usage.sessions--
+	usage.tunnels -= tunnels
+	if usage.sessions <= 0 {
+		delete(cp.usage, userID)
+	} else if usage.tunnels < 0 {
+		usage.tunnels = 0
+	}
+}
+
+// GetUserUsage returns the number of live sessions and tunnels held by a user.
+func (cp *ControlPlane) GetUserUsage(userID string) (sessions, tunnels int) {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+
+	if usage, exists := cp.usage[userID]; exists {
+		return usage.sessions, usage.tunnels
+	}
+	return 0, 0
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+type staticLimitProvider struct {
+	overrides map[string]*database.LimitOverride
+}
+
+func (p *staticLimitProvider) GetEffectiveLimitOverride(userID string) (*database.LimitOverride, error) {
+	if o, ok := p.overrides[userID]; ok {
+		return o, nil
+	}
+	return &database.LimitOverride{}, nil
+}
+
+// userTokenAuthenticator accepts every token as the ID of a distinct user,
+// unlike NoOpAuthenticator whose clients are anonymous.
+type userTokenAuthenticator struct{}
+
+func (userTokenAuthenticator) Validate(token string) (bool, error)    { return true, nil }
+func (userTokenAuthenticator) GetUserID(token string) (string, error) { return token, nil }
+
+func newTestControlPlane(maxConns, maxTunnels int) *ControlPlane {
+	cfg := common.DefaultServerConfig()
+	cfg.Limits.MaxConnectionsPerUser = maxConns
+	cfg.Limits.MaxTunnelsPerUser = maxTunnels
+	return NewControlPlane(cfg, NewRegistry("example.com", nil), userTokenAuthenticator{}, slog.Default())
+}
+
+func TestControlPlane_ConnectionQuota(t *testing.T) {
+	cp := newTestControlPlane(2, 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cp.acquireQuota("alice", 1); err != nil {
+			t.Fatalf("acquire %d: unexpected error %v", i, err)
+		}
+	}
+
+	_, err := cp.acquireQuota("alice", 1)
+	if !errors.Is(err, protocol.ErrConnectionLimit) {
+		t.Fatalf("third acquire: got %v, want ErrConnectionLimit", err)
+	}
+	if code := protocol.ErrorToCode(err); code != protocol.ErrorCodeConnectionLimit {
+		t.Errorf("error code = %q, want %q", code, protocol.ErrorCodeConnectionLimit)
+	}
+
+	// Other users are unaffected
+	if _, err := cp.acquireQuota("bob", 1); err != nil {
+		t.Errorf("acquire for bob: unexpected error %v", err)
+	}
+
+	cp.releaseQuota("alice", 1)
+	if _, err := cp.acquireQuota("alice", 1); err != nil {
+		t.Errorf("acquire after release: unexpected error %v", err)
+	}
+}
+
+func TestControlPlane_TunnelQuotaAcrossConnections(t *testing.T) {
+	cp := newTestControlPlane(0, 5)
+
+	if _, err := cp.acquireQuota("alice", 3); err != nil {
+		t.Fatalf("first acquire: unexpected error %v", err)
+	}
+
+	_, err := cp.acquireQuota("alice", 3)
+	if !errors.Is(err, protocol.ErrTunnelLimitReached) {
+		t.Fatalf("second acquire: got %v, want ErrTunnelLimitReached", err)
+	}
+
+	// Only one of the first three tunnels actually registered
+	cp.adjustQuota("alice", -2)
+	if _, err := cp.acquireQuota("alice", 3); err != nil {
+		t.Errorf("acquire after adjust: unexpected error %v", err)
+	}
+
+	if sessions, tunnels := cp.GetUserUsage("alice"); sessions != 2 || tunnels != 4 {
+		t.Errorf("usage = (%d, %d), want (2, 4)", sessions, tunnels)
+	}
+}
+
+func TestControlPlane_LimitOverride(t *testing.T) {
+	cp := newTestControlPlane(1, 10)
+
+	maxConns := 3
+	cp.SetLimitProvider(&staticLimitProvider{
+		overrides: map[string]*database.LimitOverride{
+			"vip": {MaxConnections: &maxConns},
+		},
+	})
+
+	limits := cp.LimitsForUser("vip")
+	if limits.MaxConnections != 3 || limits.MaxTunnels != 10 {
+		t.Errorf("LimitsForUser(vip) = %+v, want 3 connections and 10 tunnels", limits)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := cp.acquireQuota("vip", 1); err != nil {
+			t.Fatalf("acquire %d: unexpected error %v", i, err)
+		}
+	}
+	if _, err := cp.acquireQuota("vip", 1); !errors.Is(err, protocol.ErrConnectionLimit) {
+		t.Errorf("acquire over override: got %v, want ErrConnectionLimit", err)
+	}
+}
+
+func TestControlPlane_QuotaKey(t *testing.T) {
+	addr := func(s string) net.Addr {
+		a, err := net.ResolveTCPAddr("tcp", s)
+		if err != nil {
+			t.Fatalf("ResolveTCPAddr(%q) error = %v", s, err)
+		}
+		return a
+	}
+
+	anonymous := newTestControlPlane(1, 10)
+	anonymous.auth = &NoOpAuthenticator{}
+	wrapped := newTestControlPlane(1, 10)
+	wrapped.auth = NewDatabaseAuthenticator(nil, &NoOpAuthenticator{})
+	tokens := newTestControlPlane(1, 10)
+
+	tests := []struct {
+		name     string
+		cp       *ControlPlane
+		userID   string
+		certUser bool
+		remote   string
+		want     string
+	}{
+		{"anonymous keyed by IP", anonymous, "public", false, "192.0.2.1:5000", "anonymous:192.0.2.1"},
+		{"anonymous ignores port", anonymous, "public", false, "192.0.2.1:6000", "anonymous:192.0.2.1"},
+		{"anonymous IPv6", anonymous, "public", false, "[2001:db8::1]:5000", "anonymous:2001:db8::1"},
+		{"anonymous behind database", wrapped, "public", false, "192.0.2.1:5000", "anonymous:192.0.2.1"},
+		{"certificate user", anonymous, "alice", true, "192.0.2.1:5000", "alice"},
+		{"token user", tokens, "alice", false, "192.0.2.1:5000", "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cp.quotaKey(tt.userID, tt.certUser, addr(tt.remote)); got != tt.want {
+				t.Errorf("quotaKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Anonymous clients on different addresses do not share a quota
+	for _, remote := range []string{"192.0.2.1:5000", "192.0.2.2:5000"} {
+		if _, err := anonymous.acquireQuota(anonymous.quotaKey("public", false, addr(remote)), 1); err != nil {
+			t.Errorf("acquire from %s: unexpected error %v", remote, err)
+		}
+	}
+	if _, err := anonymous.acquireQuota(anonymous.quotaKey("public", false, addr("192.0.2.1:7000")), 1); !errors.Is(err, protocol.ErrConnectionLimit) {
+		t.Errorf("second acquire from 192.0.2.1: got %v, want ErrConnectionLimit", err)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import "github.com/anyhost/gotunnel/internal/database"
+
+// Permission is an action an organization member may be allowed to take.
+type Permission string
+
+const (
+	// PermReserveSubdomains allows reserving subdomains for the organization.
+	PermReserveSubdomains Permission = "subdomains:reserve"
+
+	// PermConnectTunnels allows connecting tunnels on organization subdomains.
+	PermConnectTunnels Permission = "tunnels:connect"
+
+	// PermViewRequestLogs allows listing captured requests.
+	PermViewRequestLogs Permission = "requests:view"
+
+	// PermViewRequestBodies allows seeing captured request and response bodies.
+	PermViewRequestBodies Permission = "requests:view_bodies"
+
+	// PermManageMembers allows adding, removing and changing the role of members.
+	PermManageMembers Permission = "members:manage"
+
+	// PermManageTokens allows creating and revoking the organization's tokens.
+	PermManageTokens Permission = "tokens:manage"
+
+	// PermViewAuditLog allows reading and exporting the organization's audit log.
+	PermViewAuditLog Permission = "audit:view"
+)
+
+// rolePermissions is the permission matrix for organization roles.
+var rolePermissions = map[string]map[Permission]bool{
+	database.RoleOwner: {
+		PermReserveSubdomains: true,
+		PermConnectTunnels:    true,
+		PermViewRequestLogs:   true,
+		PermViewRequestBodies: true,
+		PermManageMembers:     true,
+		PermManageTokens:      true,
+		PermViewAuditLog:      true,
+	},
+	database.RoleAdmin: {
+		PermReserveSubdomains: true,
+		PermConnectTunnels:    true,
+		PermViewRequestLogs:   true,
+		PermViewRequestBodies: true,
+		PermManageMembers:     true,
+		PermManageTokens:      true,
+		PermViewAuditLog:      true,
+	},
+	database.RoleDeveloper: {
+		PermReserveSubdomains: true,
+		PermConnectTunnels:    true,
+		PermViewRequestLogs:   true,
+		PermViewRequestBodies: true,
+	},
+	database.RoleViewer: {
+		PermViewRequestLogs: true,
+	},
+}
+
+// RoleAllows reports whether an organization role grants a permission.
+// Unknown and empty roles grant nothing.
+func RoleAllows(role string, perm Permission) bool {
+	return rolePermissions[role][perm]
+}
@@ -2,11 +2,16 @@ package server
 
 import (
 	"fmt"
+	"log/slog"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/anyhost/gotunnel/internal/database"
 	"github.com/anyhost/gotunnel/internal/protocol"
+	"golang.org/x/time/rate"
 )
 
 // subdomainRegex validates subdomain format.
@@ -21,11 +26,55 @@ type TunnelEntry struct {
 	LocalHost string
 	Protocol  string
 	Session   *Session
+
+	// OrganizationID is set when the subdomain is reserved by an organization.
+	OrganizationID string
+
+	// MaxRequestBodySize is the client-requested body size limit (0 = server default).
+	MaxRequestBodySize int64
+
+	// Limiter throttles traffic for this tunnel (nil = unlimited).
+	Limiter *rate.Limiter
 }
 
 // SubdomainOwnerChecker checks subdomain ownership in the database.
 type SubdomainOwnerChecker interface {
-	GetSubdomainOwner(subdomain string) (string, error)
+	// GetSubdomainOwnership returns who reserved a subdomain, or nil.
+	GetSubdomainOwnership(subdomain string) (*database.SubdomainOwnership, error)
+
+	// GetUserRoleInOrganization returns the user's role, or empty if not a member.
+	GetUserRoleInOrganization(orgID, userID string) (string, error)
+}
+
+// SubdomainUsageRecorder records when subdomains are in use, so that unused
+// reservations can expire.
+type SubdomainUsageRecorder interface {
+	TouchSubdomain(subdomain string) error
+}
+
+// RegistryBackend shares subdomain ownership across server nodes. The local
+// Registry keeps the live sessions; the backend records which node holds
+// each subdomain so requests can be routed to it.
+type RegistryBackend interface {
+	// HeartbeatNode records that a node is alive and reachable at addr.
+	HeartbeatNode(nodeID, addr string, ttl time.Duration) error
+
+	// ClaimSubdomainLease leases a subdomain to a node. It returns false if
+	// another node holds an unexpired lease.
+	ClaimSubdomainLease(subdomain, nodeID string, ttl time.Duration) (bool, error)
+
+	// RenewSubdomainLeases extends every lease held by a node.
+	RenewSubdomainLeases(nodeID string, ttl time.Duration) error
+
+	// ReleaseSubdomainLease releases a subdomain lease held by a node.
+	ReleaseSubdomainLease(subdomain, nodeID string) error
+
+	// ReleaseNodeLeases removes a node and all of its leases.
+	ReleaseNodeLeases(nodeID string) error
+
+	// GetSubdomainLeaseHolder returns the live node holding a subdomain and
+	// its address, or empty strings if none does.
+	GetSubdomainLeaseHolder(subdomain string) (nodeID, addr string, err error)
 }
 
 // Registry manages the mapping of subdomains to active client sessions.
@@ -42,11 +91,35 @@ type Registry struct {
 	// reservedSubdomains is a set of subdomains that cannot be claimed.
 	reservedSubdomains map[string]struct{}
 
+	// blockedWords and blockedBrands may not appear anywhere in a subdomain.
+	blockedWords  []string
+	blockedBrands []string
+
+	// usage records when reserved subdomains are connected (optional).
+	usage *usageRecorder
+
 	// domain is the base domain (e.g., "example.com").
 	domain string
 
 	// ownerChecker is used to verify subdomain ownership from database.
 	ownerChecker SubdomainOwnerChecker
+
+	// auditor records tunnel registrations (optional).
+	auditor *Auditor
+
+	// bandwidthLimit is the per-tunnel bandwidth limit in bytes/sec (0 = unlimited).
+	bandwidthLimit int64
+
+	// backend shares subdomain leases with other nodes (nil = single node).
+	backend  RegistryBackend
+	nodeID   string
+	leaseTTL time.Duration
+
+	// leaseMu serializes claiming and releasing leases, which talk to the
+	// backend and so happen outside mu. Holding it from a claim until the
+	// tunnel is registered keeps a release from dropping a lease that a
+	// concurrent registration still relies on.
+	leaseMu sync.Mutex
 }
 
 // NewRegistry creates a new registry with the given base domain and reserved subdomains.
@@ -71,8 +144,70 @@ func (r *Registry) SetOwnerChecker(checker SubdomainOwnerChecker) {
 	r.ownerChecker = checker
 }
 
+// SetAuditor sets the auditor that records tunnel registrations.
+func (r *Registry) SetAuditor(auditor *Auditor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auditor = auditor
+}
+
+// SetBlocklist rejects subdomains containing any of the given words or
+// brand names.
+func (r *Registry) SetBlocklist(words, brands []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blockedWords = normalizeWords(words)
+	r.blockedBrands = normalizeWords(brands)
+}
+
+// SetUsageRecorder sets where subdomain connects and disconnects are
+// recorded. Writes happen in the background until Close.
+func (r *Registry) SetUsageRecorder(store SubdomainUsageRecorder, logger *slog.Logger) {
+	r.mu.Lock()
+	previous := r.usage
+	r.usage = newUsageRecorder(store, logger)
+	r.mu.Unlock()
+
+	previous.Close()
+}
+
+// Close writes pending usage records and stops recording them.
+func (r *Registry) Close() {
+	r.mu.RLock()
+	usage := r.usage
+	r.mu.RUnlock()
+
+	usage.Close()
+}
+
+// SetBandwidthLimit sets the bandwidth limit applied to newly registered tunnels.
+func (r *Registry) SetBandwidthLimit(bytesPerSec int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bandwidthLimit = bytesPerSec
+}
+
+// SetBackend shares subdomain ownership with other nodes through backend.
+// Registered subdomains are leased to nodeID for leaseTTL and must be renewed.
+func (r *Registry) SetBackend(backend RegistryBackend, nodeID string, leaseTTL time.Duration) {
+	r.leaseMu.Lock()
+	defer r.leaseMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backend = backend
+	r.nodeID = nodeID
+	r.leaseTTL = leaseTTL
+}
+
 // ValidateSubdomain checks if a subdomain is valid for registration.
 func (r *Registry) ValidateSubdomain(subdomain string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.validateSubdomainLocked(subdomain)
+}
+
+// validateSubdomainLocked is ValidateSubdomain for callers holding r.mu.
+func (r *Registry) validateSubdomainLocked(subdomain string) error {
 	subdomain = strings.ToLower(subdomain)
 
 	if !subdomainRegex.MatchString(subdomain) {
@@ -83,15 +218,161 @@ func (r *Registry) ValidateSubdomain(subdomain string) error {
 		return fmt.Errorf("%w: '%s' is reserved", protocol.ErrSubdomainReserved, subdomain)
 	}
 
+	lower := strings.ToLower(subdomain)
+	for _, brand := range r.blockedBrands {
+		if strings.Contains(lower, brand) {
+			return fmt.Errorf("%w: '%s' contains a protected name", protocol.ErrSubdomainReserved, subdomain)
+		}
+	}
+	for _, word := range r.blockedWords {
+		if strings.Contains(lower, word) {
+			return fmt.Errorf("%w: '%s' contains a blocked word", protocol.ErrSubdomainInvalid, subdomain)
+		}
+	}
+
 	return nil
 }
 
+// normalizeWords lowercases words and drops blanks.
+func normalizeWords(words []string) []string {
+	normalized := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			normalized = append(normalized, w)
+		}
+	}
+	return normalized
+}
+
+// touchLocked records that a subdomain was connected or disconnected.
+// Caller must hold r.mu.
+func (r *Registry) touchLocked(subdomain string) {
+	r.usage.Touch(subdomain)
+}
+
+// AddReservedSubdomain reserves a subdomain so it can no longer be
+// registered. A tunnel already connected on it keeps running until it is
+// unregistered.
+func (r *Registry) AddReservedSubdomain(subdomain string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reservedSubdomains[strings.ToLower(subdomain)] = struct{}{}
+}
+
+// RemoveReservedSubdomain makes a reserved subdomain available again. It
+// reports whether the subdomain was reserved.
+func (r *Registry) RemoveReservedSubdomain(subdomain string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subdomain = strings.ToLower(subdomain)
+	if _, reserved := r.reservedSubdomains[subdomain]; !reserved {
+		return false
+	}
+	delete(r.reservedSubdomains, subdomain)
+	return true
+}
+
+// ReservedSubdomains returns the reserved subdomains in sorted order.
+func (r *Registry) ReservedSubdomains() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reserved := make([]string, 0, len(r.reservedSubdomains))
+	for subdomain := range r.reservedSubdomains {
+		reserved = append(reserved, subdomain)
+	}
+	sort.Strings(reserved)
+	return reserved
+}
+
+// checkOwnershipLocked verifies that a user may connect a subdomain reserved
+// in the database. Subdomains reserved by an organization are usable by its
+// members whose role allows connecting tunnels. Unreserved subdomains are
+// first-come-first-served, as are all subdomains if ownership cannot be
+// looked up. It returns the owning organization's ID, if any.
+func (r *Registry) checkOwnershipLocked(subdomain, userID string) (string, error) {
+	owner, err := r.ownerChecker.GetSubdomainOwnership(subdomain)
+	if err != nil || owner == nil {
+		return "", nil
+	}
+
+	if owner.OrganizationID != "" {
+		role, err := r.ownerChecker.GetUserRoleInOrganization(owner.OrganizationID, userID)
+		if err != nil || role == "" {
+			return owner.OrganizationID, fmt.Errorf("subdomain is reserved by an organization you are not a member of")
+		}
+		if !RoleAllows(role, PermConnectTunnels) {
+			return owner.OrganizationID, fmt.Errorf("your %s role does not allow connecting organization tunnels", role)
+		}
+		return owner.OrganizationID, nil
+	}
+
+	if owner.UserID != userID {
+		return "", fmt.Errorf("subdomain is reserved by another user")
+	}
+	return "", nil
+}
+
 // Register registers tunnels for a session.
 // Returns a list of TunnelStatus for each requested tunnel.
 func (r *Registry) Register(session *Session, tunnels []protocol.TunnelConfig) []protocol.TunnelStatus {
+	r.mu.RLock()
+	backend := r.backend
+	r.mu.RUnlock()
+
+	var claimed map[string]bool
+	var claimErrors map[string]string
+	if backend != nil {
+		r.leaseMu.Lock()
+		defer r.leaseMu.Unlock()
+		claimed, claimErrors = r.claimLeases(tunnels)
+	}
+
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	results := r.registerLocked(session, tunnels, claimErrors)
 
+	// Leases claimed for tunnels that were then rejected
+	var unused []string
+	for subdomain := range claimed {
+		if _, exists := r.tunnels[subdomain]; !exists {
+			unused = append(unused, subdomain)
+		}
+	}
+	r.mu.Unlock()
+
+	r.releaseUnregisteredLeases(unused)
+	return results
+}
+
+// claimLeases claims the cluster-wide lease on each well-formed subdomain
+// in tunnels. It returns the subdomains claimed and the error to report for
+// those that could not be. Caller must hold r.leaseMu.
+func (r *Registry) claimLeases(tunnels []protocol.TunnelConfig) (map[string]bool, map[string]string) {
+	claimed := make(map[string]bool)
+	claimErrors := make(map[string]string)
+	for _, tc := range tunnels {
+		subdomain := strings.ToLower(tc.Subdomain)
+		if !subdomainRegex.MatchString(subdomain) || claimed[subdomain] {
+			continue
+		}
+
+		ok, err := r.backend.ClaimSubdomainLease(subdomain, r.nodeID, r.leaseTTL)
+		switch {
+		case err != nil:
+			claimErrors[subdomain] = "failed to claim subdomain"
+		case !ok:
+			claimErrors[subdomain] = protocol.ErrSubdomainTaken.Error()
+		default:
+			claimed[subdomain] = true
+		}
+	}
+	return claimed, claimErrors
+}
+
+// registerLocked registers tunnels whose leases, if the registry has a
+// backend, were claimed by Register. Caller must hold r.mu.
+func (r *Registry) registerLocked(session *Session, tunnels []protocol.TunnelConfig, claimErrors map[string]string) []protocol.TunnelStatus {
 	results := make([]protocol.TunnelStatus, 0, len(tunnels))
 
 	for _, tc := range tunnels {
@@ -102,7 +383,7 @@ func (r *Registry) Register(session *Session, tunnels []protocol.TunnelConfig) [
 		}
 
 		// Validate subdomain format
-		if err := r.ValidateSubdomain(subdomain); err != nil {
+		if err := r.validateSubdomainLocked(subdomain); err != nil {
 			status.Status = "error"
 			status.Error = err.Error()
 			results = append(results, status)
@@ -110,18 +391,17 @@ func (r *Registry) Register(session *Session, tunnels []protocol.TunnelConfig) [
 		}
 
 		// Check database ownership if owner checker is set
+		var orgID string
 		if r.ownerChecker != nil {
-			owner, err := r.ownerChecker.GetSubdomainOwner(subdomain)
-			if err == nil && owner != "" {
-				// Subdomain is reserved in database - check ownership
-				if owner != session.Token {
-					status.Status = "error"
-					status.Error = "subdomain is reserved by another user"
-					results = append(results, status)
-					continue
-				}
+			var err error
+			orgID, err = r.checkOwnershipLocked(subdomain, session.UserID)
+			if err != nil {
+				status.Status = "error"
+				status.Error = err.Error()
+				results = append(results, status)
+				r.auditTunnelLocked(AuditTunnelRejected, session, subdomain, orgID, status.Error)
+				continue
 			}
-			// If no owner or error, allow first-come-first-served
 		}
 
 		// Check if subdomain is already taken by another session
@@ -130,10 +410,19 @@ func (r *Registry) Register(session *Session, tunnels []protocol.TunnelConfig) [
 				status.Status = "error"
 				status.Error = protocol.ErrSubdomainTaken.Error()
 				results = append(results, status)
+				r.auditTunnelLocked(AuditTunnelRejected, session, subdomain, orgID, status.Error)
 				continue
 			}
 		}
 
+		// The subdomain is held by another node
+		if failure, failed := claimErrors[subdomain]; failed {
+			status.Status = "error"
+			status.Error = failure
+			results = append(results, status)
+			continue
+		}
+
 		// Register the tunnel
 		entry := &TunnelEntry{
 			Subdomain: subdomain,
@@ -141,12 +430,19 @@ func (r *Registry) Register(session *Session, tunnels []protocol.TunnelConfig) [
 			LocalHost: tc.LocalHost,
 			Protocol:  tc.Protocol,
 			Session:   session,
+
+			OrganizationID: orgID,
+
+			MaxRequestBodySize: tc.MaxRequestBodySize,
+			Limiter:            NewBandwidthLimiter(r.bandwidthLimit),
 		}
 		r.tunnels[subdomain] = entry
 
 		status.Status = "active"
 		status.URL = r.buildURL(subdomain, tc.Protocol)
 		results = append(results, status)
+		r.auditTunnelLocked(AuditTunnelRegistered, session, subdomain, orgID, "")
+		r.touchLocked(subdomain)
 	}
 
 	// Register session
@@ -158,38 +454,107 @@ func (r *Registry) Register(session *Session, tunnels []protocol.TunnelConfig) [
 // Unregister removes all tunnels for a session.
 func (r *Registry) Unregister(sessionID string) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
 	// Remove all tunnels belonging to this session
+	var removed []string
 	for subdomain, entry := range r.tunnels {
 		if entry.Session.ID == sessionID {
 			delete(r.tunnels, subdomain)
+			removed = append(removed, subdomain)
+			r.auditTunnelLocked(AuditTunnelUnregistered, entry.Session, subdomain, entry.OrganizationID, "")
+			r.touchLocked(subdomain)
 		}
 	}
 
 	// Remove session
 	delete(r.sessions, sessionID)
+	r.mu.Unlock()
+
+	r.releaseLeases(removed)
 }
 
 // UnregisterTunnel removes a specific tunnel for a session.
 func (r *Registry) UnregisterTunnel(sessionID, subdomain string) error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
 	subdomain = strings.ToLower(subdomain)
 	entry, exists := r.tunnels[subdomain]
 	if !exists {
+		r.mu.Unlock()
 		return protocol.ErrTunnelNotFound
 	}
 
 	if entry.Session.ID != sessionID {
+		r.mu.Unlock()
 		return protocol.ErrUnauthorized
 	}
 
 	delete(r.tunnels, subdomain)
+	r.auditTunnelLocked(AuditTunnelUnregistered, entry.Session, subdomain, entry.OrganizationID, "")
+	r.touchLocked(subdomain)
+	r.mu.Unlock()
+
+	r.releaseLeases([]string{subdomain})
 	return nil
 }
 
+// auditTunnelLocked records a tunnel event. Caller must hold r.mu.
+func (r *Registry) auditTunnelLocked(action string, session *Session, subdomain, orgID, failure string) {
+	if r.auditor == nil {
+		return
+	}
+	event := &database.AuditEvent{
+		Action:         action,
+		OrganizationID: orgID,
+		ActorID:        session.UserID,
+		TargetType:     "subdomain",
+		TargetID:       subdomain,
+		RemoteAddr:     session.RemoteAddr,
+		Metadata: map[string]string{
+			"session_id": session.ID,
+		},
+	}
+	if session.ClientID != "" {
+		event.Metadata["client_id"] = session.ClientID
+	}
+	if session.Token != "" {
+		event.Metadata["token"] = tokenFingerprint(session.Token)
+	}
+	if failure != "" {
+		event.Metadata["error"] = failure
+	}
+	r.auditor.Record(event)
+}
+
+// releaseLeases releases this node's leases on subdomains that no tunnel
+// has registered again since. Failures are ignored; the lease expires on its
+// own. Caller must not hold r.mu.
+func (r *Registry) releaseLeases(subdomains []string) {
+	r.mu.RLock()
+	backend := r.backend
+	r.mu.RUnlock()
+	if backend == nil || len(subdomains) == 0 {
+		return
+	}
+
+	r.leaseMu.Lock()
+	defer r.leaseMu.Unlock()
+	r.releaseUnregisteredLeases(subdomains)
+}
+
+// releaseUnregisteredLeases releases the leases of subdomains that are not
+// registered. Caller must hold r.leaseMu but not r.mu.
+func (r *Registry) releaseUnregisteredLeases(subdomains []string) {
+	for _, subdomain := range subdomains {
+		r.mu.RLock()
+		_, registered := r.tunnels[subdomain]
+		r.mu.RUnlock()
+		if !registered {
+			_ = r.backend.ReleaseSubdomainLease(subdomain, r.nodeID)
+		}
+	}
+}
+
 // Lookup finds the tunnel entry for a given subdomain.
 func (r *Registry) Lookup(subdomain string) (*TunnelEntry, bool) {
 	r.mu.RLock()
@@ -202,6 +567,15 @@ func (r *Registry) Lookup(subdomain string) (*TunnelEntry, bool) {
 
 // LookupByHost extracts the subdomain from a host header and looks up the tunnel.
 func (r *Registry) LookupByHost(host string) (*TunnelEntry, bool) {
+	subdomain, ok := r.SubdomainFromHost(host)
+	if !ok {
+		return nil, false
+	}
+	return r.Lookup(subdomain)
+}
+
+// SubdomainFromHost extracts the subdomain from a host header.
+func (r *Registry) SubdomainFromHost(host string) (string, bool) {
 	// Remove port if present
 	if idx := strings.Index(host, ":"); idx != -1 {
 		host = host[:idx]
@@ -211,11 +585,10 @@ func (r *Registry) LookupByHost(host string) (*TunnelEntry, bool) {
 	host = strings.ToLower(host)
 	suffix := "." + r.domain
 	if !strings.HasSuffix(host, suffix) {
-		return nil, false
+		return "", false
 	}
 
-	subdomain := strings.TrimSuffix(host, suffix)
-	return r.Lookup(subdomain)
+	return strings.TrimSuffix(host, suffix), true
 }
 
 // GetSession returns a session by ID.
@@ -260,6 +633,18 @@ func (r *Registry) buildURL(subdomain, tunnelProtocol string) string {
 	return fmt.Sprintf("%s://%s.%s", scheme, subdomain, r.domain)
 }
 
+// ActiveSubdomains returns the subdomains with a tunnel on this node.
+func (r *Registry) ActiveSubdomains() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subdomains := make([]string, 0, len(r.tunnels))
+	for subdomain := range r.tunnels {
+		subdomains = append(subdomains, subdomain)
+	}
+	return subdomains
+}
+
 // GetTunnelsForSession returns all tunnels for a given session.
 func (r *Registry) GetTunnelsForSession(sessionID string) []*TunnelEntry {
 	r.mu.RLock()
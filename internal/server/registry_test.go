@@ -1,8 +1,15 @@
 package server
 
 import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
 	"github.com/anyhost/gotunnel/internal/protocol"
 )
 
@@ -143,6 +150,63 @@ func TestRegistry_DuplicateSubdomain(t *testing.T) {
 	}
 }
 
+func TestRegistry_ReservedSubdomainOwnership(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("database.New() error = %v", err)
+	}
+	defer db.Close()
+
+	owner, _ := db.CreateUser("owner@example.com", "secret")
+	member, _ := db.CreateUser("member@example.com", "secret")
+	viewer, _ := db.CreateUser("viewer@example.com", "secret")
+	outsider, _ := db.CreateUser("outsider@example.com", "secret")
+
+	org, err := db.CreateOrganization("Acme", "acme", owner.ID)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	if err := db.AddOrganizationMember(org.ID, member.ID, database.RoleDeveloper); err != nil {
+		t.Fatalf("AddOrganizationMember() error = %v", err)
+	}
+	if err := db.AddOrganizationMember(org.ID, viewer.ID, database.RoleViewer); err != nil {
+		t.Fatalf("AddOrganizationMember() error = %v", err)
+	}
+	if err := db.ReserveSubdomainForOrg(org.ID, "teamapp"); err != nil {
+		t.Fatalf("ReserveSubdomainForOrg() error = %v", err)
+	}
+	if err := db.ReserveSubdomain(owner.ID, "ownerapp"); err != nil {
+		t.Fatalf("ReserveSubdomain() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		userID    string
+		subdomain string
+		want      string
+	}{
+		{"org member", member.ID, "teamapp", "active"},
+		{"org viewer", viewer.ID, "teamapp", "error"},
+		{"org outsider", outsider.ID, "teamapp", "error"},
+		{"user owner", owner.ID, "ownerapp", "active"},
+		{"other user", member.ID, "ownerapp", "error"},
+		{"unreserved", outsider.ID, "freeapp", "active"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry("example.com", nil)
+			registry.SetOwnerChecker(db)
+
+			status := registry.Register(&Session{ID: "s", UserID: tt.userID}, []protocol.TunnelConfig{
+				{Subdomain: tt.subdomain, LocalPort: 3000},
+			})
+			if status[0].Status != tt.want {
+				t.Errorf("Register() = %+v, want status %q", status[0], tt.want)
+			}
+		})
+	}
+}
+
 func TestRegistry_GetTunnelCount(t *testing.T) {
 	registry := NewRegistry("example.com", nil)
 
@@ -166,3 +230,78 @@ func TestRegistry_GetTunnelCount(t *testing.T) {
 		t.Errorf("count after unregister = %d, want 0", count)
 	}
 }
+
+func TestRegistry_Blocklist(t *testing.T) {
+	registry := NewRegistry("example.com", nil)
+	registry.SetBlocklist([]string{" Darn ", ""}, []string{"paypal"})
+
+	tests := []struct {
+		subdomain string
+		want      error
+	}{
+		{"myapp", nil},
+		{"paypal", protocol.ErrSubdomainReserved},
+		{"PayPal-login", protocol.ErrSubdomainReserved},
+		{"darnit", protocol.ErrSubdomainInvalid},
+	}
+	for _, tt := range tests {
+		err := registry.ValidateSubdomain(tt.subdomain)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("ValidateSubdomain(%q) = %v, want %v", tt.subdomain, err, tt.want)
+		}
+	}
+}
+
+// countingUsageStore counts subdomain touches and fails those for fail.
+type countingUsageStore struct {
+	mu      sync.Mutex
+	touches map[string]int
+	fail    string
+}
+
+func (s *countingUsageStore) TouchSubdomain(subdomain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touches[subdomain]++
+	if subdomain == s.fail {
+		return errors.New("database unavailable")
+	}
+	return nil
+}
+
+func TestRegistry_UsageRecordedBeforeClose(t *testing.T) {
+	store := &countingUsageStore{touches: make(map[string]int), fail: "broken"}
+	registry := NewRegistry("example.com", nil)
+	registry.SetUsageRecorder(store, slog.Default())
+
+	session := &Session{ID: "s1"}
+	registry.Register(session, []protocol.TunnelConfig{
+		{Subdomain: "myapp", LocalPort: 3000},
+		{Subdomain: "broken", LocalPort: 3001},
+	})
+	registry.Unregister(session.ID)
+	registry.Close()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, subdomain := range []string{"myapp", "broken"} {
+		if store.touches[subdomain] == 0 {
+			t.Errorf("%s not recorded by Close()", subdomain)
+		}
+	}
+}
+
+func TestReservationExpirer_StopTwice(t *testing.T) {
+	cfg := &common.SubdomainsConfig{ReservationTTL: time.Hour, ExpiryInterval: time.Hour}
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("database.New() error = %v", err)
+	}
+	defer db.Close()
+
+	expirer := NewReservationExpirer(cfg, db, NewRegistry("example.com", nil), slog.Default())
+	expirer.Start()
+
+	expirer.Stop()
+	expirer.Stop()
+}
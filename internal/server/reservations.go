@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/database"
+)
+
+// ReservationStore expires unused subdomain reservations.
+type ReservationStore interface {
+	ExpireSubdomainReservations(cutoff time.Time, keep []string) ([]database.SubdomainReservation, error)
+}
+
+// usageRecorder records subdomain usage in the background, so callers
+// holding the registry lock never wait on the store. Repeated touches of a
+// subdomain before it is written collapse into one.
+type usageRecorder struct {
+	store  SubdomainUsageRecorder
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	wake    chan struct{}
+	stopCh  chan struct{}
+	stopped sync.Once
+	wg      sync.WaitGroup
+}
+
+// newUsageRecorder creates a recorder writing to store and starts it.
+func newUsageRecorder(store SubdomainUsageRecorder, logger *slog.Logger) *usageRecorder {
+	u := &usageRecorder{
+		store:   store,
+		logger:  logger.With(slog.String("component", "usage_recorder")),
+		pending: make(map[string]struct{}),
+		wake:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+	u.wg.Add(1)
+	go u.run()
+	return u
+}
+
+// Touch queues a subdomain to be marked as used. A nil recorder does nothing.
+func (u *usageRecorder) Touch(subdomain string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	u.pending[subdomain] = struct{}{}
+	u.mu.Unlock()
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close writes any queued touches and stops the recorder.
+func (u *usageRecorder) Close() {
+	if u == nil {
+		return
+	}
+	u.stopped.Do(func() { close(u.stopCh) })
+	u.wg.Wait()
+}
+
+func (u *usageRecorder) run() {
+	defer u.wg.Done()
+	for {
+		select {
+		case <-u.wake:
+			u.flush()
+		case <-u.stopCh:
+			u.flush()
+			return
+		}
+	}
+}
+
+// flush writes every queued touch.
+func (u *usageRecorder) flush() {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[string]struct{})
+	u.mu.Unlock()
+
+	for subdomain := range pending {
+		if err := u.store.TouchSubdomain(subdomain); err != nil {
+			u.logger.Warn("failed to record subdomain usage",
+				slog.String("subdomain", subdomain),
+				slog.Any("error", err))
+		}
+	}
+}
+
+// ReservationExpirer periodically releases subdomain reservations that have
+// not had a tunnel for longer than the configured TTL.
+type ReservationExpirer struct {
+	config   *common.SubdomainsConfig
+	store    ReservationStore
+	registry *Registry
+	auditor  *Auditor
+	logger   *slog.Logger
+
+	stopCh  chan struct{}
+	stopped sync.Once
+	wg      sync.WaitGroup
+}
+
+// NewReservationExpirer creates an expirer for the given settings. Subdomains
+// connected to registry are never expired.
+func NewReservationExpirer(cfg *common.SubdomainsConfig, store ReservationStore, registry *Registry, logger *slog.Logger) *ReservationExpirer {
+	return &ReservationExpirer{
+		config:   cfg,
+		store:    store,
+		registry: registry,
+		logger:   logger.With(slog.String("component", "reservation_expirer")),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// SetAuditor sets where expired reservations are recorded.
+func (e *ReservationExpirer) SetAuditor(auditor *Auditor) {
+	e.auditor = auditor
+}
+
+// Start starts the background expiry loop.
+func (e *ReservationExpirer) Start() {
+	if e.config.ReservationTTL <= 0 || e.config.ExpiryInterval <= 0 {
+		return
+	}
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stop stops the expiry loop. It is safe to call more than once.
+func (e *ReservationExpirer) Stop() {
+	e.stopped.Do(func() { close(e.stopCh) })
+	e.wg.Wait()
+}
+
+// Expire releases reservations unused for longer than the TTL.
+func (e *ReservationExpirer) Expire() {
+	cutoff := time.Now().Add(-e.config.ReservationTTL)
+	expired, err := e.store.ExpireSubdomainReservations(cutoff, e.registry.ActiveSubdomains())
+	if err != nil {
+		e.logger.Error("failed to expire subdomain reservations", slog.Any("error", err))
+		return
+	}
+	for _, r := range expired {
+		metadata := map[string]string{"ttl": e.config.ReservationTTL.String()}
+		if r.UserID != "" {
+			metadata["user_id"] = r.UserID
+		}
+		e.auditor.Record(&database.AuditEvent{
+			OrganizationID: r.OrganizationID,
+			Action:         AuditSubdomainExpired,
+			TargetType:     "subdomain",
+			TargetID:       r.Subdomain,
+			Metadata:       metadata,
+		})
+	}
+	if len(expired) > 0 {
+		e.logger.Info("expired subdomain reservations", slog.Int("released", len(expired)))
+	}
+}
+
+func (e *ReservationExpirer) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.config.ExpiryInterval)
+	defer ticker.Stop()
+
+	e.Expire()
+
+	for {
+		select {
+		case <-e.stopCh:
+			return
+		case <-ticker.C:
+			e.Expire()
+		}
+	}
+}
+
+// LoadBlocklistFile reads blocked words from a file, one per line. Blank
+// lines and lines starting with '#' are ignored.
+func LoadBlocklistFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist: %w", err)
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	return words, nil
+}
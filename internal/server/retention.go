@@ -0,0 +1,104 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+)
+
+// RequestLogStore prunes and compacts stored request logs.
+type RequestLogStore interface {
+	PruneRequestLogs(maxAge time.Duration, maxRows int) (int64, error)
+	CompactRequestLogs() error
+}
+
+// RequestLogPruner periodically enforces request log retention and compacts
+// the database to return the freed space.
+type RequestLogPruner struct {
+	config *common.RequestLogsConfig
+	store  RequestLogStore
+	logger *slog.Logger
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewRequestLogPruner creates a pruner for the given retention settings.
+func NewRequestLogPruner(cfg *common.RequestLogsConfig, store RequestLogStore, logger *slog.Logger) *RequestLogPruner {
+	return &RequestLogPruner{
+		config: cfg,
+		store:  store,
+		logger: logger.With(slog.String("component", "request_log_pruner")),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start starts the background pruning loop.
+func (p *RequestLogPruner) Start() {
+	if p.config.PruneInterval <= 0 {
+		return
+	}
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop stops the pruning loop.
+func (p *RequestLogPruner) Stop() {
+	close(p.stopCh)
+	p.wg.Wait()
+}
+
+// Prune deletes request logs beyond the retention limits.
+func (p *RequestLogPruner) Prune() {
+	start := time.Now()
+	deleted, err := p.store.PruneRequestLogs(p.config.MaxAge, p.config.MaxRowsPerSubdomain)
+	if err != nil {
+		p.logger.Error("failed to prune request logs", slog.Any("error", err))
+		return
+	}
+	if deleted > 0 {
+		p.logger.Info("pruned request logs",
+			slog.Int64("deleted", deleted),
+			slog.Duration("duration", time.Since(start)))
+	}
+}
+
+// Compact reclaims space freed by pruning.
+func (p *RequestLogPruner) Compact() {
+	start := time.Now()
+	if err := p.store.CompactRequestLogs(); err != nil {
+		p.logger.Error("failed to compact database", slog.Any("error", err))
+		return
+	}
+	p.logger.Info("compacted database", slog.Duration("duration", time.Since(start)))
+}
+
+func (p *RequestLogPruner) run() {
+	defer p.wg.Done()
+
+	pruneTicker := time.NewTicker(p.config.PruneInterval)
+	defer pruneTicker.Stop()
+
+	// A nil channel never fires, disabling compaction
+	var compactC <-chan time.Time
+	if p.config.VacuumInterval > 0 {
+		compactTicker := time.NewTicker(p.config.VacuumInterval)
+		defer compactTicker.Stop()
+		compactC = compactTicker.C
+	}
+
+	p.Prune()
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-pruneTicker.C:
+			p.Prune()
+		case <-compactC:
+			p.Compact()
+		}
+	}
+}
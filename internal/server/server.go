@@ -4,15 +4,19 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/anyhost/gotunnel/internal/common"
 	"github.com/anyhost/gotunnel/internal/database"
+	"github.com/anyhost/gotunnel/internal/mail"
+	"github.com/anyhost/gotunnel/internal/telemetry"
 )
 
 // Server is the main tunnel server that coordinates all components.
@@ -22,22 +26,38 @@ type Server struct {
 	auth         Authenticator
 	controlPlane *ControlPlane
 	httpProxy    *HTTPProxy
+	meter        *TransferMeter
+	logPruner    *RequestLogPruner
+	expirer      *ReservationExpirer
+	auditor      *Auditor
+	metrics      *Metrics
+	metricsSrv   *http.Server
+	tracing      telemetry.ShutdownFunc
+	cluster      *Cluster
 	logger       *slog.Logger
 	db  *database.DB
     api *API
 
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // NewServer creates a new tunnel server.
-func NewServer(cfg *common.ServerConfig, logger *slog.Logger) (*Server, error) {
-	// Use configured database path, with fallback to default
-	dbPath := cfg.DatabasePath
-	if dbPath == "" {
-		dbPath = "./gotunnel.db"
+func NewServer(cfg *common.ServerConfig, logger *slog.Logger) (_ *Server, err error) {
+	// Use configured database URL or path, with fallback to default
+	dsn := cfg.DatabaseDSN()
+	if dsn == "" {
+		dsn = "./gotunnel.db"
 	}
-	db, err := database.New(dbPath)
+	db, err := database.Open(dsn, database.Options{
+		MaxOpenConns:    cfg.DatabasePool.MaxOpenConns,
+		MaxIdleConns:    cfg.DatabasePool.MaxIdleConns,
+		ConnMaxLifetime: cfg.DatabasePool.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.DatabasePool.ConnMaxIdleTime,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -45,31 +65,153 @@ func NewServer(cfg *common.ServerConfig, logger *slog.Logger) (*Server, error) {
 		logger = slog.Default()
 	}
 
+	// Bound the size of captured request and response bodies
+	db.SetRequestLogOptions(database.RequestLogOptions{
+		MaxBodySize:    cfg.RequestLogs.MaxBodySize,
+		CompressBodies: cfg.RequestLogs.CompressBodies,
+	})
+
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Stop whatever was started if a later step fails
+	var (
+		tracing   telemetry.ShutdownFunc
+		registry  *Registry
+		meter     *TransferMeter
+		cluster   *Cluster
+		logPruner *RequestLogPruner
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		cancel()
+		if cluster != nil {
+			cluster.Stop()
+		}
+		if meter != nil {
+			meter.Stop()
+		}
+		if logPruner != nil {
+			logPruner.Stop()
+		}
+		if registry != nil {
+			registry.Close()
+		}
+		if tracing != nil {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			tracing(shutdownCtx)
+			shutdownCancel()
+		}
+		db.Close()
+	}()
+
+	// Export spans to the configured OTLP collector
+	tracing, err = telemetry.Setup(context.Background(), &cfg.Tracing, "gotunnel-server")
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
 	// Create registry
-	registry := NewRegistry(cfg.Domain, cfg.ReservedSubdomains)
+	registry = NewRegistry(cfg.Domain, cfg.ReservedSubdomains)
 
 	// Set database as owner checker for subdomain ownership validation
 	registry.SetOwnerChecker(db)
+	registry.SetBandwidthLimit(cfg.Limits.MaxBandwidthBytesPerSec)
+	registry.SetUsageRecorder(db, logger)
+
+	// Reject subdomains containing blocked words or protected brand names
+	blockedWords := cfg.Subdomains.BlockedWords
+	if cfg.Subdomains.BlocklistFile != "" {
+		words, err := LoadBlocklistFile(cfg.Subdomains.BlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		blockedWords = append(append([]string(nil), blockedWords...), words...)
+	}
+	registry.SetBlocklist(blockedWords, cfg.Subdomains.BlockedBrands)
 
 	// Create base authenticator from config
 	baseAuth, err := NewAuthenticatorFromConfig(&cfg.Auth)
 	if err != nil {
-		cancel()
 		return nil, fmt.Errorf("failed to create authenticator: %w", err)
 	}
 
 	// Wrap with database authenticator to also accept user IDs from dashboard
 	auth := NewDatabaseAuthenticator(db, baseAuth)
+	auth.SetOrganizationTokenStore(db)
 
 	// Create control plane
 	controlPlane := NewControlPlane(cfg, registry, auth, logger)
 
-	// Create HTTP proxy
+	// Map verified client certificates to users for mutual TLS
+	if cfg.Auth.CertIdentitiesFile != "" {
+		certAuth := NewCertificateAuthenticator()
+		if err := certAuth.LoadFromFile(cfg.Auth.CertIdentitiesFile); err != nil {
+			return nil, fmt.Errorf("failed to load certificate identities: %w", err)
+		}
+		controlPlane.SetCertificateAuthenticator(certAuth)
+	}
+
+	// Use database overrides for per-user connection and tunnel quotas
+	controlPlane.SetLimitProvider(db)
+
+	// Create HTTP proxy with monthly transfer accounting
+	meter = NewTransferMeter(db, logger)
+	meter.Start()
 	httpProxy := NewHTTPProxy(cfg, registry, controlPlane, logger)
+	httpProxy.SetTransferMeter(meter)
+	httpProxy.SetRequestLogger(db)
+
+	// Share subdomain ownership with other nodes and forward their requests
+	if cfg.Cluster.Enabled {
+		if cfg.Cluster.NodeID == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("failed to determine node ID: %w", err)
+			}
+			cfg.Cluster.NodeID = hostname
+		}
+		registry.SetBackend(db, cfg.Cluster.NodeID, cfg.Cluster.LeaseTTL)
+		cluster = NewCluster(&cfg.Cluster, db, logger)
+		if err := cluster.Start(); err != nil {
+			// It never joined, so there is nothing to stop
+			cluster = nil
+			return nil, fmt.Errorf("failed to join cluster: %w", err)
+		}
+		httpProxy.SetCluster(cluster)
+	}
+
+	// Enforce request log retention in the background
+	logPruner = NewRequestLogPruner(&cfg.RequestLogs, db, logger)
+	logPruner.Start()
+
+	// Send organization invitations with the configured mail driver
+	mailer, err := mail.NewSender(&cfg.Mail, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mail sender: %w", err)
+	}
+
+	// Record security-relevant actions in the audit log
+	auditor := NewAuditor(db, logger)
+	registry.SetAuditor(auditor)
+	controlPlane.SetAuditor(auditor)
 
 	api := NewAPI(db, registry, controlPlane)
+	api.SetTransferMeter(meter)
+	api.SetMailer(mailer)
+	api.SetInviteConfig(cfg.Invites)
+	api.SetAuditor(auditor)
+
+	// Release reservations that have gone unused for too long
+	expirer := NewReservationExpirer(&cfg.Subdomains, db, registry, logger)
+	expirer.SetAuditor(auditor)
+	expirer.Start()
+
+	// Record Prometheus metrics for proxied traffic and client sessions
+	metrics := NewMetrics(controlPlane, registry)
+	controlPlane.SetMetrics(metrics)
+	httpProxy.SetMetrics(metrics)
 
 	return &Server{
 		config:       cfg,
@@ -77,6 +219,13 @@ func NewServer(cfg *common.ServerConfig, logger *slog.Logger) (*Server, error) {
 		auth:         auth,
 		controlPlane: controlPlane,
 		httpProxy:    httpProxy,
+		meter:        meter,
+		logPruner:    logPruner,
+		expirer:      expirer,
+		auditor:      auditor,
+		metrics:      metrics,
+		tracing:      tracing,
+		cluster:      cluster,
 		logger:       logger.With(slog.String("component", "server")),
 		ctx:          ctx,
 		cancel:       cancel,
@@ -133,10 +282,49 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to start HTTP proxy: %w", err)
 	}
 
+	// Start metrics endpoint
+	if err := s.StartMetrics(); err != nil {
+		s.httpProxy.Stop(5 * time.Second)
+		s.controlPlane.Stop(5 * time.Second)
+		return fmt.Errorf("failed to start metrics server: %w", err)
+	}
+
 	s.logger.Info("server started")
 	return nil
 }
 
+// StartMetrics serves /metrics on metrics.addr, if metrics are enabled.
+// Metrics are never served on the tenant-facing listeners. Start calls it;
+// callers serving UnifiedHandler on their own listener should call it
+// themselves.
+func (s *Server) StartMetrics() error {
+	if !s.config.Metrics.Enabled || s.config.Metrics.Addr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", s.config.Metrics.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Metrics.Addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.Handler())
+	s.metricsSrv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.logger.Info("metrics server listening", slog.String("addr", listener.Addr().String()))
+
+	go func() {
+		if err := s.metricsSrv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("metrics server error", slog.Any("error", err))
+		}
+	}()
+
+	return nil
+}
+
 // Stop gracefully stops all server components.
 func (s *Server) Stop(gracePeriod time.Duration) error {
 	s.logger.Info("stopping server", slog.Duration("grace_period", gracePeriod))
@@ -158,6 +346,10 @@ func (s *Server) Stop(gracePeriod time.Duration) error {
 		errs = append(errs, fmt.Errorf("control plane: %w", err))
 	}
 
+	if err := s.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("errors during shutdown: %v", errs)
 	}
@@ -166,6 +358,43 @@ func (s *Server) Stop(gracePeriod time.Duration) error {
 	return nil
 }
 
+// Close stops the metrics server, releases cluster leases, persists pending
+// transfer counters, stops request log pruning and reservation expiry and
+// flushes buffered subdomain usage, audit events and spans.
+// Stop calls it; callers serving UnifiedHandler on their own listener
+// should call it on shutdown instead. Calls after the first return the
+// first call's result.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { s.closeErr = s.close() })
+	return s.closeErr
+}
+
+func (s *Server) close() error {
+	if s.metricsSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := s.metricsSrv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			s.logger.Warn("failed to stop metrics server", slog.Any("error", err))
+		}
+	}
+	if s.cluster != nil {
+		s.cluster.Stop()
+	}
+	s.meter.Stop()
+	s.logPruner.Stop()
+	s.expirer.Stop()
+	s.registry.Close()
+	s.auditor.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.tracing(ctx); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	return nil
+}
+
 // Run starts the server and blocks until interrupted.
 func (s *Server) Run() error {
 	if err := s.Start(); err != nil {
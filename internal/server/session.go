@@ -58,6 +58,13 @@ type Session struct {
 	// Token is the authentication token used for this session.
 	Token string
 
+	// UserID is the user resolved from Token by the authenticator.
+	UserID string
+
+	// QuotaKey is what the session's connections and tunnels are counted
+	// against. It is UserID except for anonymous clients.
+	QuotaKey string
+
 	// RemoteAddr is the remote address of the client.
 	RemoteAddr string
 
@@ -67,8 +74,8 @@ type Session struct {
 	// conn is the underlying network connection.
 	conn net.Conn
 
-	// muxSession is the yamux multiplexer session.
-	muxSession *yamux.Session
+	// muxSession multiplexes the session's streams.
+	muxSession StreamMux
 
 	// codec is used for control message communication.
 	codec *protocol.Codec
@@ -96,6 +103,9 @@ type Session struct {
 
 	// metrics tracks session-level metrics.
 	metrics *SessionMetrics
+
+	// limits are the per-user limits resolved at handshake time.
+	limits UserLimits
 }
 
 // SessionMetrics tracks metrics for a session.
@@ -108,13 +118,58 @@ type SessionMetrics struct {
 	Errors          atomic.Int64
 }
 
+// SessionMetricsSnapshot is a point-in-time copy of SessionMetrics.
+type SessionMetricsSnapshot struct {
+	StreamsOpened   int64 `json:"streams_opened"`
+	StreamsClosed   int64 `json:"streams_closed"`
+	BytesSent       int64 `json:"bytes_sent"`
+	BytesReceived   int64 `json:"bytes_received"`
+	RequestsHandled int64 `json:"requests_handled"`
+	Errors          int64 `json:"errors"`
+}
+
+// Snapshot returns the current metric values.
+func (m *SessionMetrics) Snapshot() SessionMetricsSnapshot {
+	return SessionMetricsSnapshot{
+		StreamsOpened:   m.StreamsOpened.Load(),
+		StreamsClosed:   m.StreamsClosed.Load(),
+		BytesSent:       m.BytesSent.Load(),
+		BytesReceived:   m.BytesReceived.Load(),
+		RequestsHandled: m.RequestsHandled.Load(),
+		Errors:          m.Errors.Load(),
+	}
+}
+
+// StreamMux multiplexes streams over a client connection: yamux over a
+// byte-stream transport, or native QUIC streams. *yamux.Session implements
+// it.
+type StreamMux interface {
+	// Open opens a new stream to the client.
+	Open() (net.Conn, error)
+
+	// Accept waits for the client to open a stream.
+	Accept() (net.Conn, error)
+
+	// Close closes the connection and all its streams.
+	Close() error
+
+	// IsClosed reports whether the connection is closed.
+	IsClosed() bool
+
+	// RemoteAddr returns the client's address.
+	RemoteAddr() net.Addr
+}
+
 // SessionConfig holds configuration for creating a new session.
 type SessionConfig struct {
-	Conn       net.Conn
-	Token      string
-	ClientID   string
-	Logger     *slog.Logger
-	YamuxConf  *yamux.Config
+	Conn      net.Conn
+	Token     string
+	UserID    string
+	QuotaKey  string
+	ClientID  string
+	Limits    UserLimits
+	Logger    *slog.Logger
+	YamuxConf *yamux.Config
 }
 
 // NewSession creates a new session from an established connection.
@@ -149,6 +204,8 @@ func NewSession(cfg *SessionConfig) (*Session, error) {
 		ID:         sessionID,
 		ClientID:   cfg.ClientID,
 		Token:      cfg.Token,
+		UserID:     cfg.UserID,
+		QuotaKey:   cfg.QuotaKey,
 		RemoteAddr: cfg.Conn.RemoteAddr().String(),
 		CreatedAt:  time.Now(),
 		conn:       cfg.Conn,
@@ -158,6 +215,7 @@ func NewSession(cfg *SessionConfig) (*Session, error) {
 		ctx:        ctx,
 		cancel:     cancel,
 		metrics:    &SessionMetrics{},
+		limits:     cfg.Limits,
 	}
 
 	s.state.Store(int32(SessionStateConnecting))
@@ -166,9 +224,11 @@ func NewSession(cfg *SessionConfig) (*Session, error) {
 	return s, nil
 }
 
-// NewSessionWithMux creates a new session with an existing yamux session.
-// Use this when the yamux session has already been established (e.g., during handshake).
-func NewSessionWithMux(cfg *SessionConfig, muxSession *yamux.Session) (*Session, error) {
+// NewSessionWithMux creates a new session with an existing stream
+// multiplexer. Use this when it has already been established (e.g., during
+// handshake). cfg.Conn may be nil if the multiplexer has no underlying
+// byte stream, as with QUIC.
+func NewSessionWithMux(cfg *SessionConfig, muxSession StreamMux) (*Session, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	sessionID := common.GenerateSessionID()
@@ -180,14 +240,16 @@ func NewSessionWithMux(cfg *SessionConfig, muxSession *yamux.Session) (*Session,
 	logger = logger.With(
 		slog.String("session_id", sessionID),
 		slog.String("client_id", cfg.ClientID),
-		slog.String("remote_addr", cfg.Conn.RemoteAddr().String()),
+		slog.String("remote_addr", muxSession.RemoteAddr().String()),
 	)
 
 	s := &Session{
 		ID:         sessionID,
 		ClientID:   cfg.ClientID,
 		Token:      cfg.Token,
-		RemoteAddr: cfg.Conn.RemoteAddr().String(),
+		UserID:     cfg.UserID,
+		QuotaKey:   cfg.QuotaKey,
+		RemoteAddr: muxSession.RemoteAddr().String(),
 		CreatedAt:  time.Now(),
 		conn:       cfg.Conn,
 		muxSession: muxSession,
@@ -196,6 +258,7 @@ func NewSessionWithMux(cfg *SessionConfig, muxSession *yamux.Session) (*Session,
 		ctx:        ctx,
 		cancel:     cancel,
 		metrics:    &SessionMetrics{},
+		limits:     cfg.Limits,
 	}
 
 	s.state.Store(int32(SessionStateConnecting))
@@ -247,6 +310,11 @@ func (s *Session) Metrics() *SessionMetrics {
 	return s.metrics
 }
 
+// Limits returns the per-user limits that applied when the session was established.
+func (s *Session) Limits() UserLimits {
+	return s.limits
+}
+
 // OpenStream opens a new multiplexed stream to the client.
 // This is used by the server to send incoming requests to the client.
 func (s *Session) OpenStream() (net.Conn, error) {
@@ -254,7 +322,7 @@ func (s *Session) OpenStream() (net.Conn, error) {
 		return nil, fmt.Errorf("session is not active")
 	}
 
-	stream, err := s.muxSession.OpenStream()
+	stream, err := s.muxSession.Open()
 	if err != nil {
 		s.metrics.Errors.Add(1)
 		return nil, fmt.Errorf("failed to open stream: %w", err)
@@ -284,7 +352,7 @@ func (s *Session) OpenStreamWithHeader(header *protocol.StreamHeader) (net.Conn,
 // AcceptStream accepts an incoming stream from the client.
 // This is used for streams initiated by the client (e.g., control messages).
 func (s *Session) AcceptStream() (net.Conn, error) {
-	stream, err := s.muxSession.AcceptStream()
+	stream, err := s.muxSession.Accept()
 	if err != nil {
 		if err == io.EOF {
 			return nil, protocol.ErrConnectionClosed
@@ -345,7 +413,7 @@ func (s *Session) Close() error {
 
 	if s.muxSession != nil {
 		if err := s.muxSession.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("failed to close yamux session: %w", err))
+			errs = append(errs, fmt.Errorf("failed to close stream multiplexer: %w", err))
 		}
 	}
 
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// testCA issues certificates for TLS tests and writes them as PEM files.
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	ca := &testCA{t: t, dir: t.TempDir()}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	ca.cert, _ = x509.ParseCertificate(der)
+	ca.key = key
+	ca.file = ca.write("ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate and key signed by the CA and returns their paths.
+func (ca *testCA) issue(name string, template *x509.Certificate) (certFile, keyFile string) {
+	ca.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		ca.t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+	return ca.write(name+".pem", "CERTIFICATE", der), ca.write(name+"-key.pem", "PRIVATE KEY", keyDER)
+}
+
+func (ca *testCA) write(name, blockType string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		ca.t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestControlPlane_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue("server", &x509.Certificate{
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	spiffeID, _ := url.Parse("spiffe://example.com/agent/build")
+	agentCert, agentKey := ca.issue("agent", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "build-agent"},
+		URIs:        []*url.URL{spiffeID},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	otherCert, otherKey := ca.issue("other", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "unmapped"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	cp := newTestControlPlane(0, 0)
+	cp.config.TLS = common.TLSConfig{
+		Enabled:           true,
+		CertFile:          serverCert,
+		KeyFile:           serverKey,
+		Control:           true,
+		ClientCAFile:      ca.file,
+		RequireClientCert: true,
+	}
+	certAuth := NewCertificateAuthenticator()
+	certAuth.AddIdentity(spiffeID.String(), "carol")
+	cp.SetCertificateAuthenticator(certAuth)
+
+	serverConfig, err := cp.config.TLS.ControlTLSConfig()
+	if err != nil {
+		t.Fatalf("ControlTLSConfig() error = %v", err)
+	}
+	transport, err := ListenTLS("127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("ListenTLS() error = %v", err)
+	}
+	cp.Serve(transport)
+	defer cp.Stop(2 * time.Second)
+
+	dial := func(clientTLS common.ClientTLSConfig) (net.Conn, error) {
+		config, err := clientTLS.Build()
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		config.ServerName = "localhost"
+		return tls.Dial("tcp", transport.Addr().String(), config)
+	}
+
+	t.Run("mapped identity", func(t *testing.T) {
+		conn, err := dial(common.ClientTLSConfig{CAFile: ca.file, CertFile: agentCert, KeyFile: agentKey})
+		if err != nil {
+			t.Fatalf("dial error = %v", err)
+		}
+		defer conn.Close()
+
+		clientHandshake(t, conn, "", "by-cert")
+		if sessions, _ := cp.GetUserUsage("carol"); sessions != 1 {
+			t.Errorf("carol's sessions = %d, want 1", sessions)
+		}
+	})
+
+	t.Run("unmapped identity uses token", func(t *testing.T) {
+		conn, err := dial(common.ClientTLSConfig{CAFile: ca.file, CertFile: otherCert, KeyFile: otherKey})
+		if err != nil {
+			t.Fatalf("dial error = %v", err)
+		}
+		defer conn.Close()
+
+		clientHandshake(t, conn, "dave", "by-token")
+		if sessions, _ := cp.GetUserUsage("dave"); sessions != 1 {
+			t.Errorf("dave's sessions = %d, want 1", sessions)
+		}
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		conn, err := dial(common.ClientTLSConfig{CAFile: ca.file})
+		if err != nil {
+			return // rejected during the TLS 1.2 handshake
+		}
+		defer conn.Close()
+
+		// With TLS 1.3 the server rejects the certificate after the client
+		// finishes its side of the handshake
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+			t.Fatalf("connection without a client certificate was accepted: %v", err)
+		}
+	})
+}
+
+func TestCertificateAuthenticator_LoadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities")
+	data := "# SPIFFE IDs and common names\nspiffe://example.com/agent/build  carol\nbuild-agent dave\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	auth := NewCertificateAuthenticator()
+	if err := auth.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	if userID, err := auth.GetUserID("spiffe://example.com/agent/build"); err != nil || userID != "carol" {
+		t.Errorf("GetUserID(spiffe) = %q, %v, want carol", userID, err)
+	}
+	if valid, _ := auth.Validate("unknown"); valid {
+		t.Error("Validate(unknown) = true")
+	}
+
+	if err := os.WriteFile(path, []byte("missing-user\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := NewCertificateAuthenticator().LoadFromFile(path); err == nil {
+		t.Error("LoadFromFile() accepted a line without a user")
+	}
+}
+
+func TestControlPlane_TokenRequiredWithoutCertificate(t *testing.T) {
+	wantUnauthorized := func(t *testing.T, resp protocol.HandshakeResponse) {
+		t.Helper()
+		if resp.Success || resp.ErrorCode != protocol.ErrorCodeUnauthorized {
+			t.Errorf("handshake without a token = %+v, want UNAUTHORIZED", resp)
+		}
+	}
+
+	t.Run("plain connection", func(t *testing.T) {
+		wantUnauthorized(t, tryHandshake(t, newTestControlPlane(0, 0), "", "anon"))
+	})
+
+	t.Run("no authentication configured", func(t *testing.T) {
+		cp := newTestControlPlane(0, 0)
+		cp.auth = NewDatabaseAuthenticator(nil, &NoOpAuthenticator{})
+		wantUnauthorized(t, tryHandshake(t, cp, "", "anon"))
+	})
+
+	// Client certificates are optional here, so the server must not treat a
+	// TLS connection without a recognized one as authenticated
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue("server", &x509.Certificate{
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	otherCert, otherKey := ca.issue("other", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "unmapped"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	cp := newTestControlPlane(0, 0)
+	cp.config.TLS = common.TLSConfig{
+		Enabled:      true,
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		Control:      true,
+		ClientCAFile: ca.file,
+	}
+	cp.SetCertificateAuthenticator(NewCertificateAuthenticator())
+
+	serverConfig, err := cp.config.TLS.ControlTLSConfig()
+	if err != nil {
+		t.Fatalf("ControlTLSConfig() error = %v", err)
+	}
+	transport, err := ListenTLS("127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("ListenTLS() error = %v", err)
+	}
+	cp.Serve(transport)
+	defer cp.Stop(2 * time.Second)
+
+	for _, tt := range []struct {
+		name string
+		tls  common.ClientTLSConfig
+	}{
+		{"TLS without client certificate", common.ClientTLSConfig{CAFile: ca.file}},
+		{"TLS with unmapped client certificate", common.ClientTLSConfig{CAFile: ca.file, CertFile: otherCert, KeyFile: otherKey}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.tls.Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			config.ServerName = "localhost"
+			conn, err := tls.Dial("tcp", transport.Addr().String(), config)
+			if err != nil {
+				t.Fatalf("dial error = %v", err)
+			}
+			defer conn.Close()
+
+			wantUnauthorized(t, tryClientHandshake(t, conn, "", "anon"))
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/database"
+)
+
+// transferFlushInterval is how often in-memory transfer counters are persisted.
+const transferFlushInterval = 30 * time.Second
+
+// TransferStore persists monthly transfer totals.
+type TransferStore interface {
+	AddTransferUsage(subjectType, subjectID, period string, bytesIn, bytesOut int64) error
+	GetTransferUsage(subjectType, subjectID, period string) (*database.TransferUsage, error)
+	GetSubdomainOrganization(subdomain string) (string, error)
+}
+
+// transferKey identifies a user or organization in a billing period.
+type transferKey struct {
+	subjectType string
+	subjectID   string
+}
+
+// transferCounter holds the in-memory view of a subject's monthly transfer.
+type transferCounter struct {
+	// stored is the total in the store, which every node in a cluster adds
+	// to, as of the last load. loaded is false until the first load succeeds.
+	stored int64
+	loaded bool
+
+	// pendingIn and pendingOut have not been flushed to the store yet.
+	pendingIn  int64
+	pendingOut int64
+}
+
+// total returns the stored total plus what this node has not flushed yet.
+func (c *transferCounter) total() int64 {
+	return c.stored + c.pendingIn + c.pendingOut
+}
+
+// TransferMeter accumulates per-user and per-organization transfer totals
+// for the current calendar month and periodically persists them. Totals are
+// reloaded from the shared store on every flush, so caps hold across a
+// cluster to within one flush interval of traffic per node.
+type TransferMeter struct {
+	store  TransferStore
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	period   string
+	counters map[transferKey]*transferCounter
+	orgCache map[string]string // subdomain -> organization ID
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewTransferMeter creates a transfer meter backed by the given store.
+func NewTransferMeter(store TransferStore, logger *slog.Logger) *TransferMeter {
+	return &TransferMeter{
+		store:    store,
+		logger:   logger.With(slog.String("component", "transfer_meter")),
+		period:   currentPeriod(),
+		counters: make(map[transferKey]*transferCounter),
+		orgCache: make(map[string]string),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start starts the background flush loop.
+func (m *TransferMeter) Start() {
+	m.wg.Add(1)
+	go m.flushLoop()
+}
+
+// Stop stops the flush loop and persists any pending counters. It is safe
+// to call more than once.
+func (m *TransferMeter) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+	m.wg.Wait()
+	m.Flush()
+}
+
+// Record adds transferred bytes for a tunnel to its user's and organization's totals.
+// bytesIn is traffic from the public client into the tunnel, bytesOut the reverse.
+func (m *TransferMeter) Record(userID, subdomain string, bytesIn, bytesOut int64) {
+	if bytesIn == 0 && bytesOut == 0 {
+		return
+	}
+
+	orgID := m.organizationFor(subdomain)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollPeriodLocked()
+
+	if userID != "" {
+		m.addLocked(transferKey{database.TransferSubjectUser, userID}, bytesIn, bytesOut)
+	}
+	if orgID != "" {
+		m.addLocked(transferKey{database.TransferSubjectOrganization, orgID}, bytesIn, bytesOut)
+	}
+}
+
+// UserTotal returns the bytes a user has transferred in the current month.
+// The first call for a user in a period loads the stored total.
+func (m *TransferMeter) UserTotal(userID string) int64 {
+	return m.total(transferKey{database.TransferSubjectUser, userID})
+}
+
+// OrganizationTotal returns the bytes an organization's subdomains have
+// transferred in the current month.
+func (m *TransferMeter) OrganizationTotal(orgID string) int64 {
+	return m.total(transferKey{database.TransferSubjectOrganization, orgID})
+}
+
+// total returns a subject's total for the current month, loading the stored
+// total on first use in the period.
+func (m *TransferMeter) total(key transferKey) int64 {
+	m.mu.Lock()
+	m.rollPeriodLocked()
+	period := m.period
+	c := m.counterLocked(key)
+	if c.loaded {
+		defer m.mu.Unlock()
+		return c.total()
+	}
+	m.mu.Unlock()
+
+	stored, err := m.load(key, period)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c = m.counterLocked(key)
+	if err == nil && m.period == period && !c.loaded {
+		c.stored = stored
+		c.loaded = true
+	}
+	return c.total()
+}
+
+// Exceeded reports whether the user has reached the given monthly cap.
+// A cap of zero or less means unlimited.
+func (m *TransferMeter) Exceeded(userID string, maxMonthlyBytes int64) bool {
+	if maxMonthlyBytes <= 0 || userID == "" {
+		return false
+	}
+	return m.UserTotal(userID) >= maxMonthlyBytes
+}
+
+// OrganizationExceeded reports whether the organization owning a subdomain
+// has reached the given monthly cap. Subdomains outside an organization and
+// caps of zero or less are never exceeded.
+func (m *TransferMeter) OrganizationExceeded(subdomain string, maxMonthlyBytes int64) bool {
+	if maxMonthlyBytes <= 0 {
+		return false
+	}
+	orgID := m.organizationFor(subdomain)
+	if orgID == "" {
+		return false
+	}
+	return m.OrganizationTotal(orgID) >= maxMonthlyBytes
+}
+
+// Flush persists all pending counters to the store, then reloads the
+// stored totals to pick up what other nodes have recorded.
+func (m *TransferMeter) Flush() {
+	m.mu.Lock()
+	period := m.period
+	pending := make(map[transferKey]transferCounter)
+	var keys []transferKey
+	for key, c := range m.counters {
+		if c.loaded {
+			keys = append(keys, key)
+		}
+		if c.pendingIn == 0 && c.pendingOut == 0 {
+			continue
+		}
+		pending[key] = *c
+		// Counted as stored until the reload below replaces it
+		c.stored += c.pendingIn + c.pendingOut
+		c.pendingIn = 0
+		c.pendingOut = 0
+	}
+	// Refresh subdomain ownership on every flush so transfers follow reservations
+	m.orgCache = make(map[string]string)
+	m.mu.Unlock()
+
+	m.persist(period, pending)
+	m.refresh(period, keys)
+}
+
+// refresh reloads the stored totals of keys for a period.
+func (m *TransferMeter) refresh(period string, keys []transferKey) {
+	for _, key := range keys {
+		stored, err := m.load(key, period)
+		if err != nil {
+			continue
+		}
+
+		m.mu.Lock()
+		if c, exists := m.counters[key]; exists && m.period == period {
+			c.stored = stored
+			c.loaded = true
+		}
+		m.mu.Unlock()
+	}
+}
+
+// flushLoop periodically persists counters.
+func (m *TransferMeter) flushLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(transferFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.Flush()
+		}
+	}
+}
+
+// persist writes pending counters for a period to the store.
+func (m *TransferMeter) persist(period string, pending map[transferKey]transferCounter) {
+	for key, c := range pending {
+		if err := m.store.AddTransferUsage(key.subjectType, key.subjectID, period, c.pendingIn, c.pendingOut); err != nil {
+			m.logger.Error("failed to persist transfer usage",
+				slog.String("subject_type", key.subjectType),
+				slog.String("subject_id", key.subjectID),
+				slog.Any("error", err))
+		}
+	}
+}
+
+// organizationFor returns the organization owning a subdomain, caching lookups.
+func (m *TransferMeter) organizationFor(subdomain string) string {
+	m.mu.Lock()
+	orgID, cached := m.orgCache[subdomain]
+	m.mu.Unlock()
+	if cached {
+		return orgID
+	}
+
+	orgID, err := m.store.GetSubdomainOrganization(subdomain)
+	if err != nil {
+		m.logger.Warn("failed to resolve subdomain organization",
+			slog.String("subdomain", subdomain),
+			slog.Any("error", err))
+		return ""
+	}
+
+	m.mu.Lock()
+	m.orgCache[subdomain] = orgID
+	m.mu.Unlock()
+	return orgID
+}
+
+// addLocked adds bytes to a subject's counter. Caller must hold m.mu.
+func (m *TransferMeter) addLocked(key transferKey, bytesIn, bytesOut int64) {
+	c := m.counterLocked(key)
+	c.pendingIn += bytesIn
+	c.pendingOut += bytesOut
+}
+
+// counterLocked returns the counter for a subject, creating an unloaded one
+// on first use in the period. Caller must hold m.mu.
+func (m *TransferMeter) counterLocked(key transferKey) *transferCounter {
+	c, exists := m.counters[key]
+	if !exists {
+		c = &transferCounter{}
+		m.counters[key] = c
+	}
+	return c
+}
+
+// load returns a subject's stored total for a period. Failures are logged
+// and leave the counter unloaded so the next call retries.
+func (m *TransferMeter) load(key transferKey, period string) (int64, error) {
+	usage, err := m.store.GetTransferUsage(key.subjectType, key.subjectID, period)
+	if err != nil {
+		m.logger.Warn("failed to load transfer usage",
+			slog.String("subject_type", key.subjectType),
+			slog.String("subject_id", key.subjectID),
+			slog.Any("error", err))
+		return 0, err
+	}
+	return usage.BytesIn + usage.BytesOut, nil
+}
+
+// rollPeriodLocked starts a new period when the month changes, persisting
+// whatever was pending for the old one. Caller must hold m.mu.
+func (m *TransferMeter) rollPeriodLocked() {
+	period := currentPeriod()
+	if period == m.period {
+		return
+	}
+
+	pending := make(map[transferKey]transferCounter)
+	for key, c := range m.counters {
+		if c.pendingIn != 0 || c.pendingOut != 0 {
+			pending[key] = *c
+		}
+	}
+	oldPeriod := m.period
+
+	m.period = period
+	m.counters = make(map[transferKey]*transferCounter)
+
+	go m.persist(oldPeriod, pending)
+}
+
+// currentPeriod returns the billing period for the current time ("YYYY-MM", UTC).
+func currentPeriod() string {
+	return time.Now().UTC().Format("2006-01")
+}
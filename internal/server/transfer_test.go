@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/anyhost/gotunnel/internal/database"
+)
+
+// memoryTransferStore is a TransferStore shared by several meters, as the
+// database is shared by the nodes of a cluster.
+type memoryTransferStore struct {
+	mu     sync.Mutex
+	totals map[string]int64
+	orgs   map[string]string // subdomain -> organization ID
+	fail   bool
+}
+
+func (s *memoryTransferStore) AddTransferUsage(subjectType, subjectID, period string, bytesIn, bytesOut int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totals[subjectType+"/"+subjectID] += bytesIn + bytesOut
+	return nil
+}
+
+func (s *memoryTransferStore) GetTransferUsage(subjectType, subjectID, period string) (*database.TransferUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return nil, errors.New("database unavailable")
+	}
+	return &database.TransferUsage{Period: period, BytesIn: s.totals[subjectType+"/"+subjectID]}, nil
+}
+
+func (s *memoryTransferStore) GetSubdomainOrganization(subdomain string) (string, error) {
+	return s.orgs[subdomain], nil
+}
+
+func (s *memoryTransferStore) setFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func TestTransferMeter_RetriesFailedLoad(t *testing.T) {
+	store := &memoryTransferStore{totals: map[string]int64{"user/u1": 900}}
+	store.setFail(true)
+	meter := NewTransferMeter(store, slog.Default())
+
+	if meter.Exceeded("u1", 1000) {
+		t.Error("Exceeded() with the store down = true, want false")
+	}
+	meter.Record("u1", "web", 50, 50)
+
+	// Once the store is back, the stored total is loaded rather than the
+	// zero from the failed attempt
+	store.setFail(false)
+	if got := meter.UserTotal("u1"); got != 1000 {
+		t.Errorf("UserTotal() = %d, want 1000", got)
+	}
+	if !meter.Exceeded("u1", 1000) {
+		t.Error("Exceeded() = false, want true")
+	}
+}
+
+func TestTransferMeter_SharedTotal(t *testing.T) {
+	store := &memoryTransferStore{totals: make(map[string]int64)}
+	node1 := NewTransferMeter(store, slog.Default())
+	node2 := NewTransferMeter(store, slog.Default())
+
+	if node1.Exceeded("u1", 1000) || node2.Exceeded("u1", 1000) {
+		t.Fatal("Exceeded() before any transfer = true")
+	}
+	node1.Record("u1", "web", 300, 300)
+	node2.Record("u1", "web", 300, 0)
+	node1.Flush()
+	node2.Flush()
+
+	// Each node sees the other's flushed transfer on its next flush
+	node1.Flush()
+	if got := node1.UserTotal("u1"); got != 900 {
+		t.Errorf("node1 UserTotal() = %d, want 900", got)
+	}
+	node2.Record("u1", "web", 100, 0)
+	if !node2.Exceeded("u1", 1000) {
+		t.Errorf("node2 Exceeded() at %d of 1000 = false, want true", node2.UserTotal("u1"))
+	}
+}
+
+func TestTransferMeter_OrganizationCap(t *testing.T) {
+	store := &memoryTransferStore{
+		totals: make(map[string]int64),
+		orgs:   map[string]string{"web": "acme"},
+	}
+	meter := NewTransferMeter(store, slog.Default())
+
+	// Two members stay under their own caps but together use up the organization's
+	meter.Record("u1", "web", 300, 300)
+	meter.Record("u2", "web", 400, 0)
+	if meter.Exceeded("u1", 1000) || meter.Exceeded("u2", 1000) {
+		t.Error("Exceeded() = true for a user under the cap")
+	}
+	if got := meter.OrganizationTotal("acme"); got != 1000 {
+		t.Errorf("OrganizationTotal() = %d, want 1000", got)
+	}
+	if !meter.OrganizationExceeded("web", 1000) {
+		t.Error("OrganizationExceeded() = false, want true")
+	}
+	if meter.OrganizationExceeded("web", 0) {
+		t.Error("OrganizationExceeded() with no cap = true")
+	}
+
+	// Subdomains outside an organization only count against their user
+	meter.Record("u1", "personal", 5000, 0)
+	if meter.OrganizationExceeded("personal", 1000) {
+		t.Error("OrganizationExceeded() for a personal subdomain = true")
+	}
+}
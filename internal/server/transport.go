@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+// Transport is a source of client connections for the control plane. Every
+// connection carries one yamux session and goes through the same handshake,
+// whatever the transport underneath.
+type Transport interface {
+	// Name identifies the transport in logs and audit events.
+	Name() string
+
+	// Accept waits for the next client connection. It returns net.ErrClosed
+	// once the transport is closed.
+	Accept() (net.Conn, error)
+
+	// Close stops accepting connections.
+	Close() error
+}
+
+// ListenerTransport accepts connections from a net.Listener.
+type ListenerTransport struct {
+	net.Listener
+	name string
+}
+
+// NewListenerTransport returns a transport named name accepting from
+// listener.
+func NewListenerTransport(name string, listener net.Listener) *ListenerTransport {
+	return &ListenerTransport{Listener: listener, name: name}
+}
+
+// ListenTCP returns a transport accepting raw TCP connections on addr.
+func ListenTCP(addr string) (*ListenerTransport, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return NewListenerTransport("tcp", listener), nil
+}
+
+// ListenTLS returns a transport accepting TLS connections on addr.
+func ListenTLS(addr string, config *tls.Config) (*ListenerTransport, error) {
+	listener, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return NewListenerTransport("tls", listener), nil
+}
+
+// Name implements Transport.
+func (t *ListenerTransport) Name() string {
+	return t.name
+}
+
+// QUICTransport accepts QUIC connections. Their streams are multiplexed
+// natively, so the control plane serves it with ServeQUIC rather than
+// running yamux over a byte stream.
+type QUICTransport struct {
+	listener *quic.Listener
+}
+
+// ListenQUIC returns a transport accepting QUIC connections on the UDP
+// address addr. config supplies the server certificate and, for mutual
+// TLS, client verification.
+func ListenQUIC(addr string, config *tls.Config) (*QUICTransport, error) {
+	config = config.Clone()
+	config.NextProtos = []string{common.QUICProtocol}
+
+	listener, err := quic.ListenAddr(addr, config, common.QUICConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &QUICTransport{listener: listener}, nil
+}
+
+// Name identifies the transport in logs and audit events.
+func (t *QUICTransport) Name() string {
+	return "quic"
+}
+
+// Accept waits for the next client connection, after its TLS handshake. It
+// returns net.ErrClosed once the transport is closed.
+func (t *QUICTransport) Accept() (*quic.Conn, error) {
+	conn, err := t.listener.Accept(context.Background())
+	if errors.Is(err, quic.ErrServerClosed) {
+		return nil, net.ErrClosed
+	}
+	return conn, err
+}
+
+// Addr returns the UDP address the transport listens on.
+func (t *QUICTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Close stops accepting connections.
+func (t *QUICTransport) Close() error {
+	return t.listener.Close()
+}
+
+// WebSocketTransport accepts connections upgraded from HTTP requests. It is
+// an http.Handler; Accept returns the upgraded connections.
+type WebSocketTransport struct {
+	upgrader websocket.Upgrader
+	logger   *slog.Logger
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWebSocketTransport creates a WebSocket transport checking request
+// origins against the server config.
+func NewWebSocketTransport(cfg *common.ServerConfig, logger *slog.Logger) *WebSocketTransport {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &WebSocketTransport{
+		upgrader: createUpgrader(cfg),
+		logger:   logger.With(slog.String("transport", "websocket")),
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+}
+
+// createUpgrader creates a WebSocket upgrader with origin checking based on config.
+// For tunnel connections, we're more permissive since the tunnel protocol has its own auth.
+func createUpgrader(config *common.ServerConfig) websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  16 * 1024,
+		WriteBufferSize: 16 * 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			// If no origin header (e.g., CLI clients), allow
+			if origin == "" {
+				return true
+			}
+			// Check against allowed origins
+			return config.IsOriginAllowed(origin)
+		},
+	}
+}
+
+// Name implements Transport.
+func (t *WebSocketTransport) Name() string {
+	return "websocket"
+}
+
+// ServeHTTP upgrades the request and queues the connection for Accept.
+func (t *WebSocketTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := t.logger.With(slog.String("remote_addr", r.RemoteAddr))
+	logger.Debug("WebSocket connection request")
+
+	ws, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("failed to upgrade to WebSocket", slog.Any("error", err))
+		return
+	}
+
+	conn := common.NewWSConn(ws)
+	select {
+	case t.conns <- conn:
+	case <-t.done:
+		conn.Close()
+	}
+}
+
+// Accept implements Transport.
+func (t *WebSocketTransport) Accept() (net.Conn, error) {
+	select {
+	case conn := <-t.conns:
+		return conn, nil
+	case <-t.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements Transport.
+func (t *WebSocketTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	return nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+func TestControlPlane_Transports(t *testing.T) {
+	cp := newTestControlPlane(0, 0)
+
+	tcp, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenTCP() error = %v", err)
+	}
+	cp.Serve(tcp)
+
+	ts := httptest.NewServer(http.HandlerFunc(cp.HandleWebSocket))
+	defer ts.Close()
+
+	dials := []struct {
+		name      string
+		subdomain string
+		dial      func() (net.Conn, error)
+	}{
+		{"tcp", "over-tcp", func() (net.Conn, error) {
+			return net.Dial("tcp", tcp.Addr().String())
+		}},
+		{"websocket", "over-ws", func() (net.Conn, error) {
+			ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+			if err != nil {
+				return nil, err
+			}
+			return common.NewWSConn(ws), nil
+		}},
+	}
+
+	for _, d := range dials {
+		t.Run(d.name, func(t *testing.T) {
+			conn, err := d.dial()
+			if err != nil {
+				t.Fatalf("dial error = %v", err)
+			}
+			defer conn.Close()
+
+			clientHandshake(t, conn, "alice", d.subdomain)
+			if _, ok := cp.registry.Lookup(d.subdomain); !ok {
+				t.Errorf("%s not in registry after handshake", d.subdomain)
+			}
+		})
+	}
+
+	if err := cp.Stop(2 * time.Second); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if conn, err := net.DialTimeout("tcp", tcp.Addr().String(), time.Second); err == nil {
+		conn.Close()
+		t.Error("TCP transport still accepting after Stop")
+	}
+}
+
+func TestControlPlane_QUIC(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue("server", &x509.Certificate{
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	cp := newTestControlPlane(0, 0)
+	cp.config.TLS = common.TLSConfig{Enabled: true, CertFile: serverCert, KeyFile: serverKey}
+	serverConfig, err := cp.config.TLS.ControlTLSConfig()
+	if err != nil {
+		t.Fatalf("ControlTLSConfig() error = %v", err)
+	}
+	transport, err := ListenQUIC("127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("ListenQUIC() error = %v", err)
+	}
+	cp.ServeQUIC(transport)
+	defer cp.Stop(2 * time.Second)
+
+	clientConfig, err := (&common.ClientTLSConfig{CAFile: ca.file, ServerName: "localhost"}).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	clientConfig.NextProtos = []string{common.QUICProtocol}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, transport.Addr().String(), clientConfig, common.QUICConfig())
+	if err != nil {
+		t.Fatalf("DialAddr() error = %v", err)
+	}
+	mux := common.NewQUICSession(conn)
+	defer mux.Close()
+
+	stream, err := mux.Open()
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	sendHandshake(t, stream, "alice", "over-quic")
+	stream.Close()
+
+	entry, ok := cp.registry.Lookup("over-quic")
+	if !ok {
+		t.Fatal("over-quic not in registry after handshake")
+	}
+
+	// Proxied requests arrive as native QUIC streams with the usual header
+	serverStream, err := entry.Session.OpenStreamWithHeader(&protocol.StreamHeader{
+		Type:      protocol.StreamTypeHTTP,
+		RequestID: "req-1",
+		Subdomain: "over-quic",
+		LocalPort: 3000,
+	})
+	if err != nil {
+		t.Fatalf("OpenStreamWithHeader() error = %v", err)
+	}
+	defer serverStream.Close()
+
+	clientStream, err := mux.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer clientStream.Close()
+	header, err := protocol.ReadStreamHeader(clientStream)
+	if err != nil {
+		t.Fatalf("ReadStreamHeader() error = %v", err)
+	}
+	if header.RequestID != "req-1" || header.Subdomain != "over-quic" {
+		t.Errorf("header = %+v, want req-1 for over-quic", header)
+	}
+
+	// Closing the connection ends the session and returns its quota
+	mux.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if sessions, _ := cp.GetUserUsage("alice"); sessions == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session still active after the QUIC connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
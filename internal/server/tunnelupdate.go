@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// tunnelUpdateTimeout bounds how long a client may take to send an add or
+// remove request on a control stream.
+const tunnelUpdateTimeout = 10 * time.Second
+
+// serveTunnelUpdates accepts streams opened by the client after the
+// handshake and applies the add_tunnel and remove_tunnel requests sent on
+// them. Requests are handled one at a time so quota accounting stays in step
+// with the session's tunnels. It returns when the session closes.
+func (cp *ControlPlane) serveTunnelUpdates(session *Session) {
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		cp.handleTunnelUpdate(session, stream)
+	}
+}
+
+// handleTunnelUpdate reads one request from a control stream and answers it
+// with a tunnel_update message.
+func (cp *ControlPlane) handleTunnelUpdate(session *Session, stream net.Conn) {
+	defer stream.Close()
+
+	logger := session.Logger()
+	if err := stream.SetDeadline(time.Now().Add(tunnelUpdateTimeout)); err != nil {
+		logger.Error("failed to set deadline", slog.Any("error", err))
+		return
+	}
+
+	codec := protocol.NewCodec(stream, stream)
+	envelope, err := codec.ReadMessage()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			logger.Warn("failed to read tunnel update", slog.Any("error", err))
+		}
+		return
+	}
+
+	var resp *protocol.TunnelUpdateResponse
+	switch envelope.Type {
+	case protocol.MessageTypeAddTunnel:
+		var req protocol.AddTunnelRequest
+		if err := envelope.DecodePayload(&req); err != nil {
+			resp = tunnelUpdateError("invalid add tunnel payload", protocol.ErrorCodeProtocolError)
+			break
+		}
+		resp = cp.addSessionTunnel(session, req.Tunnel)
+
+	case protocol.MessageTypeRemoveTunnel:
+		var req protocol.RemoveTunnelRequest
+		if err := envelope.DecodePayload(&req); err != nil {
+			resp = tunnelUpdateError("invalid remove tunnel payload", protocol.ErrorCodeProtocolError)
+			break
+		}
+		resp = cp.removeSessionTunnel(session, req.Subdomain)
+
+	default:
+		logger.Warn("unexpected message type", slog.String("type", string(envelope.Type)))
+		resp = tunnelUpdateError(fmt.Sprintf("unexpected message type %q", envelope.Type), protocol.ErrorCodeProtocolError)
+	}
+
+	if err := codec.SendTunnelUpdate(envelope.RequestID, resp); err != nil {
+		logger.Warn("failed to send tunnel update", slog.Any("error", err))
+	}
+}
+
+// addSessionTunnel registers one more tunnel on a live session, subject to
+// the same per-connection and per-user limits as the handshake. Re-adding a
+// subdomain the session already serves replaces its settings.
+func (cp *ControlPlane) addSessionTunnel(session *Session, tc protocol.TunnelConfig) *protocol.TunnelUpdateResponse {
+	if err := tc.Validate(); err != nil {
+		return tunnelUpdateError(err.Error(), protocol.ErrorCodeProtocolError)
+	}
+
+	_, replacing := session.GetTunnel(strings.ToLower(tc.Subdomain))
+	if !replacing {
+		if limit := cp.config.Limits.MaxTunnelsPerConnection; limit > 0 && len(session.GetTunnels()) >= limit {
+			return tunnelUpdateError(fmt.Sprintf("maximum %d tunnels allowed", limit), protocol.ErrorCodeTunnelLimitReached)
+		}
+		if err := cp.acquireTunnelQuota(session.QuotaKey, session.Limits()); err != nil {
+			return tunnelUpdateError(err.Error(), protocol.ErrorToCode(err))
+		}
+	}
+
+	status := cp.registry.Register(session, []protocol.TunnelConfig{tc})[0]
+	if status.Status != "active" {
+		if !replacing {
+			cp.adjustQuota(session.QuotaKey, -1)
+		}
+		return &protocol.TunnelUpdateResponse{Success: false, Tunnel: status, Error: status.Error}
+	}
+
+	tc.Subdomain = status.Subdomain
+	session.RegisterTunnel(&tc)
+	session.Logger().Info("tunnel added",
+		slog.String("subdomain", status.Subdomain),
+		slog.Int("local_port", tc.LocalPort))
+	return &protocol.TunnelUpdateResponse{Success: true, Tunnel: status}
+}
+
+// removeSessionTunnel unregisters one of a live session's tunnels and
+// returns its quota slot.
+func (cp *ControlPlane) removeSessionTunnel(session *Session, subdomain string) *protocol.TunnelUpdateResponse {
+	subdomain = strings.ToLower(subdomain)
+	tc, ok := session.GetTunnel(subdomain)
+	if !ok {
+		return tunnelUpdateError(protocol.ErrTunnelNotFound.Error(), protocol.ErrorCodeProtocolError)
+	}
+
+	err := cp.registry.UnregisterTunnel(session.ID, subdomain)
+	if err != nil && !errors.Is(err, protocol.ErrTunnelNotFound) {
+		return tunnelUpdateError(err.Error(), protocol.ErrorToCode(err))
+	}
+	session.UnregisterTunnel(subdomain)
+	if err == nil {
+		// Whoever removed it from the registry releases its quota, so a
+		// concurrent admin unregister does not release it twice
+		cp.adjustQuota(session.QuotaKey, -1)
+	}
+
+	session.Logger().Info("tunnel removed", slog.String("subdomain", subdomain))
+	return &protocol.TunnelUpdateResponse{
+		Success: true,
+		Tunnel: protocol.TunnelStatus{
+			Subdomain: subdomain,
+			LocalPort: tc.LocalPort,
+			Status:    "removed",
+		},
+	}
+}
+
+// tunnelUpdateError builds a failed tunnel_update response.
+func tunnelUpdateError(message, code string) *protocol.TunnelUpdateResponse {
+	return &protocol.TunnelUpdateResponse{Success: false, Error: message, ErrorCode: code}
+}
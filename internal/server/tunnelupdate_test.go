@@ -0,0 +1,274 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"github.com/hashicorp/yamux"
+)
+
+// dialControlPlane runs a handshake for the given tunnels against cp over
+// an in-memory connection and returns the client's side of the session.
+func dialControlPlane(t *testing.T, cp *ControlPlane, token string, subdomains ...string) *yamux.Session {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	cp.wg.Add(1)
+	go cp.handleConnection(serverConn, "pipe")
+
+	return clientHandshake(t, clientConn, token, subdomains...)
+}
+
+// clientHandshake starts a yamux client session on conn and performs the
+// handshake for the given tunnels.
+func clientHandshake(t *testing.T, conn net.Conn, token string, subdomains ...string) *yamux.Session {
+	t.Helper()
+
+	mux, err := yamux.Client(conn, DefaultYamuxConfig())
+	if err != nil {
+		t.Fatalf("yamux.Client() error = %v", err)
+	}
+	t.Cleanup(func() { mux.Close() })
+
+	stream, err := mux.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer stream.Close()
+
+	sendHandshake(t, stream, token, subdomains...)
+	return mux
+}
+
+// sendHandshake performs the handshake for the given tunnels on stream.
+func sendHandshake(t *testing.T, stream net.Conn, token string, subdomains ...string) {
+	t.Helper()
+
+	if resp := handshakeResponse(t, stream, token, subdomains...); !resp.Success {
+		t.Fatalf("handshake failed: %+v", resp)
+	}
+}
+
+// handshakeResponse sends the handshake for the given tunnels on stream and
+// returns the server's response, whether or not it succeeded.
+func handshakeResponse(t *testing.T, stream net.Conn, token string, subdomains ...string) protocol.HandshakeResponse {
+	t.Helper()
+
+	req := &protocol.HandshakeRequest{Version: protocol.ProtocolVersion, Token: token, ClientID: "test"}
+	for _, subdomain := range subdomains {
+		req.Tunnels = append(req.Tunnels, protocol.TunnelConfig{Subdomain: subdomain, LocalPort: 3000, Protocol: "http"})
+	}
+	codec := protocol.NewCodec(stream, stream)
+	if err := codec.SendHandshake(req); err != nil {
+		t.Fatalf("SendHandshake() error = %v", err)
+	}
+	envelope, err := codec.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	var resp protocol.HandshakeResponse
+	if err := envelope.DecodePayload(&resp); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	return resp
+}
+
+// tryHandshake runs a handshake against cp over an in-memory connection and
+// returns the server's response.
+func tryHandshake(t *testing.T, cp *ControlPlane, token string, subdomains ...string) protocol.HandshakeResponse {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	cp.wg.Add(1)
+	go cp.handleConnection(serverConn, "pipe")
+
+	return tryClientHandshake(t, clientConn, token, subdomains...)
+}
+
+// tryClientHandshake starts a yamux client session on conn, sends the
+// handshake for the given tunnels and returns the server's response.
+func tryClientHandshake(t *testing.T, conn net.Conn, token string, subdomains ...string) protocol.HandshakeResponse {
+	t.Helper()
+
+	mux, err := yamux.Client(conn, DefaultYamuxConfig())
+	if err != nil {
+		t.Fatalf("yamux.Client() error = %v", err)
+	}
+	t.Cleanup(func() { mux.Close() })
+
+	stream, err := mux.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer stream.Close()
+
+	return handshakeResponse(t, stream, token, subdomains...)
+}
+
+// sendTunnelUpdate sends one add or remove request on a new stream.
+func sendTunnelUpdate(t *testing.T, mux *yamux.Session, msgType protocol.MessageType, payload interface{}) protocol.TunnelUpdateResponse {
+	t.Helper()
+
+	stream, err := mux.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer stream.Close()
+
+	envelope, err := protocol.NewEnvelope(msgType, "req-1", payload)
+	if err != nil {
+		t.Fatalf("NewEnvelope() error = %v", err)
+	}
+	codec := protocol.NewCodec(stream, stream)
+	if err := codec.WriteMessage(envelope); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	reply, err := codec.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if reply.Type != protocol.MessageTypeTunnelUpdate || reply.RequestID != "req-1" {
+		t.Fatalf("reply = %s/%s, want tunnel_update/req-1", reply.Type, reply.RequestID)
+	}
+	var resp protocol.TunnelUpdateResponse
+	if err := reply.DecodePayload(&resp); err != nil {
+		t.Fatalf("DecodePayload() error = %v", err)
+	}
+	return resp
+}
+
+func TestControlPlane_TunnelUpdates(t *testing.T) {
+	cp := newTestControlPlane(0, 2)
+	mux := dialControlPlane(t, cp, "alice", "alpha")
+
+	add := func(subdomain string) protocol.TunnelUpdateResponse {
+		return sendTunnelUpdate(t, mux, protocol.MessageTypeAddTunnel, &protocol.AddTunnelRequest{
+			Tunnel: protocol.TunnelConfig{Subdomain: subdomain, LocalPort: 4000, Protocol: "http"},
+		})
+	}
+
+	if resp := add("beta"); !resp.Success || resp.Tunnel.Status != "active" {
+		t.Fatalf("add beta = %+v", resp)
+	}
+	if _, ok := cp.registry.Lookup("beta"); !ok {
+		t.Error("beta not in registry after add")
+	}
+
+	// The user's two-tunnel quota is now used up
+	if resp := add("gamma"); resp.Success || resp.ErrorCode != protocol.ErrorCodeTunnelLimitReached {
+		t.Errorf("add over quota = %+v, want TUNNEL_LIMIT_REACHED", resp)
+	}
+
+	// Re-adding a served subdomain updates it without using more quota
+	if resp := add("beta"); !resp.Success {
+		t.Errorf("re-add beta = %+v", resp)
+	}
+
+	resp := sendTunnelUpdate(t, mux, protocol.MessageTypeRemoveTunnel, &protocol.RemoveTunnelRequest{Subdomain: "alpha"})
+	if !resp.Success {
+		t.Fatalf("remove alpha = %+v", resp)
+	}
+	if _, ok := cp.registry.Lookup("alpha"); ok {
+		t.Error("alpha still in registry after remove")
+	}
+	if _, tunnels := cp.GetUserUsage("alice"); tunnels != 1 {
+		t.Errorf("tunnels in use = %d, want 1", tunnels)
+	}
+
+	resp = sendTunnelUpdate(t, mux, protocol.MessageTypeRemoveTunnel, &protocol.RemoveTunnelRequest{Subdomain: "alpha"})
+	if resp.Success {
+		t.Error("removing an unknown tunnel succeeded")
+	}
+
+	// Closing the session returns everything it held
+	mux.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sessions, tunnels := cp.GetUserUsage("alice")
+		if sessions == 0 && tunnels == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("usage after close = (%d, %d), want (0, 0)", sessions, tunnels)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControlPlane_RemoveTunnelRacingAdmin(t *testing.T) {
+	cp := newTestControlPlane(0, 2)
+	mux := dialControlPlane(t, cp, "alice", "alpha", "beta")
+
+	// An admin unregister has taken alpha out of the registry and released
+	// its quota, but not yet out of the session
+	if err := cp.registry.UnregisterTunnel(sessionOf(t, cp, "alpha"), "alpha"); err != nil {
+		t.Fatalf("UnregisterTunnel() error = %v", err)
+	}
+	cp.adjustQuota("alice", -1)
+
+	resp := sendTunnelUpdate(t, mux, protocol.MessageTypeRemoveTunnel, &protocol.RemoveTunnelRequest{Subdomain: "alpha"})
+	if !resp.Success {
+		t.Fatalf("remove alpha = %+v", resp)
+	}
+	if _, tunnels := cp.GetUserUsage("alice"); tunnels != 1 {
+		t.Errorf("tunnels in use = %d, want 1 for beta", tunnels)
+	}
+}
+
+func TestControlPlane_UnregisterTunnelNotifiesClient(t *testing.T) {
+	cp := newTestControlPlane(0, 0)
+	mux := dialControlPlane(t, cp, "alice", "alpha", "beta")
+
+	// The session opens streams to the client once it is active
+	entry, _ := cp.registry.Lookup("alpha")
+	deadline := time.Now().Add(2 * time.Second)
+	for !entry.Session.IsActive() {
+		if time.Now().After(deadline) {
+			t.Fatal("session never became active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := cp.UnregisterTunnel("alpha"); err != nil {
+		t.Fatalf("UnregisterTunnel() error = %v", err)
+	}
+
+	stream, err := mux.AcceptStream()
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+
+	header, err := protocol.ReadStreamHeader(stream)
+	if err != nil {
+		t.Fatalf("ReadStreamHeader() error = %v", err)
+	}
+	if header.Type != protocol.StreamTypeControl {
+		t.Errorf("stream type = %q, want %q", header.Type, protocol.StreamTypeControl)
+	}
+	envelope, err := protocol.NewCodec(stream, stream).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	var msg protocol.TunnelClosedMessage
+	if envelope.Type != protocol.MessageTypeTunnelClosed || envelope.DecodePayload(&msg) != nil || msg.Subdomain != "alpha" {
+		t.Errorf("got %s %+v, want tunnel_closed for alpha", envelope.Type, msg)
+	}
+	if _, ok := cp.registry.Lookup("beta"); !ok {
+		t.Error("beta unregistered with alpha")
+	}
+}
+
+// sessionOf returns the ID of the session serving subdomain.
+func sessionOf(t *testing.T, cp *ControlPlane, subdomain string) string {
+	t.Helper()
+
+	entry, ok := cp.registry.Lookup(subdomain)
+	if !ok {
+		t.Fatalf("%s not in registry", subdomain)
+	}
+	return entry.Session.ID
+}
@@ -0,0 +1,115 @@
+// Package telemetry configures OpenTelemetry tracing and carries trace
+// context across the tunnel.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ShutdownFunc flushes buffered spans and releases the exporter.
+type ShutdownFunc func(context.Context) error
+
+// Setup installs the global tracer provider and W3C trace context propagator.
+// When tracing is disabled spans are not recorded, but incoming trace context
+// is still propagated. The returned function must be called on shutdown.
+func Setup(ctx context.Context, cfg *common.TracingConfig, serviceName string) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns a named tracer from the global provider.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// InjectStreamHeader stores the trace context of ctx in a stream header.
+func InjectStreamHeader(ctx context.Context, header *protocol.StreamHeader) {
+	otel.GetTextMapPropagator().Inject(ctx, streamHeaderCarrier{header})
+}
+
+// ExtractStreamHeader returns ctx with the trace context carried by a stream header.
+func ExtractStreamHeader(ctx context.Context, header *protocol.StreamHeader) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, streamHeaderCarrier{header})
+}
+
+// InjectHTTP writes the W3C trace context of ctx into HTTP headers.
+func InjectHTTP(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractHTTP returns ctx with the trace context carried by HTTP headers.
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// streamHeaderCarrier adapts a StreamHeader to propagation.TextMapCarrier.
+type streamHeaderCarrier struct {
+	header *protocol.StreamHeader
+}
+
+func (c streamHeaderCarrier) Get(key string) string {
+	switch key {
+	case "traceparent":
+		return c.header.TraceParent
+	case "tracestate":
+		return c.header.TraceState
+	}
+	return ""
+}
+
+func (c streamHeaderCarrier) Set(key, value string) {
+	switch key {
+	case "traceparent":
+		c.header.TraceParent = value
+	case "tracestate":
+		c.header.TraceState = value
+	}
+}
+
+func (c streamHeaderCarrier) Keys() []string {
+	return []string{"traceparent", "tracestate"}
+}
@@ -0,0 +1,71 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func testSpanContext(t *testing.T) trace.SpanContext {
+	t.Helper()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+func TestStreamHeaderPropagation(t *testing.T) {
+	cfg := common.DefaultTracingConfig()
+	if _, err := Setup(context.Background(), &cfg, "test"); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	sc := testSpanContext(t)
+	header := &protocol.StreamHeader{Type: protocol.StreamTypeHTTP, LocalPort: 3000}
+	InjectStreamHeader(trace.ContextWithSpanContext(context.Background(), sc), header)
+
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if header.TraceParent != want {
+		t.Errorf("TraceParent = %q, want %q", header.TraceParent, want)
+	}
+
+	got := trace.SpanContextFromContext(ExtractStreamHeader(context.Background(), header))
+	if got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() {
+		t.Errorf("extracted span context = %v, want %v", got, sc)
+	}
+}
+
+func TestHTTPPropagation(t *testing.T) {
+	cfg := common.DefaultTracingConfig()
+	if _, err := Setup(context.Background(), &cfg, "test"); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	sc := testSpanContext(t)
+	header := http.Header{}
+	InjectHTTP(trace.ContextWithSpanContext(context.Background(), sc), header)
+
+	if header.Get("Traceparent") == "" {
+		t.Fatal("traceparent header not set")
+	}
+	got := trace.SpanContextFromContext(ExtractHTTP(context.Background(), header))
+	if got.TraceID() != sc.TraceID() {
+		t.Errorf("extracted trace ID = %v, want %v", got.TraceID(), sc.TraceID())
+	}
+}
+
+func TestExtractStreamHeader_Empty(t *testing.T) {
+	ctx := ExtractStreamHeader(context.Background(), &protocol.StreamHeader{})
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("expected no span context from empty header")
+	}
+}
@@ -0,0 +1,374 @@
+// Package apiclient is a typed client for the gotunnel server's HTTP API.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API of one gotunnel server as one user.
+type Client struct {
+	// BaseURL is the server's HTTP address, e.g. https://tunnel.example.com.
+	BaseURL string
+
+	// Token authenticates requests. It may be empty for Login.
+	Token string
+
+	// HTTPClient sends requests. It defaults to a client with a 30s timeout.
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, which may also be a
+// tunnel address such as wss://tunnel.example.com/tunnel.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    BaseURL(baseURL),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// BaseURL turns a tunnel server address into the HTTP base URL of its API.
+// ws:// and wss:// become http:// and https://, a trailing /tunnel is
+// dropped, and a bare host gets https://.
+func BaseURL(server string) string {
+	base := strings.TrimSuffix(server, "/")
+	base = strings.TrimSuffix(base, "/tunnel")
+	switch {
+	case strings.HasPrefix(base, "wss://"):
+		base = "https://" + strings.TrimPrefix(base, "wss://")
+	case strings.HasPrefix(base, "ws://"):
+		base = "http://" + strings.TrimPrefix(base, "ws://")
+	case !strings.Contains(base, "://"):
+		base = "https://" + base
+	}
+	return base
+}
+
+// Error is a non-2xx API response.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// IsStatus reports whether err is an API error with the given status code.
+func IsStatus(err error, code int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
+
+// User is an account on the server.
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	IsAdmin   bool      `json:"is_admin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginResult is returned by Login.
+type LoginResult struct {
+	Token  string `json:"token"`
+	UserID string `json:"user_id"`
+}
+
+// Tunnel is a reserved subdomain and whether a client is connected to it.
+type Tunnel struct {
+	Subdomain string `json:"subdomain"`
+	Status    string `json:"status"` // "online" or "offline"
+	URL       string `json:"url"`
+
+	// ConnectedBy is the user connected to an organization subdomain.
+	ConnectedBy string `json:"connected_by,omitempty"`
+}
+
+// Online reports whether a client is connected to the tunnel.
+func (t Tunnel) Online() bool {
+	return t.Status == "online"
+}
+
+// RequestLog is a request captured by the request inspector. Bodies are
+// empty unless the caller may view them.
+type RequestLog struct {
+	ID              string    `json:"id"`
+	Subdomain       string    `json:"subdomain"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path"`
+	StatusCode      int       `json:"status_code"`
+	DurationMs      int       `json:"duration_ms"`
+	ClientIP        string    `json:"client_ip"`
+	UserAgent       string    `json:"user_agent"`
+	RequestHeaders  string    `json:"request_headers,omitempty"`
+	ResponseHeaders string    `json:"response_headers,omitempty"`
+	RequestBody     string    `json:"request_body,omitempty"`
+	ResponseBody    string    `json:"response_body,omitempty"`
+	BodyTruncated   bool      `json:"body_truncated,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Organization is a group of users sharing subdomains.
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	OwnerID   string    `json:"owner_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Member is a user's membership in an organization.
+type Member struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	UserID         string    `json:"user_id"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Invite is an invitation for an email address to join an organization.
+type Invite struct {
+	ID             string     `json:"id"`
+	OrganizationID string     `json:"organization_id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	InvitedBy      string     `json:"invited_by"`
+	Status         string     `json:"status"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	RespondedAt    *time.Time `json:"responded_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// InviteResult is returned by CreateInvite.
+type InviteResult struct {
+	Invite     Invite `json:"invite"`
+	EmailSent  bool   `json:"email_sent"`
+	EmailError string `json:"email_error,omitempty"`
+}
+
+// Register creates an account.
+func (c *Client) Register(ctx context.Context, email, password string) (*User, error) {
+	var user User
+	body := map[string]string{"email": email, "password": password}
+	if err := c.do(ctx, http.MethodPost, "/api/auth/register", body, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Login exchanges an email and password for a token. It does not change
+// c.Token.
+func (c *Client) Login(ctx context.Context, email, password string) (*LoginResult, error) {
+	var result LoginResult
+	body := map[string]string{"email": email, "password": password}
+	if err := c.do(ctx, http.MethodPost, "/api/auth/login", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Me returns the user the token belongs to.
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/api/auth/me", nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListTunnels returns the caller's reserved subdomains.
+func (c *Client) ListTunnels(ctx context.Context) ([]Tunnel, error) {
+	var tunnels []Tunnel
+	if err := c.do(ctx, http.MethodGet, "/api/tunnels", nil, &tunnels); err != nil {
+		return nil, err
+	}
+	return tunnels, nil
+}
+
+// Reserve reserves a subdomain for the caller.
+func (c *Client) Reserve(ctx context.Context, subdomain string) error {
+	return c.do(ctx, http.MethodPost, "/api/tunnels", map[string]string{"subdomain": subdomain}, nil)
+}
+
+// Release deletes a reservation.
+func (c *Client) Release(ctx context.Context, subdomain string) error {
+	return c.do(ctx, http.MethodDelete, "/api/tunnels/"+url.PathEscape(subdomain), nil, nil)
+}
+
+// TransferToUser moves a reservation to another user.
+func (c *Client) TransferToUser(ctx context.Context, subdomain, userID string) error {
+	return c.do(ctx, http.MethodPost, "/api/tunnels/"+url.PathEscape(subdomain)+"/transfer",
+		map[string]string{"user_id": userID}, nil)
+}
+
+// TransferToOrganization moves a reservation to an organization.
+func (c *Client) TransferToOrganization(ctx context.Context, subdomain, orgID string) error {
+	return c.do(ctx, http.MethodPost, "/api/tunnels/"+url.PathEscape(subdomain)+"/transfer",
+		map[string]string{"organization_id": orgID}, nil)
+}
+
+// RequestLogs returns captured requests for a subdomain, newest first. The
+// server caps limit at 100.
+func (c *Client) RequestLogs(ctx context.Context, subdomain string, limit, offset int) ([]RequestLog, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	path := "/api/requests/" + url.PathEscape(subdomain)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var result struct {
+		Logs []RequestLog `json:"logs"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Logs, nil
+}
+
+// RequestLog returns one captured request.
+func (c *Client) RequestLog(ctx context.Context, subdomain, id string) (*RequestLog, error) {
+	var log RequestLog
+	path := "/api/requests/" + url.PathEscape(subdomain) + "/" + url.PathEscape(id)
+	if err := c.do(ctx, http.MethodGet, path, nil, &log); err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+
+// ListOrganizations returns the organizations the caller belongs to.
+func (c *Client) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	var orgs []Organization
+	if err := c.do(ctx, http.MethodGet, "/api/orgs", nil, &orgs); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// CreateOrganization creates an organization owned by the caller.
+func (c *Client) CreateOrganization(ctx context.Context, name, slug string) (*Organization, error) {
+	var org Organization
+	body := map[string]string{"name": name, "slug": slug}
+	if err := c.do(ctx, http.MethodPost, "/api/orgs", body, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// OrganizationMembers lists an organization's members.
+func (c *Client) OrganizationMembers(ctx context.Context, orgID string) ([]Member, error) {
+	var members []Member
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "members"), nil, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// AddOrganizationMember adds a user to an organization with a role.
+func (c *Client) AddOrganizationMember(ctx context.Context, orgID, userID, role string) error {
+	body := map[string]string{"user_id": userID, "role": role}
+	return c.do(ctx, http.MethodPost, orgPath(orgID, "members"), body, nil)
+}
+
+// UpdateOrganizationMember changes a member's role.
+func (c *Client) UpdateOrganizationMember(ctx context.Context, orgID, userID, role string) error {
+	return c.do(ctx, http.MethodPatch, orgPath(orgID, "members")+"/"+url.PathEscape(userID),
+		map[string]string{"role": role}, nil)
+}
+
+// RemoveOrganizationMember removes a user from an organization.
+func (c *Client) RemoveOrganizationMember(ctx context.Context, orgID, userID string) error {
+	return c.do(ctx, http.MethodDelete, orgPath(orgID, "members")+"/"+url.PathEscape(userID), nil, nil)
+}
+
+// OrganizationSubdomains lists an organization's reserved subdomains.
+func (c *Client) OrganizationSubdomains(ctx context.Context, orgID string) ([]Tunnel, error) {
+	var tunnels []Tunnel
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "subdomains"), nil, &tunnels); err != nil {
+		return nil, err
+	}
+	return tunnels, nil
+}
+
+// ReserveOrganizationSubdomain reserves a subdomain for an organization.
+func (c *Client) ReserveOrganizationSubdomain(ctx context.Context, orgID, subdomain string) error {
+	return c.do(ctx, http.MethodPost, orgPath(orgID, "subdomains"), map[string]string{"subdomain": subdomain}, nil)
+}
+
+// CreateInvite invites an email address to an organization.
+func (c *Client) CreateInvite(ctx context.Context, orgID, email, role string) (*InviteResult, error) {
+	var result InviteResult
+	body := map[string]string{"email": email, "role": role}
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "invites"), body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// orgPath returns /api/orgs/{id}/{resource}.
+func orgPath(orgID, resource string) string {
+	return "/api/orgs/" + url.PathEscape(orgID) + "/" + resource
+}
+
+// do sends a JSON request and decodes a JSON response into out, if non-nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package apiclient
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/server"
+)
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"wss://tunnel.example.com/tunnel", "https://tunnel.example.com"},
+		{"ws://localhost:8080/tunnel/", "http://localhost:8080"},
+		{"https://tunnel.example.com/", "https://tunnel.example.com"},
+		{"tunnel.example.com", "https://tunnel.example.com"},
+	}
+	for _, tt := range tests {
+		if got := BaseURL(tt.server); got != tt.want {
+			t.Errorf("BaseURL(%q) = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
+
+// newTestServer serves the real API backed by a temporary database.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	cfg := common.DefaultServerConfig()
+	cfg.Domain = "example.com"
+	cfg.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	srv, err := server.NewServer(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	ts := httptest.NewServer(srv.UnifiedHandler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClient(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	api := New(ts.URL, "")
+
+	if _, err := api.Register(ctx, "alice@example.com", "secret123"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := api.Login(ctx, "alice@example.com", "wrong"); !IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Login() with wrong password error = %v, want 401", err)
+	}
+	login, err := api.Login(ctx, "alice@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	api.Token = login.Token
+
+	me, err := api.Me(ctx)
+	if err != nil || me.Email != "alice@example.com" {
+		t.Fatalf("Me() = %+v, %v, want alice", me, err)
+	}
+
+	if err := api.Reserve(ctx, "www"); !IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Reserve(www) error = %v, want 400", err)
+	}
+	if err := api.Reserve(ctx, "myapp"); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	tunnels, err := api.ListTunnels(ctx)
+	if err != nil {
+		t.Fatalf("ListTunnels() error = %v", err)
+	}
+	if len(tunnels) != 1 || tunnels[0].Subdomain != "myapp" || tunnels[0].Online() || tunnels[0].URL == "" {
+		t.Errorf("ListTunnels() = %+v, want offline myapp", tunnels)
+	}
+	if logs, err := api.RequestLogs(ctx, "myapp", 10, 0); err != nil || len(logs) != 0 {
+		t.Errorf("RequestLogs() = %v, %v, want none", logs, err)
+	}
+
+	org, err := api.CreateOrganization(ctx, "Acme", "acme")
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	if orgs, err := api.ListOrganizations(ctx); err != nil || len(orgs) != 1 || orgs[0].ID != org.ID {
+		t.Errorf("ListOrganizations() = %+v, %v, want acme", orgs, err)
+	}
+	if err := api.TransferToOrganization(ctx, "myapp", org.ID); err != nil {
+		t.Fatalf("TransferToOrganization() error = %v", err)
+	}
+	subdomains, err := api.OrganizationSubdomains(ctx, org.ID)
+	if err != nil || len(subdomains) != 1 || subdomains[0].Subdomain != "myapp" {
+		t.Errorf("OrganizationSubdomains() = %+v, %v, want myapp", subdomains, err)
+	}
+	members, err := api.OrganizationMembers(ctx, org.ID)
+	if err != nil || len(members) != 1 || members[0].UserID != me.ID {
+		t.Errorf("OrganizationMembers() = %+v, %v, want alice", members, err)
+	}
+
+	if err := api.Release(ctx, "myapp"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err := api.Release(ctx, "myapp"); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("second Release() error = %v, want 404", err)
+	}
+}
@@ -0,0 +1,240 @@
+// Package gotunnel embeds a tunnel in a Go program. Listen connects to a
+// gotunnel server and returns a net.Listener for the tunnel's public URL,
+// so an http.Server (or any other net.Listener consumer) can serve tunnel
+// traffic in-process without binding a local port:
+//
+//	ln, err := gotunnel.Listen(ctx, gotunnel.Options{
+//		ServerAddr: "wss://tunnel.example.com",
+//		Token:      os.Getenv("GOTUNNEL_TOKEN"),
+//		Subdomain:  "myapp",
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Println("serving on", ln.URL())
+//	http.Serve(ln, handler)
+package gotunnel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/anyhost/gotunnel/internal/client"
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/protocol"
+)
+
+// placeholderPort is registered as the tunnel's local port, which the
+// server requires. Streams are handed to the listener, never dialed.
+const placeholderPort = 80
+
+// Options configures a tunnel listener.
+type Options struct {
+	// ServerAddr is the tunnel server: host:port for raw TCP,
+	// tls://host:port, quic://host:port, or an http(s):// or ws(s):// URL
+	// for WebSocket.
+	ServerAddr string
+
+	// Token authenticates with the server.
+	Token string
+
+	// Subdomain is the subdomain to serve. A random one is used if empty.
+	Subdomain string
+
+	// Protocol is "http" (the default) or "tcp".
+	Protocol string
+
+	// ClientID identifies this client in server logs.
+	ClientID string
+
+	// MaxRequestBodySize optionally lowers the server's request body limit
+	// for this tunnel. Zero uses the server default.
+	MaxRequestBodySize int64
+
+	// DisableReconnect leaves the tunnel down if the connection drops,
+	// rather than reconnecting with backoff.
+	DisableReconnect bool
+
+	// Logger receives client logs. It defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Listener accepts connections forwarded by the tunnel server. Its Addr is
+// the tunnel's public URL.
+type Listener struct {
+	tunnel *client.Tunnel
+	url    string
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Listen connects to the server and registers the tunnel. ctx bounds the
+// connection attempt; once Listen returns, the tunnel runs until Close.
+func Listen(ctx context.Context, opts Options) (*Listener, error) {
+	if opts.Subdomain == "" {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate subdomain: %w", err)
+		}
+		opts.Subdomain = "go-" + hex.EncodeToString(b)
+	}
+
+	cfg := common.DefaultClientConfig()
+	cfg.ServerAddr = opts.ServerAddr
+	cfg.Token = opts.Token
+	cfg.ClientID = opts.ClientID
+	cfg.Reconnect.Enabled = !opts.DisableReconnect
+	cfg.Tunnels = []protocol.TunnelConfig{{
+		Subdomain:          opts.Subdomain,
+		LocalPort:          placeholderPort,
+		Protocol:           opts.Protocol,
+		MaxRequestBodySize: opts.MaxRequestBodySize,
+	}}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	tunnel, err := client.NewTunnel(cfg, opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tunnel: %w", err)
+	}
+
+	l := &Listener{
+		tunnel: tunnel,
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+	tunnel.SetStreamHandler(l.handleStream)
+
+	connected := make(chan error, 1)
+	go func() { connected <- tunnel.Connect() }()
+	select {
+	case err = <-connected:
+	case <-ctx.Done():
+		// Connect has its own timeouts; clean up once it gives up
+		go func() {
+			<-connected
+			tunnel.Close()
+		}()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+
+	for _, status := range tunnel.GetTunnelStatus() {
+		if status.Status != "active" {
+			tunnel.Close()
+			return nil, fmt.Errorf("tunnel %s rejected: %s", status.Subdomain, status.Error)
+		}
+		l.url = status.URL
+	}
+
+	if err := tunnel.Start(); err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// handleStream queues a stream for Accept.
+func (l *Listener) handleStream(stream net.Conn, header *protocol.StreamHeader) {
+	conn := &Conn{Conn: stream, requestID: header.RequestID, host: header.Host, remoteAddr: header.RemoteAddr}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		stream.Close()
+	}
+}
+
+// Accept waits for the next connection through the tunnel. It returns
+// net.ErrClosed once the listener is closed.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close disconnects from the server. Connections already accepted are not
+// closed.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.closeErr = l.tunnel.Close()
+	})
+	return l.closeErr
+}
+
+// Addr returns the tunnel's public URL as a net.Addr.
+func (l *Listener) Addr() net.Addr {
+	return Addr{network: "gotunnel", address: l.url}
+}
+
+// URL returns the tunnel's public URL, e.g. https://myapp.tunnel.example.com.
+func (l *Listener) URL() string {
+	return l.url
+}
+
+// Connected reports whether the tunnel currently has a session with the
+// server. Accept keeps blocking while it reconnects.
+func (l *Listener) Connected() bool {
+	return l.tunnel.State() == client.TunnelStateConnected
+}
+
+// Conn is a connection forwarded through the tunnel.
+type Conn struct {
+	net.Conn
+
+	requestID  string
+	host       string
+	remoteAddr string
+}
+
+// RemoteAddr returns the address of the client that connected to the
+// public URL, when the server reports it.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.remoteAddr == "" {
+		return c.Conn.RemoteAddr()
+	}
+	return Addr{network: "tcp", address: c.remoteAddr}
+}
+
+// RequestID returns the server's identifier for this connection, which
+// also appears in its logs and request inspector.
+func (c *Conn) RequestID() string {
+	return c.requestID
+}
+
+// Host returns the Host header of the request that opened an HTTP
+// connection.
+func (c *Conn) Host() string {
+	return c.host
+}
+
+// Addr is a tunnel address.
+type Addr struct {
+	network string
+	address string
+}
+
+// Network implements net.Addr.
+func (a Addr) Network() string {
+	return a.network
+}
+
+// String implements net.Addr.
+func (a Addr) String() string {
+	return a.address
+}
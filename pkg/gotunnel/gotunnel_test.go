@@ -0,0 +1,107 @@
+package gotunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anyhost/gotunnel/internal/common"
+	"github.com/anyhost/gotunnel/internal/server"
+)
+
+// newTestServer serves the real tunnel server with authentication off.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	cfg := common.DefaultServerConfig()
+	cfg.Domain = "example.com"
+	cfg.Auth.Mode = "none"
+	cfg.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	srv, err := server.NewServer(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	ts := httptest.NewServer(srv.UnifiedHandler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestListen(t *testing.T) {
+	ts := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := Listen(ctx, Options{
+		ServerAddr: ts.URL,
+		Token:      "alice",
+		Subdomain:  "embedded",
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	if !strings.HasPrefix(ln.URL(), "http") || !strings.Contains(ln.URL(), "embedded.example.com") {
+		t.Errorf("URL() = %q, want the embedded.example.com URL", ln.URL())
+	}
+	if ln.Addr().String() != ln.URL() {
+		t.Errorf("Addr() = %q, want %q", ln.Addr(), ln.URL())
+	}
+
+	remoteAddrs := make(chan string, 1)
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddrs <- r.RemoteAddr
+		fmt.Fprintf(w, "hello from %s", r.URL.Path)
+	}))
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/greet", nil)
+	req.Host = "embedded.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request through tunnel error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello from /greet" {
+		t.Errorf("response = %d %q, want 200 %q", resp.StatusCode, body, "hello from /greet")
+	}
+	if addr := <-remoteAddrs; !strings.HasPrefix(addr, "127.0.0.1") {
+		t.Errorf("RemoteAddr = %q, want the original client's address", addr)
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() after Close error = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestListen_Rejected(t *testing.T) {
+	ts := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := Listen(ctx, Options{
+		ServerAddr:       ts.URL,
+		Token:            "alice",
+		Subdomain:        "www",
+		DisableReconnect: true,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err == nil || !strings.Contains(err.Error(), "www") {
+		t.Errorf("Listen() with a reserved subdomain error = %v, want a rejection naming www", err)
+	}
+}